@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"udptunnel/tunnel"
 )
 
 // ===============================
@@ -27,6 +40,9 @@ func printUsage() {
 	fmt.Println("  UDP服务端: -mode=server -protocol=udp -local=:9090 -remote=127.0.0.1:53")
 	fmt.Println("  TCP客户端: -mode=client -protocol=tcp -local=:8080 -remote=server.example.com:9090")
 	fmt.Println("  TCP服务端: -mode=server -protocol=tcp -local=:9090 -remote=127.0.0.1:22")
+	fmt.Println("  HTTP代理客户端: -mode=client -protocol=tcp -http-proxy -local=:3128 -remote=server.example.com:9090")
+	fmt.Println("  HTTP代理服务端: -mode=server -protocol=tcp -dynamic-target -local=:9090 -remote=127.0.0.1:80")
+	fmt.Println("  透明代理客户端: -mode=client -protocol=udp -tproxy -local=:15353 -remote=server.example.com:9090")
 	fmt.Println()
 	fmt.Println("功能说明:")
 	fmt.Println("  UDP隧道:")
@@ -35,6 +51,100 @@ func printUsage() {
 	fmt.Println("  TCP隧道:")
 	fmt.Println("    - 客户端: 监听本地 TCP 端口，将连接通过 TCP 转发到服务端")
 	fmt.Println("    - 服务端: 接收 TCP 连接，将连接转发到目标 TCP 服务")
+	fmt.Println("    - HTTP代理: 客户端接受 CONNECT 和绝对 URI 请求，由服务端连接请求中的目标")
+	fmt.Println("  透明代理（仅 Linux）:")
+	fmt.Println("    - -tproxy: 客户端以 IP_TRANSPARENT 监听 TPROXY 规则拦截的流量，把原始目标地址发给服务端")
+	fmt.Println("    - 服务端需使用 -dynamic-target 允许客户端指定目标")
+	fmt.Println("  Unix 域套接字:")
+	fmt.Println("    - -local/-remote 可使用 unix://<路径>（流式）或 unixgram://<路径>（数据报）")
+	fmt.Println("    - UDP隧道的本地监听和目标可使用 unixgram://，隧道连接和 TCP 隧道两端可使用 unix://")
+	fmt.Println("    - -socket-mode=0660 -socket-owner=用户:组: 设置创建的套接字文件权限")
+	fmt.Println("  隧道传输层:")
+	fmt.Println("    - -transport=tcp|tls|udp: 客户端与服务端之间使用的传输（默认 tcp）")
+	fmt.Println("    - -tls-cert/-tls-key: 本端证书（TLS 服务端必需）；-tls-ca: 校验对端证书的 CA")
+	fmt.Println("    - udp 传输默认启用可靠模式（选择重传、拥塞控制）；-udp-unreliable 关闭，仅适用于UDP隧道")
+	fmt.Println("    - -udp-keepalive/-udp-timeout: 保活间隔和对端超时；-udp-loss: 模拟发送丢包率（测试用）")
+	fmt.Println("  前向纠错（UDP隧道）:")
+	fmt.Println("    - -fec=K,M: 客户端每 K 个数据报附加 M 个 Reed-Solomon 校验分片，服务端自动跟随")
+	fmt.Println("    - -fec-flush=20ms: 分组未满时发送校验分片前的最长等待时间")
+	fmt.Println("    - 适合与 -transport=udp -udp-unreliable 配合，用带宽换取比重传更低的延迟")
+	fmt.Println("  多路径绑定（UDP隧道）:")
+	fmt.Println("    - -bond=<地址>[@<源IP>],...: 客户端除 -remote 外再经这些路径连接服务端，组成一个会话")
+	fmt.Println("    - -bond-mode=redundant|aggregate: 每个数据报在所有路径发送，或轮流分配到各路径（默认 redundant）")
+	fmt.Println("  压缩:")
+	fmt.Println("    - -compress=deflate: 客户端按顺序提供压缩算法；服务端限制可接受的算法（默认接受全部）")
+	fmt.Println("    - -compress-min=128: 小于该长度的数据报或数据段不压缩")
+	fmt.Println("  限速（作用于本端发往隧道的流量，UDP 超限丢弃，TCP 超限延迟）:")
+	fmt.Println("    - -limit-tunnel/-limit-session/-limit-ip=<字节/秒>[,<包/秒>]: 整个隧道、每个会话、每个来源 IP 的限制")
+	fmt.Println("    - 字节速率可带 K/M/G 后缀，例如 -limit-session=1M,500")
+	fmt.Println("  流量配额（服务端，按 TLS 客户端证书身份统计）:")
+	fmt.Println("    - -quota=<每日>[,<每月>]: 每个用户两个方向合计的流量配额，例如 -quota=10G,200G")
+	fmt.Println("    - -quota-file=<路径>: 保存用户流量计数的 JSON 文件，重启后继续累计")
+	fmt.Println("    - -quota 和 -quota-file 需要 -transport=tls -tls-ca=<CA> 校验客户端证书，否则无法识别用户")
+	fmt.Println("    - -admin=127.0.0.1:9100: 管理接口，GET /usage[?user=名称] 返回用户流量使用情况")
+	fmt.Println("  访问控制（客户端检查本地 UDP/TCP 对端，服务端检查隧道连接对端）:")
+	fmt.Println("    - -allow=<CIDR,...>: 只接受来自这些网段的对端；-deny=<CIDR,...>: 拒绝来自这些网段的对端")
+	fmt.Println("    - -acl-file=<路径>: 规则文件，每行 allow <CIDR> 或 deny <CIDR>，收到 SIGHUP 时重新加载")
+	fmt.Println("    - 启用 -admin 时 GET /acl 返回被拒绝的对端及次数")
+	fmt.Println("  连接数限制（监听端，UDP客户端按本地会话计）:")
+	fmt.Println("    - -max-conns=<N>/-max-conns-ip=<N>: 全局和每个来源 IP 的并发连接数上限")
+	fmt.Println("    - -accept-rate=<N>: 每秒最多接受 N 个新连接")
+	fmt.Println("    - -accept-queue=5s: 超过全局上限或速率时暂停接受并最多等待该时间，默认立即拒绝")
+	fmt.Println("  保活与往返时延:")
+	fmt.Println("    - -keepalive=10s: 客户端请求控制帧并按该间隔在每条隧道连接上发送 PING；服务端设置后也主动发送")
+	fmt.Println("    - -keepalive-timeout=30s: 等待对端的帧超过该时间时关闭连接，默认 3 倍保活间隔")
+	fmt.Println("    - 启用 -admin 时 GET /sessions 返回会话列表（含往返时延），GET /metrics 返回往返时延直方图")
+	fmt.Println("  控制帧:")
+	fmt.Println("    - 客户端默认请求控制帧，服务端关闭会话时通过 CLOSE 帧告知原因（配额、目标不可达、服务端关闭等）")
+	fmt.Println("    - -control=false: 连接不支持控制帧的旧版本服务端时关闭")
+	fmt.Println("    - -strict-frames: 收到未知类型的帧时以协议错误关闭会话，默认忽略")
+	fmt.Println("  平滑重启（服务端）:")
+	fmt.Println("    - -drain-timeout=30s: 收到 SIGINT/SIGTERM 后停止接受连接，向客户端发送 GOAWAY，最多等待该时间再关闭会话")
+	fmt.Println("    - -goaway-addr=<地址>: GOAWAY 中告知客户端的备用服务端地址，新会话改为连接该地址")
+	fmt.Println("    - 客户端收到 GOAWAY 后 UDP 会话迁移到新的隧道连接，TCP 会话在原连接上继续直到结束")
+	fmt.Println("  多路复用（TCP隧道）:")
+	fmt.Println("    - -mux: 客户端把本地连接作为流复用到共享的隧道连接上，每个流独立流量控制，慢速连接不影响其他连接")
+	fmt.Println("    - -mux-window=262144: 每个流的接收窗口（字节），两端各自设置")
+	fmt.Println("    - -mux-streams=256: 每条隧道连接的流数上限，客户端达到上限时建立新的隧道连接，服务端拒绝超出的流")
+	fmt.Println("  预建连接池（TCP隧道客户端）:")
+	fmt.Println("    - -pool=<N>: 后台保持 N 条已建立（含 TLS 握手）的空闲隧道连接，新的本地连接直接取用")
+	fmt.Println("    - -pool-idle=8s: 空闲连接的最长保留时间，不能超过 9s（服务端等待会话头 10 秒）")
+	fmt.Println("    - -pool-rate=10: 每秒最多新建的空闲连接数")
+	fmt.Println("    - -pool-check=1s: 检查空闲连接是否已被服务端关闭的间隔")
+	fmt.Println("  会话超时（TCP隧道）:")
+	fmt.Println("    - -idle-timeout=5m: 两个方向都没有数据超过该时间时关闭会话")
+	fmt.Println("    - -linger=30s: 一个方向结束（半关闭）后，另一个方向最多继续传输的时间")
+	fmt.Println("    - -max-lifetime=24h: 会话的最长存活时间")
+	fmt.Println("    - 超时关闭的原因记录在日志、OnSessionClose 和 /metrics 的 tunnel_sessions_closed_total 中")
+	fmt.Println("  套接字选项（隧道连接、本地监听和目标连接）:")
+	fmt.Println("    - -tcp-keepalive=30s -tcp-keepintvl=10s -tcp-keepcnt=3: TCP 保活，约 60 秒发现对端消失")
+	fmt.Println("    - -nagle: 启用 Nagle 算法，默认设置 TCP_NODELAY 以降低延迟")
+	fmt.Println("    - -sndbuf=4194304 -rcvbuf=4194304: 高带宽时延积链路上增大套接字缓冲区")
+	fmt.Println("    - -mark=100: 设置 SO_MARK 以配合 ip rule 做策略路由（Linux，需要 CAP_NET_ADMIN）")
+	fmt.Println("    - -dscp=EF: 为发出的数据包设置 DSCP，用于 QoS 标记")
+	fmt.Println("  源地址绑定（客户端的隧道连接，服务端的目标连接）:")
+	fmt.Println("    - -bind=203.0.113.10: 固定出站连接的源 IP，多出口主机上选择上行链路")
+	fmt.Println("    - -bind-ports=40000-40999: 源端口从范围内选择，被占用时换下一个")
+	fmt.Println("    - -bind-dev=eth1: 通过 SO_BINDTODEVICE 从指定网络接口发出（Linux）")
+	fmt.Println("  地址族（IPv4/IPv6）:")
+	fmt.Println("    - -local=[::1]:8080 -remote=[fe80::2%<接口>]:9090: IPv6 地址写在方括号中，链路本地地址带接口名")
+	fmt.Println("    - -local-family=4|6|dual: 本地只监听 IPv4、只监听 IPv6，或显式使用双栈套接字（需要通配地址）")
+	fmt.Println("    - -remote-family=4|6|prefer-4|prefer-6: 连接远程端点时只用或优先某个地址族")
+	fmt.Println("    - -fallback-delay=250ms: 主机名有多个地址时按 Happy Eyeballs 交替尝试 IPv6 和 IPv4")
+	fmt.Println("  DNS 重新解析（客户端的服务端地址，服务端的目标地址）:")
+	fmt.Println("    - -dns=system: 系统解析器的结果缓存 -dns-ttl（默认 30s），过期后重新解析")
+	fmt.Println("    - -dns=10.0.0.53: 向指定的 DNS 服务器查询，按记录的 TTL 缓存，限制在 -dns-min-ttl 和 -dns-max-ttl 之间")
+	fmt.Println("    - 地址变化后 UDP 会话迁移到新地址，TCP 隧道只有新会话使用新地址")
+	fmt.Println("  PROXY 协议:")
+	fmt.Println("    - -proxy-protocol=v1|v2: TCP服务端向目标发送携带原始客户端地址的 PROXY 头")
+	fmt.Println("    - -accept-proxy-protocol: 监听端位于 HAProxy 或负载均衡之后时解析 PROXY 头")
+	fmt.Println("    - 服务端的 -accept-proxy-protocol 仅适用于 -transport=tcp，不能与 tls 或 udp 传输同时使用")
+	fmt.Println("  原始 UDP 客户端地址（UDP服务端）:")
+	fmt.Println("    - -origin-allow=<CIDR,...>: 仅允许来自指定网段的原始客户端")
+	fmt.Println("    - -origin-rate=<N>: 每个原始客户端 IP 每秒最多转发 N 个数据包")
+	fmt.Println("    - -origin-header: 向目标发送的数据报前附加来源地址头")
+	fmt.Println("    - 原始客户端地址由隧道客户端提供，服务端无法验证；-origin-allow/-origin-rate 只应与可信的客户端")
+	fmt.Println("      一起使用（例如 -transport=tls -tls-ca 校验客户端证书），否则客户端可以伪造地址绕过限制")
 }
 
 // validateArgs 验证命令行参数
@@ -66,6 +176,78 @@ func main() {
 		protocol   = flag.String("protocol", "udp", "协议类型: udp 或 tcp (默认: udp)")
 		localAddr  = flag.String("local", "", "本地地址")
 		remoteAddr = flag.String("remote", "", "远程地址")
+		httpProxy  = flag.Bool("http-proxy", false, "TCP客户端作为 HTTP 代理运行（支持 CONNECT）")
+		dynamic    = flag.Bool("dynamic-target", false, "服务端允许客户端指定目标地址（HTTP 代理、透明代理）")
+		tproxy     = flag.Bool("tproxy", false, "客户端透明代理模式（Linux TPROXY）")
+		proxyOut   = flag.String("proxy-protocol", "", "TCP服务端向目标发送的 PROXY 协议版本: v1 或 v2")
+		proxyIn    = flag.Bool("accept-proxy-protocol", false, "解析 TCP 监听端收到的 PROXY 协议头")
+		originACL  = flag.String("origin-allow", "", "UDP服务端允许的原始客户端网段（逗号分隔的 CIDR）")
+		originRate = flag.Float64("origin-rate", 0, "UDP服务端每个原始客户端 IP 每秒允许的数据包数（0 表示不限制）")
+		originHdr  = flag.Bool("origin-header", false, "UDP服务端向目标发送的数据报前附加来源地址头")
+		transport  = flag.String("transport", "tcp", "隧道传输层: tcp、tls 或 udp")
+		tlsCert    = flag.String("tls-cert", "", "TLS 证书文件")
+		tlsKey     = flag.String("tls-key", "", "TLS 私钥文件")
+		tlsCA      = flag.String("tls-ca", "", "校验对端证书的 CA 文件")
+		tlsSkip    = flag.Bool("tls-insecure", false, "TLS 客户端不校验服务端证书（仅用于测试）")
+		udpUnrel   = flag.Bool("udp-unreliable", false, "udp 传输不重传丢失的数据帧（仅适用于UDP隧道）")
+		udpKeep    = flag.Duration("udp-keepalive", 0, "udp 传输空闲时的保活间隔（默认 5s）")
+		udpIdle    = flag.Duration("udp-timeout", 0, "udp 传输多久收不到对端数据视为断开（默认 30s）")
+		udpLoss    = flag.Float64("udp-loss", 0, "udp 传输随机丢弃发送数据包的比例 0~1（仅用于测试）")
+		fecShards  = flag.String("fec", "", "UDP客户端前向纠错分片数: 数据分片数,校验分片数（如 10,3）")
+		fecFlush   = flag.Duration("fec-flush", 0, "前向纠错分组刷新超时（默认 20ms）")
+		bondPaths  = flag.String("bond", "", "UDP客户端多路径绑定的附加路径: 地址[@源IP]，逗号分隔")
+		bondMode   = flag.String("bond-mode", "redundant", "多路径绑定模式: redundant 或 aggregate")
+		compress   = flag.String("compress", "", "压缩算法（逗号分隔）: 客户端提供的算法，服务端接受的算法")
+		compMin    = flag.Int("compress-min", 0, "小于该字节数的帧不压缩（默认 128）")
+		limitAll   = flag.String("limit-tunnel", "", "整个隧道的限速: 字节/秒[,包/秒]")
+		limitSess  = flag.String("limit-session", "", "每个会话的限速: 字节/秒[,包/秒]")
+		limitIP    = flag.String("limit-ip", "", "每个来源 IP 的限速: 字节/秒[,包/秒]")
+		quotaSpec  = flag.String("quota", "", "服务端每个用户的流量配额: 每日[,每月]")
+		quotaFile  = flag.String("quota-file", "", "服务端保存用户流量计数的文件")
+		adminAddr  = flag.String("admin", "", "管理接口监听地址")
+		aclAllow   = flag.String("allow", "", "监听端只接受的对端网段（逗号分隔的 CIDR）")
+		aclDeny    = flag.String("deny", "", "监听端拒绝的对端网段（逗号分隔的 CIDR）")
+		aclFile    = flag.String("acl-file", "", "访问控制规则文件（SIGHUP 时重新加载）")
+		maxConns   = flag.Int("max-conns", 0, "并发连接数上限（0 表示不限制）")
+		maxConnsIP = flag.Int("max-conns-ip", 0, "每个来源 IP 的并发连接数上限（0 表示不限制）")
+		acceptRate = flag.Float64("accept-rate", 0, "每秒接受的新连接数上限（0 表示不限制）")
+		acceptWait = flag.Duration("accept-queue", 0, "超过连接数限制时等待名额的最长时间（0 表示立即拒绝）")
+		keepIntvl  = flag.Duration("keepalive", 0, "隧道连接的 PING 间隔（0 表示不启用）")
+		keepTmo    = flag.Duration("keepalive-timeout", 0, "等待对端的帧超过该时间时关闭连接（0 表示 3 倍保活间隔）")
+		ctrlOn     = flag.Bool("control", true, "客户端请求控制帧（服务端可告知会话关闭原因）")
+		ctrlStrict = flag.Bool("strict-frames", false, "收到未知类型的帧时关闭会话")
+		drainTmo   = flag.Duration("drain-timeout", 0, "服务端关闭时发送 GOAWAY 后等待会话结束的最长时间（0 表示立即关闭）")
+		goawayAddr = flag.String("goaway-addr", "", "GOAWAY 中告知客户端的备用服务端地址")
+		muxOn      = flag.Bool("mux", false, "TCP隧道客户端把本地连接复用到共享的隧道连接上")
+		muxWindow  = flag.Int("mux-window", 0, "多路复用每个流的接收窗口字节数（默认 256KB）")
+		muxMax     = flag.Int("mux-streams", 0, "多路复用每条隧道连接的流数上限（默认 256）")
+		poolSize   = flag.Int("pool", 0, "TCP隧道客户端保持的空闲隧道连接数（0 表示不启用）")
+		poolIdle   = flag.Duration("pool-idle", 0, "空闲隧道连接的最长保留时间（默认 8s）")
+		poolRate   = flag.Float64("pool-rate", 0, "每秒最多新建的空闲隧道连接数（默认 10）")
+		poolCheck  = flag.Duration("pool-check", 0, "检查空闲隧道连接的间隔（默认 1s）")
+		idleTmo    = flag.Duration("idle-timeout", 0, "TCP隧道会话两个方向都没有数据时的超时（0 表示不限制）")
+		lingerTmo  = flag.Duration("linger", 0, "TCP隧道会话一个方向结束后另一个方向的最长等待时间（0 表示不限制）")
+		maxLife    = flag.Duration("max-lifetime", 0, "TCP隧道会话的最长存活时间（0 表示不限制）")
+		tcpKeep    = flag.Duration("tcp-keepalive", 0, "TCP 保活空闲时间（0 表示默认 15s，负数表示关闭）")
+		tcpKeepInt = flag.Duration("tcp-keepintvl", 0, "TCP 保活探测间隔（仅 Linux，默认与 -tcp-keepalive 相同）")
+		tcpKeepCnt = flag.Int("tcp-keepcnt", 0, "TCP 保活探测无应答多少次后断开（仅 Linux，0 表示系统默认）")
+		nagle      = flag.Bool("nagle", false, "启用 Nagle 算法（默认设置 TCP_NODELAY）")
+		sndBuf     = flag.Int("sndbuf", 0, "套接字发送缓冲区字节数 SO_SNDBUF（0 表示系统默认）")
+		rcvBuf     = flag.Int("rcvbuf", 0, "套接字接收缓冲区字节数 SO_RCVBUF（0 表示系统默认）")
+		sockMark   = flag.Int("mark", 0, "套接字防火墙标记 SO_MARK（仅 Linux，0 表示不设置）")
+		dscpValue  = flag.String("dscp", "", "发出数据包的 DSCP 值：0~63 或 EF、AF11~AF43、CS0~CS7")
+		bindAddr   = flag.String("bind", "", "出站连接的源 IP（客户端为隧道连接，服务端为目标连接）")
+		bindPorts  = flag.String("bind-ports", "", "出站连接的源端口范围，如 40000-40999")
+		bindDev    = flag.String("bind-dev", "", "出站连接绑定的网络接口（SO_BINDTODEVICE，仅 Linux）")
+		localFam   = flag.String("local-family", "", "本地监听的地址族: any、4、6 或 dual（双栈）")
+		remoteFam  = flag.String("remote-family", "", "连接远程端点的地址族: any、4、6、prefer-4 或 prefer-6")
+		fbDelay    = flag.Duration("fallback-delay", 0, "Happy Eyeballs 发起下一次连接尝试前的等待时间（默认 250ms，负数表示依次尝试）")
+		dnsServer  = flag.String("dns", "", "按 TTL 重新解析远程主机名: system 或 DNS 服务器地址（默认端口 53），为空时每次拨号解析")
+		dnsTTL     = flag.Duration("dns-ttl", 0, "系统解析器结果的缓存时间（默认 30s）")
+		dnsMinTTL  = flag.Duration("dns-min-ttl", 0, "DNS 缓存时间下限（默认 5s）")
+		dnsMaxTTL  = flag.Duration("dns-max-ttl", 0, "DNS 缓存时间上限（默认 1h）")
+		sockMode   = flag.String("socket-mode", "", "创建的 Unix 套接字文件权限（八进制，如 0660）")
+		sockOwner  = flag.String("socket-owner", "", "创建的 Unix 套接字文件属主（用户:组）")
 		help       = flag.Bool("help", false, "显示帮助信息")
 	)
 	flag.Parse()
@@ -81,22 +263,545 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *tproxy && *mode != "client" {
+		fmt.Printf("参数错误: -tproxy 仅适用于客户端模式\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	proxyVersion, err := tunnel.ParseProxyProtocolVersion(*proxyOut)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	originAllow, err := tunnel.ParsePrefixList(*originACL)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	if *transport == "udp" && *udpUnrel && *protocol == "tcp" {
+		fmt.Printf("参数错误: -udp-unreliable 不能用于TCP隧道\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	// TLS 监听端在解析 PROXY 头之前就开始握手，UDP 传输的连接不经过负载均衡
+	if *proxyIn && *mode == "server" && *transport != "tcp" {
+		fmt.Printf("参数错误: 服务端的 -accept-proxy-protocol 仅适用于 -transport=tcp\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	if *muxOn && *protocol != "tcp" {
+		fmt.Printf("参数错误: -mux 仅适用于TCP隧道\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	if *poolSize > 0 && (*protocol != "tcp" || *mode != "client") {
+		fmt.Printf("参数错误: -pool 仅适用于TCP隧道客户端\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	if *poolIdle < 0 || *poolIdle > tunnel.MaxPoolIdle {
+		fmt.Printf("参数错误: -pool-idle 应在 0 到 %s 之间，服务端等待会话头 10 秒后不再接受会话头\n\n", tunnel.MaxPoolIdle)
+		printUsage()
+		os.Exit(1)
+	}
+
+	if (*idleTmo > 0 || *lingerTmo > 0 || *maxLife > 0) && *protocol != "tcp" {
+		fmt.Printf("参数错误: -idle-timeout、-linger 和 -max-lifetime 仅适用于TCP隧道\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	if *muxWindow < 0 || *muxWindow > 1<<30 || *muxMax < 0 {
+		fmt.Printf("参数错误: -mux-window 应在 0 到 %d 之间，-mux-streams 不能为负数\n\n", 1<<30)
+		printUsage()
+		os.Exit(1)
+	}
+
+	dscp, err := tunnel.ParseDSCP(*dscpValue)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	if *sndBuf < 0 || *rcvBuf < 0 || *tcpKeepInt < 0 || *tcpKeepCnt < 0 {
+		fmt.Printf("参数错误: -sndbuf、-rcvbuf、-tcp-keepintvl 和 -tcp-keepcnt 不能为负数\n\n")
+		printUsage()
+		os.Exit(1)
+	}
+
+	socket := tunnel.SocketOptions{
+		KeepAlive:         *tcpKeep,
+		KeepAliveInterval: *tcpKeepInt,
+		KeepAliveCount:    *tcpKeepCnt,
+		Nagle:             *nagle,
+		SendBuffer:        *sndBuf,
+		ReceiveBuffer:     *rcvBuf,
+		Mark:              *sockMark,
+		DSCP:              dscp,
+	}
+
+	bind, err := tunnel.ParseBindOptions(*bindAddr, *bindPorts, *bindDev)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	family, err := parseFamilyOptions(*localFam, *remoteFam, *fbDelay)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+	resolver, err := newResolver(*dnsServer, *dnsTTL, *dnsMinTTL, *dnsMaxTTL)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	// 传输层在服务端用于监听，在客户端用于拨号
+	tunnelFamily := family.Remote
+	if *mode == "server" {
+		tunnelFamily = family.Local
+	}
+
+	udpTransport := &tunnel.UDPTransport{
+		Reliable:          !*udpUnrel,
+		KeepaliveInterval: *udpKeep,
+		IdleTimeout:       *udpIdle,
+		LossRate:          *udpLoss,
+		Socket:            socket,
+		Bind:              bind,
+		Family:            tunnelFamily,
+		Resolver:          resolver,
+	}
+	tcpTransport := tunnel.TCPTransport{
+		Socket:        socket,
+		Bind:          bind,
+		Family:        tunnelFamily,
+		FallbackDelay: family.FallbackDelay,
+		Resolver:      resolver,
+	}
+
+	tunnelTransport, err := newTransport(*transport, *mode, *tlsCert, *tlsKey, *tlsCA, *tlsSkip, udpTransport, tcpTransport)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	fec, err := tunnel.ParseFECOptions(*fecShards)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+	fec.FlushTimeout = *fecFlush
+
+	bond, err := parseBondOptions(*bondPaths, *bondMode, tunnelTransport)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	compressAlgs, err := tunnel.ParseCompressionAlgorithms(*compress)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	var rateLimits tunnel.RateLimits
+	for _, item := range []struct {
+		value string
+		limit *tunnel.RateLimit
+	}{
+		{*limitAll, &rateLimits.Tunnel},
+		{*limitSess, &rateLimits.Session},
+		{*limitIP, &rateLimits.SourceIP},
+	} {
+		if *item.limit, err = tunnel.ParseRateLimit(item.value); err != nil {
+			fmt.Printf("参数错误: %v\n\n", err)
+			printUsage()
+			os.Exit(1)
+		}
+	}
+
+	var accounting *tunnel.Accounting
+	if *quotaSpec != "" || *quotaFile != "" || (*adminAddr != "" && *mode == "server") {
+		if *mode != "server" {
+			fmt.Printf("参数错误: -quota 和 -quota-file 仅适用于服务端模式\n\n")
+			printUsage()
+			os.Exit(1)
+		}
+		// 用户身份来自客户端证书，未校验客户端证书时所有会话都不统计，配额不会生效
+		if (*quotaSpec != "" || *quotaFile != "") && (*transport != "tls" || *tlsCA == "") {
+			fmt.Printf("参数错误: -quota 和 -quota-file 需要 -transport=tls 和 -tls-ca 校验客户端证书\n\n")
+			printUsage()
+			os.Exit(1)
+		}
+		quota, err := tunnel.ParseQuota(*quotaSpec)
+		if err != nil {
+			fmt.Printf("参数错误: %v\n\n", err)
+			printUsage()
+			os.Exit(1)
+		}
+		accounting, err = tunnel.NewAccounting(tunnel.AccountingOptions{Default: quota, File: *quotaFile})
+		if err != nil {
+			log.Fatalf("加载流量统计失败: %v", err)
+		}
+		defer accounting.Close()
+	}
+	// log.Fatalf 不执行延迟调用，启动失败时先保存流量计数再退出
+	fatalf := func(format string, args ...any) {
+		log.Printf(format, args...)
+		if accounting != nil {
+			accounting.Close()
+		}
+		os.Exit(1)
+	}
+
+	acl, err := newACL(*aclAllow, *aclDeny, *aclFile)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	socketMode, err := parseFileMode(*sockMode)
+	if err != nil {
+		fmt.Printf("参数错误: %v\n\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+
+	opts := tunnel.Options{
+		Transport:           tunnelTransport,
+		SocketFile:          tunnel.SocketFileOptions{Mode: socketMode, Owner: *sockOwner},
+		Socket:              socket,
+		Bind:                bind,
+		Family:              family,
+		Resolver:            resolver,
+		LocalAddr:           *localAddr,
+		RemoteAddr:          *remoteAddr,
+		HTTPProxy:           *httpProxy,
+		TProxy:              *tproxy,
+		DynamicTarget:       *dynamic,
+		AcceptProxyProtocol: *proxyIn,
+		ProxyProtocol:       proxyVersion,
+		OriginAllow:         originAllow,
+		OriginRate:          *originRate,
+		OriginHeader:        *originHdr,
+		FEC:                 fec,
+		Bond:                bond,
+		Compression:         tunnel.CompressionOptions{Algorithms: compressAlgs, MinSize: *compMin},
+		RateLimits:          rateLimits,
+		Accounting:          accounting,
+		ACL:                 acl,
+		ConnLimits: tunnel.ConnLimits{
+			MaxConns:      *maxConns,
+			MaxConnsPerIP: *maxConnsIP,
+			AcceptRate:    *acceptRate,
+			QueueTimeout:  *acceptWait,
+		},
+		Keepalive: tunnel.KeepaliveOptions{Interval: *keepIntvl, Timeout: *keepTmo},
+		Control:   tunnel.ControlOptions{Enabled: *ctrlOn, RejectUnknown: *ctrlStrict},
+		Drain:     tunnel.DrainOptions{Timeout: *drainTmo, Alternate: *goawayAddr},
+		Mux:       tunnel.MuxOptions{Enabled: *muxOn, Window: uint32(*muxWindow), MaxStreams: *muxMax},
+		Pool: tunnel.PoolOptions{
+			Size:           *poolSize,
+			MaxIdle:        *poolIdle,
+			RefillRate:     *poolRate,
+			HealthInterval: *poolCheck,
+		},
+		Timeouts: tunnel.TimeoutOptions{Idle: *idleTmo, Linger: *lingerTmo, MaxLifetime: *maxLife},
+	}
+
 	log.Printf("启动 %s 隧道程序 - 模式: %s", strings.ToUpper(*protocol), *mode)
+	if bind != (tunnel.BindOptions{}) {
+		log.Printf("出站连接绑定: %s", bind)
+	}
+	if resolver != nil {
+		log.Printf("远程主机名解析: %s", resolver)
+	}
+	if (len(originAllow) > 0 || *originRate > 0) && (*transport != "tls" || *tlsCA == "") {
+		log.Printf("注意: 未校验客户端证书，-origin-allow/-origin-rate 使用的原始客户端地址可由隧道客户端伪造")
+	}
 
-	switch *protocol {
-	case "udp":
-		switch *mode {
-		case "client":
-			runClient(*localAddr, *remoteAddr)
-		case "server":
-			runServer(*localAddr, *remoteAddr)
+	// 收到 SIGINT/SIGTERM 时关闭隧道
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 开始关闭后恢复默认的信号处理，排空会话期间再次收到信号时立即退出
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	t := newTunnel(*mode, *protocol, opts)
+	if *adminAddr != "" {
+		if err := serveAdmin(ctx, *adminAddr, t, accounting, acl); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if acl != nil && *aclFile != "" {
+		go reloadOnHangup(ctx, acl)
+	}
+
+	if err := t.Serve(ctx); err != nil {
+		fatalf("%s%s启动失败: %v", strings.ToUpper(*protocol), modeName(*mode), err)
+	}
+	log.Printf("隧道已停止")
+}
+
+// runner 隧道运行接口，四种隧道角色都实现了该接口
+type runner interface {
+	Serve(ctx context.Context) error
+	Close() error
+	Sessions() []tunnel.SessionInfo
+	RTT() tunnel.RTTStats
+	CloseCounts() map[tunnel.ReasonCode]uint64
+}
+
+// newTunnel 根据运行模式和协议类型创建隧道
+func newTunnel(mode, protocol string, opts tunnel.Options) runner {
+	switch {
+	case protocol == "udp" && mode == "client":
+		return tunnel.NewTunnelClient(opts)
+	case protocol == "udp" && mode == "server":
+		return tunnel.NewTunnelServer(opts)
+	case protocol == "tcp" && mode == "client":
+		return tunnel.NewTCPTunnelClient(opts)
+	default:
+		return tunnel.NewTCPTunnelServer(opts)
+	}
+}
+
+// serveAdmin 在 addr 上启动管理接口，ctx 取消时关闭
+func serveAdmin(ctx context.Context, addr string, t runner, accounting *tunnel.Accounting, acl *tunnel.ACL) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("监听管理接口失败: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(t.Sessions())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeRTTMetrics(w, t.RTT())
+		writeCloseMetrics(w, t.CloseCounts())
+	})
+	if accounting != nil {
+		mux.Handle("/usage", accounting)
+	}
+	if acl != nil {
+		mux.Handle("/acl", acl)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(listener)
+
+	log.Printf("管理接口已启动，地址: http://%s/", listener.Addr())
+	return nil
+}
+
+// writeRTTMetrics 以 Prometheus 文本格式输出往返时延直方图
+func writeRTTMetrics(w io.Writer, stats tunnel.RTTStats) {
+	fmt.Fprintln(w, "# HELP tunnel_rtt_seconds 隧道连接 PING/PONG 往返时延")
+	fmt.Fprintln(w, "# TYPE tunnel_rtt_seconds histogram")
+	var cumulative uint64
+	for _, bucket := range stats.Buckets {
+		cumulative += bucket.Count
+		fmt.Fprintf(w, "tunnel_rtt_seconds_bucket{le=\"%g\"} %d\n", bucket.UpperBound.Seconds(), cumulative)
+	}
+	fmt.Fprintf(w, "tunnel_rtt_seconds_bucket{le=\"+Inf\"} %d\n", stats.Count)
+	fmt.Fprintf(w, "tunnel_rtt_seconds_sum %g\n", stats.Sum.Seconds())
+	fmt.Fprintf(w, "tunnel_rtt_seconds_count %d\n", stats.Count)
+}
+
+// writeCloseMetrics 以 Prometheus 文本格式输出按原因统计的会话关闭数
+func writeCloseMetrics(w io.Writer, counts map[tunnel.ReasonCode]uint64) {
+	fmt.Fprintln(w, "# HELP tunnel_sessions_closed_total 带关闭原因的会话数（控制帧通知的关闭和超时关闭）")
+	fmt.Fprintln(w, "# TYPE tunnel_sessions_closed_total counter")
+	codes := make([]tunnel.ReasonCode, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "tunnel_sessions_closed_total{code=\"%d\",reason=%q} %d\n", uint16(code), code.String(), counts[code])
+	}
+}
+
+// newACL 根据命令行参数创建访问控制列表，未设置任何规则时返回 nil
+func newACL(allow, deny, file string) (*tunnel.ACL, error) {
+	if allow == "" && deny == "" && file == "" {
+		return nil, nil
+	}
+	allowList, err := tunnel.ParsePrefixList(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyList, err := tunnel.ParsePrefixList(deny)
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.NewACL(allowList, denyList, file)
+}
+
+// reloadOnHangup 收到 SIGHUP 时重新加载访问控制规则文件，直到 ctx 取消
+func reloadOnHangup(ctx context.Context, acl *tunnel.ACL) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-hangup:
+			if err := acl.Reload(); err != nil {
+				log.Printf("重新加载访问控制规则失败，继续使用原有规则: %v", err)
+			} else {
+				log.Printf("已重新加载访问控制规则")
+			}
+		case <-ctx.Done():
+			return
 		}
+	}
+}
+
+// newTransport 根据命令行参数创建隧道传输层
+func newTransport(name, mode, certFile, keyFile, caFile string, insecure bool, udp *tunnel.UDPTransport, tcp tunnel.TCPTransport) (tunnel.Transport, error) {
+	switch name {
 	case "tcp":
-		switch *mode {
-		case "client":
-			runTCPClient(*localAddr, *remoteAddr)
-		case "server":
-			runTCPServer(*localAddr, *remoteAddr)
+		return &tcp, nil
+	case "tls":
+		config, err := tunnel.LoadTLSConfig(certFile, keyFile, caFile, mode == "server", insecure)
+		if err != nil {
+			return nil, err
+		}
+		return &tunnel.TLSTransport{
+			Config:        config,
+			Socket:        tcp.Socket,
+			Bind:          tcp.Bind,
+			Family:        tcp.Family,
+			FallbackDelay: tcp.FallbackDelay,
+			Resolver:      tcp.Resolver,
+		}, nil
+	case "udp":
+		return udp, nil
+	default:
+		return nil, fmt.Errorf("无效的传输层: %s（必须是 'tcp'、'tls' 或 'udp'）", name)
+	}
+}
+
+// parseBondOptions 解析多路径绑定参数，指定源 IP 的路径使用绑定该地址的 TCP 或 TLS 传输
+func parseBondOptions(paths, mode string, base tunnel.Transport) (tunnel.BondOptions, error) {
+	bondMode, err := tunnel.ParseBondMode(mode)
+	if err != nil {
+		return tunnel.BondOptions{}, err
+	}
+	opts := tunnel.BondOptions{Mode: bondMode}
+	if paths == "" {
+		return opts, nil
+	}
+
+	for _, item := range strings.Split(paths, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, source, hasSource := strings.Cut(item, "@")
+		path := tunnel.BondPath{RemoteAddr: addr}
+		if hasSource {
+			ip, err := netip.ParseAddr(source)
+			if err != nil {
+				return tunnel.BondOptions{}, fmt.Errorf("无效的绑定路径源地址: %s", source)
+			}
+			// 路径的源 IP 覆盖 -bind，源端口范围和网络接口沿用全局配置
+			switch t := base.(type) {
+			case *tunnel.TCPTransport:
+				tcp := *t
+				tcp.Bind.Address = ip
+				path.Transport = &tcp
+			case *tunnel.TLSTransport:
+				tls := *t
+				tls.Bind.Address = ip
+				path.Transport = &tls
+			default:
+				return tunnel.BondOptions{}, fmt.Errorf("当前传输层不支持为绑定路径指定源地址")
+			}
 		}
+		opts.Paths = append(opts.Paths, path)
+	}
+	return opts, nil
+}
+
+// parseFamilyOptions 解析本地和远程端点的地址族，本地端点不能使用优先类，远程端点不能使用双栈
+func parseFamilyOptions(local, remote string, delay time.Duration) (tunnel.FamilyOptions, error) {
+	localFamily, err := tunnel.ParseAddressFamily(local)
+	if err != nil {
+		return tunnel.FamilyOptions{}, err
+	}
+	if localFamily == tunnel.FamilyPreferIPv4 || localFamily == tunnel.FamilyPreferIPv6 {
+		return tunnel.FamilyOptions{}, fmt.Errorf("-local-family 不支持 %s（必须是 any、4、6 或 dual）", local)
+	}
+	remoteFamily, err := tunnel.ParseAddressFamily(remote)
+	if err != nil {
+		return tunnel.FamilyOptions{}, err
+	}
+	if remoteFamily == tunnel.FamilyDual {
+		return tunnel.FamilyOptions{}, fmt.Errorf("-remote-family 不支持 dual（必须是 any、4、6、prefer-4 或 prefer-6）")
+	}
+	return tunnel.FamilyOptions{Local: localFamily, Remote: remoteFamily, FallbackDelay: delay}, nil
+}
+
+// newResolver 创建远程主机名的解析器：server 为空时不缓存，system 使用系统解析器，其余为 DNS 服务器地址
+func newResolver(server string, ttl, minTTL, maxTTL time.Duration) (*tunnel.Resolver, error) {
+	if server == "" {
+		if ttl != 0 || minTTL != 0 || maxTTL != 0 {
+			return nil, fmt.Errorf("-dns-ttl、-dns-min-ttl 和 -dns-max-ttl 需要同时指定 -dns")
+		}
+		return nil, nil
+	}
+	if server == "system" {
+		server = ""
+	}
+	return tunnel.NewResolver(tunnel.ResolverOptions{Server: server, SystemTTL: ttl, MinTTL: minTTL, MaxTTL: maxTTL})
+}
+
+// parseFileMode 解析八进制文件权限，空字符串表示不设置
+func parseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("无效的套接字文件权限: %s", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// modeName 返回运行模式的中文名称
+func modeName(mode string) string {
+	if mode == "client" {
+		return "客户端"
 	}
+	return "服务端"
 }
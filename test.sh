@@ -3,7 +3,7 @@
 # UDP 隧道测试脚本
 
 echo "=== 编译程序 ==="
-go build -o udptunnel main.go client.go server.go packet.go
+go build -o udptunnel .
 go build -o test_udp_server test_udp_server.go  
 go build -o test_client test_client.go
 
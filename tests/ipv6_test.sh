@@ -0,0 +1,147 @@
+#!/bin/bash
+
+# IPv6 与地址族集成测试脚本
+# 第一部分在本机回环地址上完成：IPv6 字面量、-local-family 和双栈监听
+# 第二部分需要 root 权限和 iproute2，在两个网络命名空间中完成：
+#   - udptunnel-v6cli: 测试发送端 + 隧道客户端
+#   - udptunnel-v6srv: 隧道服务端 + 测试 UDP 服务器
+# 两个命名空间之间只有 IPv6 地址：链路本地地址用于测试带 %zone 的地址，ULA 地址用于测试 Happy Eyeballs
+
+set -e
+
+NS_CLI=udptunnel-v6cli
+NS_SRV=udptunnel-v6srv
+# IPv4 地址不可达、IPv6 地址可达的主机名，写入客户端命名空间的 hosts（hosts 中的链路本地地址会丢失 zone，使用 ULA 地址）
+DUAL_HOST=tunnel-server.test
+PIDS=""
+
+cleanup() {
+    echo "=== 清理 ==="
+    kill $PIDS 2>/dev/null || true
+    ip netns del $NS_CLI 2>/dev/null || true
+    ip netns del $NS_SRV 2>/dev/null || true
+    rm -rf /etc/netns/$NS_CLI
+}
+trap cleanup EXIT
+
+# start 在后台启动命令并记录进程号
+start() {
+    "$@" &
+    PIDS="$PIDS $!"
+}
+
+# send 发送一个 UDP 数据报并读取应答：send <命名空间或 -> <地址> <端口> <消息>
+send() {
+    local ns=$1 host=$2 port=$3 message=$4
+    local cmd="exec 3<>/dev/udp/$host/$port; echo -n '$message' >&3; timeout 5 dd bs=1024 count=1 <&3 2>/dev/null"
+    if [ "$ns" = "-" ]; then
+        bash -c "$cmd" || true
+    else
+        ip netns exec "$ns" bash -c "$cmd" || true
+    fi
+}
+
+# expect 检查应答
+expect() {
+    local name=$1 response=$2 message=$3
+    if [ "$response" = "回显: $message" ]; then
+        echo "✅ $name"
+    else
+        echo "❌ $name（收到: $response）"
+        exit 1
+    fi
+}
+
+echo "=== 编译程序 ==="
+cd ..
+go build -o udptunnel .
+cd tests
+go build -o test_udp_server test_udp_server.go
+
+echo "=== 启动测试 UDP 服务器 (端口 12345，双栈) ==="
+start ./test_udp_server
+sleep 1
+
+echo ""
+echo "=== 1. IPv6 回环字面量 ==="
+start ../udptunnel -mode=server -local=[::1]:19090 -remote=[::1]:12345 -remote-family=6
+start ../udptunnel -mode=client -local=[::1]:18080 -remote=[::1]:19090 -local-family=6
+sleep 1
+expect "IPv6 字面量" "$(send - ::1 18080 'hello ipv6')" "hello ipv6"
+
+echo ""
+echo "=== 2. 双栈监听，IPv4 和 IPv6 客户端连接同一个服务端 ==="
+start ../udptunnel -mode=server -local=:19091 -local-family=dual -remote=127.0.0.1:12345
+start ../udptunnel -mode=client -local=127.0.0.1:18081 -local-family=4 -remote=127.0.0.1:19091
+start ../udptunnel -mode=client -local=[::1]:18082 -remote=[::1]:19091 -remote-family=6
+sleep 1
+expect "双栈监听（IPv4 隧道）" "$(send - 127.0.0.1 18081 'via ipv4')" "via ipv4"
+expect "双栈监听（IPv6 隧道）" "$(send - ::1 18082 'via ipv6')" "via ipv6"
+
+echo ""
+echo "=== 3. 地址族不匹配时拒绝 ==="
+if ../udptunnel -mode=server -local=127.0.0.1:19092 -local-family=dual -remote=127.0.0.1:12345 2>&1 \
+    | grep -q "双栈监听需要通配地址"; then
+    echo "✅ 双栈监听拒绝非通配地址"
+else
+    echo "❌ 双栈监听未拒绝非通配地址"
+    exit 1
+fi
+start ../udptunnel -mode=client -local=127.0.0.1:18083 -remote=127.0.0.1:19091 -remote-family=6
+sleep 1
+if [ -z "$(send - 127.0.0.1 18083 'wrong family')" ]; then
+    echo "✅ -remote-family=6 拒绝 IPv4 地址"
+else
+    echo "❌ -remote-family=6 连接了 IPv4 地址"
+    exit 1
+fi
+
+if [ "$(id -u)" != "0" ]; then
+    echo ""
+    echo "非 root 用户，跳过链路本地地址和 Happy Eyeballs 测试"
+    exit 0
+fi
+
+echo ""
+echo "=== 创建网络命名空间（仅 IPv6 地址）==="
+ip netns add $NS_CLI
+ip netns add $NS_SRV
+ip link add veth-v6cli netns $NS_CLI type veth peer name veth-v6srv netns $NS_SRV
+ip netns exec $NS_CLI ip link set lo up
+ip netns exec $NS_SRV ip link set lo up
+ip netns exec $NS_CLI ip -6 addr add fe80::1/64 dev veth-v6cli nodad
+ip netns exec $NS_SRV ip -6 addr add fe80::2/64 dev veth-v6srv nodad
+ip netns exec $NS_CLI ip -6 addr add fd00:200::1/64 dev veth-v6cli nodad
+ip netns exec $NS_SRV ip -6 addr add fd00:200::2/64 dev veth-v6srv nodad
+ip netns exec $NS_CLI ip link set veth-v6cli up
+ip netns exec $NS_SRV ip link set veth-v6srv up
+# 主机名同时有没有路由的 IPv4 地址和可达的 IPv6 地址
+mkdir -p /etc/netns/$NS_CLI
+printf '127.0.0.1 localhost\n192.0.2.1 %s\nfd00:200::2 %s\n' $DUAL_HOST $DUAL_HOST > /etc/netns/$NS_CLI/hosts
+
+start ip netns exec $NS_SRV ./test_udp_server
+start ip netns exec $NS_SRV ../udptunnel -mode=server -local=[fe80::2%veth-v6srv]:9090 -remote=[::1]:12345
+start ip netns exec $NS_SRV ../udptunnel -mode=server -local=[fd00:200::2]:9091 -remote=[::1]:12345
+sleep 1
+
+echo ""
+echo "=== 4. 链路本地地址（带 %zone）==="
+start ip netns exec $NS_CLI ../udptunnel -mode=client -local=[::1]:8080 \
+    -remote=[fe80::2%veth-v6cli]:9090 -bind=fe80::1%veth-v6cli
+sleep 1
+expect "链路本地地址" "$(send $NS_CLI ::1 8080 'hello link-local')" "hello link-local"
+
+echo ""
+echo "=== 5. Happy Eyeballs：优先的 IPv4 地址不可达时改用 IPv6 ==="
+start ip netns exec $NS_CLI ../udptunnel -mode=client -local=[::1]:8081 \
+    -remote=$DUAL_HOST:9091 -remote-family=prefer-4 -fallback-delay=200ms
+sleep 1
+expect "Happy Eyeballs" "$(send $NS_CLI ::1 8081 'hello eyeballs')" "hello eyeballs"
+
+echo ""
+echo "测试流程说明："
+echo "1. IPv6 字面量写在方括号中，-local-family=6 只监听 IPv6"
+echo "2. -local-family=dual 使用一个双栈套接字同时接受 IPv4 和 IPv6 隧道连接"
+echo "3. 地址与地址族不匹配时监听或拨号失败"
+echo "4. 链路本地地址通过 %zone 指定接口，-bind 同样可以使用带 zone 的源地址"
+echo "5. 主机名的 IPv4 地址没有路由，IPv4 尝试失败后立即改用 IPv6 地址"
@@ -0,0 +1,83 @@
+#!/bin/bash
+
+# 透明代理（TPROXY）集成测试脚本
+# 需要 root 权限、iproute2 和 iptables（含 TPROXY 模块），所有流量都在两个独立的网络命名空间中完成：
+#   - udptunnel-cli: 测试发送端 + 透明代理隧道客户端（配置 TPROXY 规则）
+#   - udptunnel-srv: 隧道服务端 + 测试 UDP 服务器（持有“原始目标”地址）
+
+set -e
+
+NS_CLI=udptunnel-cli
+NS_SRV=udptunnel-srv
+# 被拦截流量的原始目标地址，只在服务端命名空间中存在
+TARGET_IP=198.51.100.10
+
+cleanup() {
+    echo "=== 清理 ==="
+    kill $UDP_SERVER_PID $TUNNEL_SERVER_PID $TUNNEL_CLIENT_PID 2>/dev/null || true
+    ip netns del $NS_CLI 2>/dev/null || true
+    ip netns del $NS_SRV 2>/dev/null || true
+}
+trap cleanup EXIT
+
+echo "=== 编译程序 ==="
+cd ..
+go build -o udptunnel .
+cd tests
+go build -o test_udp_server test_udp_server.go
+
+echo "=== 创建网络命名空间 ==="
+ip netns add $NS_CLI
+ip netns add $NS_SRV
+ip link add veth-cli netns $NS_CLI type veth peer name veth-srv netns $NS_SRV
+ip netns exec $NS_CLI ip link set lo up
+ip netns exec $NS_SRV ip link set lo up
+ip netns exec $NS_CLI ip addr add 10.200.0.1/24 dev veth-cli
+ip netns exec $NS_SRV ip addr add 10.200.0.2/24 dev veth-srv
+ip netns exec $NS_SRV ip addr add $TARGET_IP/32 dev lo
+ip netns exec $NS_CLI ip link set veth-cli up
+ip netns exec $NS_SRV ip link set veth-srv up
+ip netns exec $NS_CLI ip route add $TARGET_IP/32 via 10.200.0.2
+
+echo "=== 配置 TPROXY 规则 ==="
+# 带 fwmark 1 的数据包走本地路由表，交给本机套接字处理
+ip netns exec $NS_CLI ip rule add fwmark 1 lookup 100
+ip netns exec $NS_CLI ip route add local 0.0.0.0/0 dev lo table 100
+# 本机发出的测试流量先打标记，重新路由到 lo 后进入 PREROUTING
+ip netns exec $NS_CLI iptables -t mangle -A OUTPUT -p udp -d $TARGET_IP --dport 12345 -j MARK --set-mark 1
+# 发往 TARGET_IP:12345 的 UDP 流量被拦截到隧道客户端的 15353 端口
+ip netns exec $NS_CLI iptables -t mangle -A PREROUTING -p udp -d $TARGET_IP --dport 12345 \
+    -j TPROXY --on-port 15353 --tproxy-mark 1
+
+echo "=== 启动测试 UDP 服务器 ($TARGET_IP:12345) ==="
+ip netns exec $NS_SRV ./test_udp_server &
+UDP_SERVER_PID=$!
+sleep 1
+
+echo "=== 启动隧道服务端（允许客户端指定目标）==="
+ip netns exec $NS_SRV ../udptunnel -mode=server -dynamic-target -local=10.200.0.2:9090 -remote=127.0.0.1:12345 &
+TUNNEL_SERVER_PID=$!
+sleep 1
+
+echo "=== 启动透明代理隧道客户端 ==="
+ip netns exec $NS_CLI ../udptunnel -mode=client -tproxy -local=0.0.0.0:15353 -remote=10.200.0.2:9090 &
+TUNNEL_CLIENT_PID=$!
+sleep 1
+
+echo "=== 向原始目标发送数据 ==="
+RESPONSE=$(ip netns exec $NS_CLI bash -c \
+    "exec 3<>/dev/udp/$TARGET_IP/12345; echo -n 'Hello, TPROXY!' >&3; timeout 5 head -c 64 <&3" || true)
+echo "收到响应: $RESPONSE"
+
+if [ "$RESPONSE" = "回显: Hello, TPROXY!" ]; then
+    echo "✅ 透明代理测试通过"
+else
+    echo "❌ 透明代理测试失败"
+    exit 1
+fi
+
+echo ""
+echo "测试流程说明："
+echo "1. 测试数据发往 $TARGET_IP:12345，被 TPROXY 规则拦截到隧道客户端 :15353"
+echo "2. 隧道客户端从 IP_RECVORIGDSTADDR 取得原始目标，放入会话头发给服务端"
+echo "3. 服务端连接会话头中的目标，应答由客户端以原始目标地址为源地址发回"
@@ -0,0 +1,254 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// 访问控制模块
+// ===============================
+
+// 访问控制按监听端看到的对端 IP 判断（TCP/TLS/UDP 传输的隧道连接、本地 UDP 数据报和本地 TCP 连接），
+// 位于 PROXY 协议之后时判断的是负载均衡的地址。规则：
+//   - 匹配任一拒绝网段的对端被拒绝
+//   - 允许列表非空时，只接受匹配允许网段的对端
+//   - Unix 域套接字的对端不受限制
+//
+// 规则文件每行一条规则，"allow <CIDR>" 或 "deny <CIDR>"，单个 IP 视为主机路由，# 之后为注释。
+
+const (
+	// 同一对端两次拒绝日志之间的最短间隔，期间的拒绝只计数
+	aclLogInterval = 1 * time.Minute
+	// 被拒绝对端的统计清理时间
+	aclPeerIdleTimeout = 10 * time.Minute
+	// 最多统计的被拒绝对端数，超过后只计入总数
+	aclMaxPeers = 4096
+)
+
+// aclRules 一组允许和拒绝网段
+type aclRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// allowed 按规则判断对端 IP
+func (r aclRules) allowed(ip netip.Addr) bool {
+	if prefixesContain(r.deny, ip) {
+		return false
+	}
+	return len(r.allow) == 0 || prefixesContain(r.allow, ip)
+}
+
+// ACLRejection 一个被拒绝的对端
+type ACLRejection struct {
+	Addr  string    `json:"addr"`
+	Count uint64    `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// aclPeer 被拒绝对端的统计
+type aclPeer struct {
+	count  uint64
+	last   time.Time
+	logged time.Time
+}
+
+// ACL 按对端 IP 的访问控制列表，可以重新加载规则文件
+type ACL struct {
+	static aclRules
+	file   string
+
+	mu    sync.RWMutex
+	rules aclRules
+
+	rejected atomic.Uint64
+	peersMu  sync.Mutex
+	peers    map[netip.Addr]*aclPeer
+}
+
+// NewACL 创建访问控制列表：allow/deny 为固定规则，file 非空时追加规则文件中的规则
+func NewACL(allow, deny []netip.Prefix, file string) (*ACL, error) {
+	a := &ACL{
+		static: aclRules{allow: allow, deny: deny},
+		file:   file,
+		peers:  make(map[netip.Addr]*aclPeer),
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload 重新读取规则文件，读取失败时保留原有规则
+func (a *ACL) Reload() error {
+	rules := aclRules{
+		allow: append([]netip.Prefix(nil), a.static.allow...),
+		deny:  append([]netip.Prefix(nil), a.static.deny...),
+	}
+	if a.file != "" {
+		fileRules, err := loadACLFile(a.file)
+		if err != nil {
+			return err
+		}
+		rules.allow = append(rules.allow, fileRules.allow...)
+		rules.deny = append(rules.deny, fileRules.deny...)
+	}
+
+	a.mu.Lock()
+	a.rules = rules
+	a.mu.Unlock()
+	return nil
+}
+
+// loadACLFile 解析规则文件
+func loadACLFile(path string) (aclRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return aclRules{}, fmt.Errorf("读取访问控制规则文件失败: %w", err)
+	}
+	defer file.Close()
+
+	var rules aclRules
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return aclRules{}, fmt.Errorf("%s:%d: 规则格式应为 \"allow|deny <CIDR>\"", path, lineNo)
+		}
+
+		prefixes, err := ParsePrefixList(fields[1])
+		if err != nil {
+			return aclRules{}, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		switch fields[0] {
+		case "allow":
+			rules.allow = append(rules.allow, prefixes...)
+		case "deny":
+			rules.deny = append(rules.deny, prefixes...)
+		default:
+			return aclRules{}, fmt.Errorf("%s:%d: 无效的规则类型 %q（必须是 'allow' 或 'deny'）", path, lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return aclRules{}, fmt.Errorf("读取访问控制规则文件失败: %w", err)
+	}
+	return rules, nil
+}
+
+// Allowed 判断对端 IP 是否被允许
+func (a *ACL) Allowed(ip netip.Addr) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.rules.allowed(ip.Unmap())
+}
+
+// check 检查监听端的对端地址，拒绝时计数并按对端限频记录日志；a 为空或对端不是 IP 地址时允许。
+// 统计的对端数达到上限时，新的对端只计入总数，不记录日志
+func (a *ACL) check(addr net.Addr, listener string) bool {
+	if a == nil {
+		return true
+	}
+	peer := addrPortOf(addr)
+	if !peer.IsValid() {
+		return true
+	}
+	ip := peer.Addr().Unmap()
+	if a.Allowed(ip) {
+		return true
+	}
+
+	a.rejected.Add(1)
+	now := time.Now()
+
+	a.peersMu.Lock()
+	p, exists := a.peers[ip]
+	if !exists {
+		if len(a.peers) >= aclMaxPeers {
+			a.prunePeersLocked(now)
+		}
+		if len(a.peers) < aclMaxPeers {
+			p = &aclPeer{}
+			a.peers[ip] = p
+		}
+	}
+	shouldLog := false
+	var count uint64
+	if p != nil {
+		p.count++
+		p.last = now
+		count = p.count
+		if now.Sub(p.logged) >= aclLogInterval {
+			p.logged = now
+			shouldLog = true
+		}
+	}
+	a.peersMu.Unlock()
+
+	if shouldLog {
+		log.Printf("[%s] 访问控制拒绝 %s（累计 %d 次）", listener, ip, count)
+	}
+	return false
+}
+
+// prunePeersLocked 清理长时间没有再被拒绝的对端，调用方需持有 peersMu
+func (a *ACL) prunePeersLocked(now time.Time) {
+	for ip, p := range a.peers {
+		if now.Sub(p.last) > aclPeerIdleTimeout {
+			delete(a.peers, ip)
+		}
+	}
+}
+
+// Rejected 返回被拒绝的连接和数据报总数
+func (a *ACL) Rejected() uint64 {
+	return a.rejected.Load()
+}
+
+// Rejections 返回按拒绝次数从多到少排序的被拒绝对端
+func (a *ACL) Rejections() []ACLRejection {
+	a.peersMu.Lock()
+	defer a.peersMu.Unlock()
+
+	rejections := make([]ACLRejection, 0, len(a.peers))
+	for ip, p := range a.peers {
+		rejections = append(rejections, ACLRejection{Addr: ip.String(), Count: p.count, Last: p.last})
+	}
+	sort.Slice(rejections, func(i, j int) bool {
+		if rejections[i].Count != rejections[j].Count {
+			return rejections[i].Count > rejections[j].Count
+		}
+		return rejections[i].Addr < rejections[j].Addr
+	})
+	return rejections
+}
+
+// ServeHTTP 管理接口：GET 以 JSON 返回拒绝总数和被拒绝的对端
+func (a *ACL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "只支持 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(struct {
+		Rejected uint64         `json:"rejected"`
+		Peers    []ACLRejection `json:"peers"`
+	}{a.Rejected(), a.Rejections()})
+}
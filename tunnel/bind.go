@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ===============================
+// 源地址绑定模块
+// ===============================
+
+// 多出口的主机上，出站连接默认使用内核按路由表选择的源地址和随机端口。BindOptions 为出站连接
+// 固定源 IP、源端口范围和出口网络接口：客户端作用于隧道连接，服务端作用于目标连接。
+//
+// 指定端口范围时从范围内随机的位置开始依次尝试，端口被占用时换下一个，全部被占用时拨号失败。
+// Unix 套接字不受影响。
+
+// BindOptions 出站连接的源地址和网络接口
+type BindOptions struct {
+	// Address 源 IP，未设置时由内核选择；IPv6 链路本地地址可以带 %zone
+	Address netip.Addr
+	// PortMin、PortMax 源端口范围（含两端），均为 0 时由内核选择
+	PortMin int
+	PortMax int
+	// Device 出口网络接口名称，通过 SO_BINDTODEVICE 绑定（仅 Linux，内核 5.7 之前需要 CAP_NET_RAW）
+	Device string
+}
+
+// ParseBindOptions 解析源 IP、源端口范围（如 40000-40999 或单个端口）和网络接口，均可为空
+func ParseBindOptions(address, ports, device string) (BindOptions, error) {
+	opts := BindOptions{Device: strings.TrimSpace(device)}
+	if address = strings.TrimSpace(address); address != "" {
+		// 只去掉成对的方括号：netip 接受任意字符作为 zone，"[fe80::1%lo]:9090" 去掉方括号后
+		// 会被解析为 zone 为 "lo]:9090" 的地址
+		literal := address
+		if strings.HasPrefix(literal, "[") && strings.HasSuffix(literal, "]") {
+			literal = literal[1 : len(literal)-1]
+		}
+		addr, err := netip.ParseAddr(literal)
+		if err != nil {
+			return BindOptions{}, fmt.Errorf("无效的源地址 %q: %w", address, err)
+		}
+		opts.Address = addr
+	}
+	if ports = strings.TrimSpace(ports); ports != "" {
+		low, high, isRange := strings.Cut(ports, "-")
+		if !isRange {
+			high = low
+		}
+		var errLow, errHigh error
+		opts.PortMin, errLow = strconv.Atoi(strings.TrimSpace(low))
+		opts.PortMax, errHigh = strconv.Atoi(strings.TrimSpace(high))
+		if errLow != nil || errHigh != nil {
+			return BindOptions{}, fmt.Errorf("无效的源端口范围: %s（例如 40000-40999）", ports)
+		}
+	}
+	if err := opts.validate(); err != nil {
+		return BindOptions{}, err
+	}
+	return opts, nil
+}
+
+// validate 检查端口范围
+func (b BindOptions) validate() error {
+	if b.PortMin == 0 && b.PortMax == 0 {
+		return nil
+	}
+	if b.PortMin < 1 || b.PortMax > 65535 || b.PortMin > b.PortMax {
+		return fmt.Errorf("无效的源端口范围: %d-%d（1~65535）", b.PortMin, b.PortMax)
+	}
+	return nil
+}
+
+// enabled 是否设置了任一绑定选项
+func (b BindOptions) enabled() bool {
+	return b.Address.IsValid() || b.PortMin > 0 || b.Device != ""
+}
+
+// String 返回可读的绑定描述，用于日志
+func (b BindOptions) String() string {
+	var parts []string
+	if b.Address.IsValid() {
+		parts = append(parts, b.Address.String())
+	}
+	if b.PortMin > 0 {
+		parts = append(parts, fmt.Sprintf("端口 %d-%d", b.PortMin, b.PortMax))
+	}
+	if b.Device != "" {
+		parts = append(parts, "接口 "+b.Device)
+	}
+	return strings.Join(parts, "，")
+}
+
+// localAddr 返回 network 对应类型的本地地址，未设置源 IP 且 port 为 0 时返回 nil
+func (b BindOptions) localAddr(network string, port int) net.Addr {
+	if !b.Address.IsValid() && port == 0 {
+		return nil
+	}
+	var ip net.IP
+	if b.Address.IsValid() {
+		ip = b.Address.AsSlice()
+	}
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip, Port: port, Zone: b.Address.Zone()}
+	}
+	return &net.TCPAddr{IP: ip, Port: port, Zone: b.Address.Zone()}
+}
+
+// control 在连接之前绑定网络接口，供 net.Dialer 使用
+func (b BindOptions) control(network, address string, rc syscall.RawConn) error {
+	if b.Device == "" || strings.HasPrefix(network, "unix") {
+		return nil
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = bindToDevice(fd, b.Device)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// dial 使用 dialer 从绑定的源地址建立连接，指定端口范围时依次尝试范围内的端口
+func (b BindOptions) dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if !b.enabled() || strings.HasPrefix(network, "unix") {
+		return dialer.DialContext(ctx, network, address)
+	}
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	d := *dialer
+	if b.PortMin == 0 {
+		d.LocalAddr = b.localAddr(network, 0)
+		return d.DialContext(ctx, network, address)
+	}
+
+	size := b.PortMax - b.PortMin + 1
+	start := rand.Intn(size)
+	var lastErr error
+	for i := 0; i < size; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		d.LocalAddr = b.localAddr(network, b.PortMin+(start+i)%size)
+		conn, err := d.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		if !portInUse(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, ctx.Err()
+	}
+	return nil, fmt.Errorf("源端口范围 %d-%d 内没有可用的端口: %w", b.PortMin, b.PortMax, lastErr)
+}
+
+// portInUse 判断拨号错误是否因为源端口不可用：绑定时端口被占用，或连接时同样的四元组已存在。
+// 绑定时源 IP 不可用（不是本机地址）换端口也无法解决，不属于这种情况
+func portInUse(err error) bool {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return true
+	}
+	var sysErr *os.SyscallError
+	return errors.Is(err, syscall.EADDRNOTAVAIL) && errors.As(err, &sysErr) && sysErr.Syscall != "bind"
+}
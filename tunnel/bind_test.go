@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestParseBindOptions(t *testing.T) {
+	cases := []struct {
+		address, ports string
+		want           BindOptions
+		wantErr        bool
+	}{
+		{"", "", BindOptions{}, false},
+		{"::1", "", BindOptions{Address: netip.MustParseAddr("::1")}, false},
+		{"[::1]", "40000-40999", BindOptions{Address: netip.MustParseAddr("::1"), PortMin: 40000, PortMax: 40999}, false},
+		{"[fe80::1%lo]", "", BindOptions{Address: netip.MustParseAddr("fe80::1%lo")}, false},
+		{" fe80::1%eth0 ", "5000", BindOptions{Address: netip.MustParseAddr("fe80::1%eth0"), PortMin: 5000, PortMax: 5000}, false},
+		{"192.0.2.1", "", BindOptions{Address: netip.MustParseAddr("192.0.2.1")}, false},
+		// 带端口的地址应通过端口范围参数指定
+		{"[::1]:9090", "", BindOptions{}, true},
+		{"[fe80::1%lo]:9090", "", BindOptions{}, true},
+		{"not-an-ip", "", BindOptions{}, true},
+		{"", "40999-40000", BindOptions{}, true},
+		{"", "0-10", BindOptions{}, true},
+		{"", "abc", BindOptions{}, true},
+	}
+	for _, c := range cases {
+		opts, err := ParseBindOptions(c.address, c.ports, "")
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParseBindOptions(%q, %q) 应返回错误，实际 %+v", c.address, c.ports, opts)
+			}
+			continue
+		}
+		if err != nil || opts != c.want {
+			t.Errorf("ParseBindOptions(%q, %q) = %+v, %v，期望 %+v", c.address, c.ports, opts, err, c.want)
+		}
+	}
+}
+
+func TestBindLocalAddrZone(t *testing.T) {
+	opts, err := ParseBindOptions("[fe80::1%lo]", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 链路本地地址的 zone 保留到本地地址中
+	udpAddr, ok := opts.localAddr("udp6", 0).(*net.UDPAddr)
+	if !ok || udpAddr.Zone != "lo" || !udpAddr.IP.Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("UDP 本地地址为 %v", opts.localAddr("udp6", 0))
+	}
+	tcpAddr, ok := opts.localAddr("tcp", 40000).(*net.TCPAddr)
+	if !ok || tcpAddr.Zone != "lo" || tcpAddr.Port != 40000 {
+		t.Fatalf("TCP 本地地址为 %v", opts.localAddr("tcp", 40000))
+	}
+}
@@ -0,0 +1,395 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// 多路径绑定模块
+// ===============================
+
+// 绑定模式下 UDP 隧道的一个会话使用多条隧道连接（路径），每条连接的会话头带有相同的 bond 编号。
+// 每个隧道帧的内容为 4 字节序号（大端序）+ 数据报，接收端按序号去重并在短时间内重排序。
+
+const (
+	// 绑定帧序号长度
+	bondSeqSize = 4
+	// 接收端等待缺失序号的最长时间，超时后跳过
+	bondReorderTimeout = 50 * time.Millisecond
+	// 接收端重排序窗口（序号数）
+	bondReorderWindow = 1024
+	// 路径读取协程与 ReadPacket 之间的队列长度
+	bondQueueSize = 256
+)
+
+// BondMode 多路径绑定模式
+type BondMode int
+
+const (
+	// BondRedundant 每个数据报在所有路径上发送，任一路径送达即可
+	BondRedundant BondMode = iota
+	// BondAggregate 数据报轮流分配到各路径，叠加带宽
+	BondAggregate
+)
+
+// String 返回模式名
+func (m BondMode) String() string {
+	if m == BondAggregate {
+		return "aggregate"
+	}
+	return "redundant"
+}
+
+// ParseBondMode 解析绑定模式名，空字符串表示冗余模式
+func ParseBondMode(value string) (BondMode, error) {
+	switch value {
+	case "", "redundant":
+		return BondRedundant, nil
+	case "aggregate":
+		return BondAggregate, nil
+	default:
+		return 0, fmt.Errorf("无效的绑定模式: %s（必须是 'redundant' 或 'aggregate'）", value)
+	}
+}
+
+// BondPath 绑定模式下的一条附加路径
+type BondPath struct {
+	// RemoteAddr 该路径连接的服务端地址
+	RemoteAddr string
+	// Transport 该路径使用的传输层（例如绑定了本地源地址的 TCPTransport），为空时使用 Options.Transport
+	Transport Transport
+}
+
+// BondOptions 多路径绑定配置
+type BondOptions struct {
+	// Paths 除 RemoteAddr 之外的附加路径，为空表示不启用绑定
+	Paths []BondPath
+	// Mode 绑定模式
+	Mode BondMode
+}
+
+// enabled 是否启用多路径绑定
+func (o BondOptions) enabled() bool {
+	return len(o.Paths) > 0
+}
+
+// newBondID 生成随机的绑定会话编号
+func newBondID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("生成绑定会话编号失败: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// errBondClosed 所有路径都已断开
+var errBondClosed = errors.New("绑定会话的所有路径都已断开")
+
+// bondStream 多路径绑定处理阶段
+type bondStream struct {
+	mode     BondMode
+	identity string // 第一条路径的 TLS 客户端身份，其余路径必须相同；创建后不再修改
+
+	mu       sync.Mutex
+	paths    []*TCPPacketHandler
+	nextPath int
+	sendSeq  uint32
+	err      error
+
+	incoming  chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// 接收端状态（只在 ReadPacket 所在协程访问）
+	recvNext uint32
+	buffer   map[uint32][]byte
+	gapSince time.Time
+	ready    [][]byte
+
+	duplicates atomic.Uint64
+	skipped    atomic.Uint64
+}
+
+// newBondStream 创建绑定处理阶段
+func newBondStream(mode BondMode) *bondStream {
+	return &bondStream{
+		mode:     mode,
+		incoming: make(chan []byte, bondQueueSize),
+		done:     make(chan struct{}),
+		buffer:   make(map[uint32][]byte),
+	}
+}
+
+// addPath 加入一条路径并开始读取，返回的通道在路径断开或会话关闭后关闭；会话已关闭时返回 nil
+func (b *bondStream) addPath(path *TCPPacketHandler) <-chan struct{} {
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return nil
+	}
+	b.paths = append(b.paths, path)
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.readPath(path)
+	}()
+	return done
+}
+
+// readPath 读取一条路径上的帧
+func (b *bondStream) readPath(path *TCPPacketHandler) {
+	for {
+		frame, err := path.ReadPacket()
+		if err != nil {
+			b.removePath(path, err)
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		select {
+		case b.incoming <- frame:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// removePath 移除失效的路径，最后一条路径断开时关闭会话
+func (b *bondStream) removePath(path *TCPPacketHandler, cause error) {
+	path.conn.Close()
+
+	b.mu.Lock()
+	found := false
+	for i, p := range b.paths {
+		if p == path {
+			b.paths = append(b.paths[:i], b.paths[i+1:]...)
+			found = true
+			break
+		}
+	}
+	remaining := len(b.paths)
+	b.mu.Unlock()
+
+	if !found {
+		return
+	}
+	if remaining == 0 {
+		b.closeWithError(fmt.Errorf("%w: %v", errBondClosed, cause))
+	} else if !errors.Is(cause, net.ErrClosed) {
+		log.Printf("绑定路径 %s 已断开（剩余 %d 条）: %v", peerName(path.conn), remaining, cause)
+	}
+}
+
+// WritePacket 按绑定模式在一条或全部路径上发送数据报
+func (b *bondStream) WritePacket(data []byte) error {
+	if len(data) > 0xFFFF-bondSeqSize {
+		return fmt.Errorf("数据包过长: %d 字节", len(data))
+	}
+
+	b.mu.Lock()
+	if b.err != nil {
+		err := b.err
+		b.mu.Unlock()
+		return err
+	}
+	frame := make([]byte, 0, bondSeqSize+len(data))
+	frame = binary.BigEndian.AppendUint32(frame, b.sendSeq)
+	frame = append(frame, data...)
+	b.sendSeq++
+
+	var targets []*TCPPacketHandler
+	if b.mode == BondRedundant {
+		targets = append(targets, b.paths...)
+	} else {
+		// 从下一条路径开始依次尝试，直到一条路径发送成功
+		for i := range b.paths {
+			targets = append(targets, b.paths[(b.nextPath+i)%len(b.paths)])
+		}
+		b.nextPath++
+	}
+	b.mu.Unlock()
+
+	sent := false
+	var lastErr error
+	for _, path := range targets {
+		if err := path.WritePacket(frame); err != nil {
+			lastErr = err
+			b.removePath(path, err)
+			continue
+		}
+		sent = true
+		if b.mode == BondAggregate {
+			break
+		}
+	}
+	if !sent {
+		if lastErr == nil {
+			lastErr = errBondClosed
+		}
+		return lastErr
+	}
+	return nil
+}
+
+// ReadPacket 返回去重、重排序后的数据报，缺失的序号等待 bondReorderTimeout 后跳过
+func (b *bondStream) ReadPacket() ([]byte, error) {
+	for len(b.ready) == 0 {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if len(b.buffer) > 0 {
+			timer = time.NewTimer(time.Until(b.gapSince.Add(bondReorderTimeout)))
+			timeout = timer.C
+		}
+
+		select {
+		case frame := <-b.incoming:
+			b.receive(frame)
+		case <-timeout:
+			b.skipGap()
+		case <-b.done:
+			b.mu.Lock()
+			err := b.err
+			b.mu.Unlock()
+			return nil, err
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	data := b.ready[0]
+	b.ready = b.ready[1:]
+	return data, nil
+}
+
+// receive 处理一个带序号的帧
+func (b *bondStream) receive(frame []byte) {
+	if len(frame) < bondSeqSize {
+		log.Printf("丢弃过短的绑定帧: %d 字节", len(frame))
+		return
+	}
+	seq := binary.BigEndian.Uint32(frame)
+	data := frame[bondSeqSize:]
+
+	switch {
+	case seqLess(seq, b.recvNext):
+		b.duplicates.Add(1)
+	case seq == b.recvNext:
+		b.ready = append(b.ready, data)
+		b.recvNext++
+		b.drain()
+	case seq-b.recvNext >= bondReorderWindow:
+		// 超出重排序窗口，放弃等待之前缺失的序号
+		b.flushBuffer()
+		b.skipped.Add(uint64(seq - b.recvNext))
+		b.ready = append(b.ready, data)
+		b.recvNext = seq + 1
+	default:
+		if _, exists := b.buffer[seq]; exists {
+			b.duplicates.Add(1)
+			return
+		}
+		if len(b.buffer) == 0 {
+			b.gapSince = time.Now()
+		}
+		b.buffer[seq] = data
+	}
+}
+
+// drain 交付缓存中连续的数据报
+func (b *bondStream) drain() {
+	delivered := false
+	for {
+		data, exists := b.buffer[b.recvNext]
+		if !exists {
+			break
+		}
+		delete(b.buffer, b.recvNext)
+		b.ready = append(b.ready, data)
+		b.recvNext++
+		delivered = true
+	}
+	if delivered && len(b.buffer) > 0 {
+		b.gapSince = time.Now()
+	}
+}
+
+// skipGap 跳过等待超时的缺失序号
+func (b *bondStream) skipGap() {
+	if len(b.buffer) == 0 {
+		return
+	}
+	lowest, first := uint32(0), true
+	for seq := range b.buffer {
+		if first || seqLess(seq, lowest) {
+			lowest, first = seq, false
+		}
+	}
+	b.skipped.Add(uint64(lowest - b.recvNext))
+	b.recvNext = lowest
+	b.drain()
+}
+
+// flushBuffer 按序号顺序交付缓存中的全部数据报
+func (b *bondStream) flushBuffer() {
+	seqs := make([]uint32, 0, len(b.buffer))
+	for seq := range b.buffer {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqLess(seqs[i], seqs[j]) })
+	for _, seq := range seqs {
+		b.ready = append(b.ready, b.buffer[seq])
+		delete(b.buffer, seq)
+	}
+}
+
+// closeWithError 关闭全部路径
+func (b *bondStream) closeWithError(err error) {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		b.err = err
+		paths := b.paths
+		b.paths = nil
+		b.mu.Unlock()
+
+		close(b.done)
+		for _, path := range paths {
+			path.conn.Close()
+		}
+	})
+}
+
+// close 关闭绑定会话
+func (b *bondStream) close() {
+	b.closeWithError(errBondClosed)
+}
+
+// stats 返回统计摘要
+func (b *bondStream) stats() string {
+	return fmt.Sprintf("丢弃重复数据报 %d 个，跳过缺失序号 %d 个", b.duplicates.Load(), b.skipped.Load())
+}
+
+// pathNames 返回当前路径的对端地址
+func (b *bondStream) pathNames() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, len(b.paths))
+	for i, path := range b.paths {
+		names[i] = peerName(path.conn)
+	}
+	return strings.Join(names, ", ")
+}
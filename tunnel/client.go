@@ -0,0 +1,540 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 客户端模块
+// ===============================
+
+// TunnelClient UDP 隧道客户端
+type TunnelClient struct {
+	opts        Options
+	localUDP    string
+	remote      serverAddress // 服务端地址，收到带备用地址的 GOAWAY 后切换
+	udpConn     net.PacketConn
+	connections map[string]*ClientConnection
+	mu          sync.RWMutex
+	tunnelLimit *rateLimiter   // 整个隧道的上传限速
+	sourceLimit *sourceLimiter // 按本地客户端 IP 的上传限速
+	connLimit   *connLimiter   // 本地会话数限制
+	rtt         *rttRecorder   // 全部隧道连接的往返时延
+	sessions    *sessionRegistry
+	lifecycle
+}
+
+// NewTunnelClient 创建新的隧道客户端
+func NewTunnelClient(opts Options) *TunnelClient {
+	return &TunnelClient{
+		opts:        opts,
+		localUDP:    opts.LocalAddr,
+		remote:      serverAddress{addr: opts.RemoteAddr},
+		connections: make(map[string]*ClientConnection),
+		tunnelLimit: newRateLimiter(opts.RateLimits.Tunnel),
+		sourceLimit: newSourceLimiter(opts.RateLimits.SourceIP),
+		connLimit:   newConnLimiter(opts.ConnLimits),
+		rtt:         newRTTRecorder(nil),
+		sessions:    newSessionRegistry(opts.Hooks),
+		lifecycle:   newLifecycle(),
+	}
+}
+
+// Serve 启动客户端并处理数据，直到 ctx 取消或调用 Close
+func (c *TunnelClient) Serve(ctx context.Context) error {
+	log.Printf("启动客户端模式 - 本地 UDP: %s, 远程 TCP: %s", c.localUDP, c.remote.get())
+
+	udpConn, err := c.listenUDP()
+	if err != nil {
+		return err
+	}
+	if _, ok := udpConn.(*net.UDPConn); c.opts.TProxy && !ok {
+		udpConn.Close()
+		return fmt.Errorf("透明代理模式需要 UDP 套接字")
+	}
+
+	c.mu.Lock()
+	c.udpConn = udpConn
+	c.mu.Unlock()
+	defer c.Close()
+
+	// Close 可能在监听建立前被调用
+	if c.closed() {
+		return nil
+	}
+	c.watch(ctx, c.Close)
+	go c.opts.Resolver.watch(c.ctx, c.remote.get, c.opts.Family.Remote, c.serverChanged)
+
+	log.Printf("UDP 隧道客户端已启动，监听地址: %s", udpConn.LocalAddr())
+
+	// 处理 UDP 数据包
+	return c.handleUDPPackets()
+}
+
+// listenUDP 返回注入的数据报套接字，或监听本地 UDP / Unix 数据报套接字
+func (c *TunnelClient) listenUDP() (net.PacketConn, error) {
+	if c.opts.PacketConn != nil {
+		return c.opts.PacketConn, nil
+	}
+
+	if network, path := splitAddress(c.localUDP, "udp"); network == "unixgram" {
+		conn, err := listenUnixgram(path, c.opts.SocketFile)
+		if err != nil {
+			return nil, fmt.Errorf("监听 Unix 数据报套接字失败: %w", err)
+		}
+		return conn, nil
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", c.localUDP)
+	if err != nil {
+		return nil, fmt.Errorf("解析 UDP 地址失败: %w", err)
+	}
+
+	var udpConn *net.UDPConn
+	if c.opts.TProxy {
+		udpConn, err = listenTransparentUDP(udpAddr.String(), true, c.opts.Socket)
+	} else {
+		udpConn, err = c.opts.Socket.listenPacket("udp", udpAddr.String(), c.opts.Family.Local)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("监听 UDP 失败: %w", err)
+	}
+	return udpConn, nil
+}
+
+// Close 停止客户端并关闭所有会话
+func (c *TunnelClient) Close() error {
+	c.shutdown(func() {
+		c.mu.RLock()
+		udpConn := c.udpConn
+		c.mu.RUnlock()
+		if udpConn != nil {
+			udpConn.Close()
+		}
+		c.sessions.closeAll()
+	})
+	return nil
+}
+
+// Sessions 返回当前活动会话
+func (c *TunnelClient) Sessions() []SessionInfo {
+	return c.sessions.list()
+}
+
+// RTT 返回全部隧道连接的往返时延统计，需要启用保活
+func (c *TunnelClient) RTT() RTTStats {
+	return c.rtt.snapshot()
+}
+
+// CloseCounts 返回按原因码统计的带关闭原因的会话数（控制帧通知的关闭和超时关闭）
+func (c *TunnelClient) CloseCounts() map[ReasonCode]uint64 {
+	return c.sessions.closeCounts()
+}
+
+// handleUDPPackets 处理 UDP 数据包
+func (c *TunnelClient) handleUDPPackets() error {
+	buffer := make([]byte, maxPacketSize)
+	var oob []byte
+	if c.opts.TProxy {
+		oob = make([]byte, 256)
+	}
+	aclName := "本地 UDP 监听 " + c.udpConn.LocalAddr().String()
+	var backoff acceptBackoff
+
+	for {
+		n, clientAddr, origDst, err := c.readPacket(buffer, oob)
+		if err != nil {
+			if c.closed() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("读取 UDP 数据失败: %v，%s 后重试", err, backoff.next())
+			backoff.wait(c.done)
+			continue
+		}
+		backoff.reset()
+
+		// 未绑定路径的 Unix 数据报客户端无法接收应答
+		if clientAddr == nil || clientAddr.String() == "" {
+			log.Printf("丢弃来自未绑定地址的数据报：无法发送应答")
+			continue
+		}
+
+		// 拒绝访问控制之外的来源，避免成为开放的 UDP 反射器
+		if !c.opts.ACL.check(clientAddr, aclName) {
+			continue
+		}
+
+		if err := c.forwardToServer(clientAddr, origDst, buffer[:n]); err != nil {
+			log.Printf("转发数据到服务端失败: %v", err)
+		}
+	}
+}
+
+// readPacket 读取一个数据报，透明代理模式下同时从控制消息中恢复原始目标地址
+func (c *TunnelClient) readPacket(buffer, oob []byte) (int, net.Addr, *net.UDPAddr, error) {
+	if !c.opts.TProxy {
+		n, clientAddr, err := c.udpConn.ReadFrom(buffer)
+		return n, clientAddr, nil, err
+	}
+
+	n, oobn, _, clientAddr, err := c.udpConn.(*net.UDPConn).ReadMsgUDP(buffer, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	origDst, err := parseOrigDstAddr(oob[:oobn])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("获取 %s 数据报的原始目标地址失败: %w", clientAddr, err)
+	}
+	return n, clientAddr, origDst, nil
+}
+
+// forwardToServer 转发数据到服务端，origDst 仅在透明代理模式下非空
+func (c *TunnelClient) forwardToServer(clientAddr net.Addr, origDst *net.UDPAddr, data []byte) error {
+	clientKey := sessionKey(clientAddr, origDst)
+
+	c.mu.RLock()
+	conn, exists := c.connections[clientKey]
+	c.mu.RUnlock()
+
+	if !exists || !c.isConnectionValid(conn) {
+		// 如果连接不存在或无效，清理旧连接并创建新连接
+		if exists {
+			c.removeConnection(clientKey)
+		}
+
+		// 新会话超过限制时丢弃数据包，不等待以免阻塞其他会话
+		release, err := c.connLimit.acquire(c.ctx, clientAddr, false)
+		if err != nil {
+			return fmt.Errorf("拒绝客户端 %s 的新会话: %w", clientKey, err)
+		}
+		conn, err = c.createClientConnection(clientKey, clientAddr, origDst, release)
+		if err != nil {
+			release()
+			return fmt.Errorf("创建客户端连接失败: %w", err)
+		}
+		log.Printf("为客户端 %s 建立了新的 TCP 连接", clientKey)
+	}
+
+	// 超过限速的数据包直接丢弃
+	if !conn.limit.police(len(data)) {
+		return nil
+	}
+
+	if err := conn.SendToServer(data); err != nil {
+		// 清理失效连接
+		c.removeConnection(clientKey)
+		return err
+	}
+
+	return nil
+}
+
+// sessionKey 返回 UDP 会话的键，透明代理模式下同一客户端发往不同目标的数据属于不同会话
+func sessionKey(clientAddr net.Addr, origDst *net.UDPAddr) string {
+	if origDst == nil {
+		return clientAddr.String()
+	}
+	return clientAddr.String() + "->" + origDst.String()
+}
+
+// isConnectionValid 检查连接是否有效
+func (c *TunnelClient) isConnectionValid(conn *ClientConnection) bool {
+	if conn == nil || conn.tcpHandler == nil || conn.tcpHandler.conn == nil {
+		return false
+	}
+	// 可以添加更多的连接健康检查逻辑
+	return true
+}
+
+// createClientConnection 创建客户端连接，release 在连接关闭时释放会话名额
+func (c *TunnelClient) createClientConnection(clientKey string, clientAddr net.Addr, origDst *net.UDPAddr, release func()) (*ClientConnection, error) {
+	// 通过会话头把原始 UDP 客户端地址传给服务端（Unix 数据报客户端的路径对服务端没有意义）
+	meta := NewSessionMeta()
+	if _, ok := clientAddr.(*net.UDPAddr); ok {
+		meta.Set(metaSource, clientAddr.String())
+	}
+	if origDst != nil {
+		meta.Set(metaTarget, origDst.String())
+	}
+	if c.opts.FEC.enabled() {
+		meta.Set(metaFEC, c.opts.FEC.String())
+	}
+	offerSession(meta, &c.opts)
+	if c.opts.Bond.enabled() {
+		bondID, err := newBondID()
+		if err != nil {
+			return nil, err
+		}
+		meta.Set(metaBond, bondID)
+		meta.Set(metaBondMode, c.opts.Bond.Mode.String())
+	}
+
+	rtt := newRTTRecorder(c.rtt)
+	handler, params, err := c.dialSession(nil, c.remote.get(), meta, rtt)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &ClientConnection{
+		tcpHandler: handler,
+		udpConn:    c.udpConn,
+		clientAddr: clientAddr,
+		clientKey:  clientKey,
+		client:     c,
+		limit:      newTrafficLimiter(c.tunnelLimit, c.sourceLimit.get(addrPortOf(clientAddr).Addr()), newRateLimiter(c.opts.RateLimits.Session)),
+		rtt:        rtt,
+	}
+	conn.packets = conn.tcpHandler
+	if c.opts.Bond.enabled() {
+		conn.bond = newBondStream(c.opts.Bond.Mode)
+		conn.bond.addPath(conn.tcpHandler)
+		for _, path := range c.opts.Bond.Paths {
+			pathHandler, _, err := c.dialSession(path.Transport, path.RemoteAddr, meta, rtt)
+			if err != nil {
+				log.Printf("为客户端 %s 建立绑定路径 %s 失败: %v", clientKey, path.RemoteAddr, err)
+				continue
+			}
+			conn.bond.addPath(pathHandler)
+		}
+		conn.packets = conn.bond
+	}
+	if c.opts.FEC.enabled() {
+		conn.fec, err = newFECStream(conn.packets, c.opts.FEC)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.packets = conn.fec
+	}
+	if params.codec != nil {
+		conn.codec = params.codec
+		conn.packets = &compressStream{inner: conn.packets, codec: params.codec}
+	}
+
+	// 透明代理模式下使用绑定在原始目标地址上的套接字应答，使客户端看到的源地址不变
+	if origDst != nil {
+		replyConn, err := listenTransparentUDP(origDst.String(), false, c.opts.Socket)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("创建透明应答套接字失败: %w", err)
+		}
+		conn.udpConn = replyConn
+		conn.ownsUDPConn = true
+	}
+
+	if conn.bond != nil {
+		log.Printf("客户端 %s 的绑定会话（%s 模式）路径: %s", clientKey, c.opts.Bond.Mode, conn.bond.pathNames())
+	}
+
+	conn.release = release
+	conn.sessionID = c.sessions.open(SessionInfo{
+		Protocol:   "udp",
+		ClientAddr: clientAddr.String(),
+		Target:     meta.Get(metaTarget),
+		rtt:        rtt,
+	}, func() {
+		conn.closeWithReason(ReasonShutdown, "客户端正在关闭")
+	})
+
+	c.mu.Lock()
+	c.connections[clientKey] = conn
+	c.mu.Unlock()
+
+	// 收到 GOAWAY 后把会话迁移到新的隧道连接
+	conn.tcpHandler.onGoaway = conn.handleGoaway
+
+	// 启动从服务端接收数据的协程
+	go conn.HandleServerResponse()
+
+	return conn, nil
+}
+
+// dialSession 建立隧道连接、发送会话头并读取协商结果，transport 为空时使用配置的传输层；
+// 启用控制帧时连接的往返时延记录到 rtt
+func (c *TunnelClient) dialSession(transport Transport, address string, meta *SessionMeta, rtt *rttRecorder) (*TCPPacketHandler, sessionParams, error) {
+	tcpConn, err := c.opts.dialTunnelWith(c.ctx, transport, address)
+	if err != nil {
+		return nil, sessionParams{}, fmt.Errorf("连接到服务端失败: %w", err)
+	}
+	if err := WriteSessionHeader(tcpConn, meta); err != nil {
+		tcpConn.Close()
+		return nil, sessionParams{}, err
+	}
+	params, err := readSessionReply(tcpConn, meta, &c.opts)
+	if err != nil {
+		tcpConn.Close()
+		return nil, sessionParams{}, err
+	}
+	return newSessionHandler(tcpConn, params, rtt, &c.opts), params, nil
+}
+
+// removeConnection 移除连接
+func (c *TunnelClient) removeConnection(clientKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, exists := c.connections[clientKey]; exists {
+		delete(c.connections, clientKey)
+		// 关闭TCP连接，但不再递归调用removeConnection
+		conn.Close()
+	}
+}
+
+// detachConnection 从连接表中移除 conn，连接表中该会话已换成新连接时保留新连接
+func (c *TunnelClient) detachConnection(conn *ClientConnection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connections[conn.clientKey] == conn {
+		delete(c.connections, conn.clientKey)
+	}
+}
+
+// serverChanged 服务端主机名的解析结果变化后，把隧道连接的对端地址不在新结果中的会话迁移到新的隧道连接
+func (c *TunnelClient) serverChanged(host string, addrs []netip.Addr) {
+	c.mu.RLock()
+	var stale []*ClientConnection
+	for _, conn := range c.connections {
+		if c.isConnectionValid(conn) && addrMoved(addrs, conn.tcpHandler.conn.RemoteAddr()) {
+			stale = append(stale, conn)
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, conn := range stale {
+		conn.migrate(fmt.Sprintf("服务端 %s 的地址已变化", host))
+	}
+}
+
+// ClientConnection 客户端连接管理
+type ClientConnection struct {
+	tcpHandler  *TCPPacketHandler
+	packets     packetStream // 数据包读写，依次叠加多路径绑定、前向纠错和压缩
+	bond        *bondStream
+	fec         *fecStream
+	codec       *frameCodec
+	limit       *trafficLimiter // 上传限速，可为空
+	rtt         *rttRecorder    // 隧道连接的往返时延
+	udpConn     net.PacketConn
+	ownsUDPConn bool // udpConn 为本连接独占的透明应答套接字
+	clientAddr  net.Addr
+	clientKey   string
+	sessionID   uint64
+	client      *TunnelClient
+	release     func() // 释放会话名额，可为空
+	closeOnce   sync.Once
+}
+
+// SendToServer 发送数据到服务端
+func (c *ClientConnection) SendToServer(data []byte) error {
+	return c.packets.WritePacket(data)
+}
+
+// HandleServerResponse 处理服务端响应
+func (c *ClientConnection) HandleServerResponse() {
+	defer func() {
+		// 确保连接被清理
+		c.Close()
+		if c.client != nil {
+			c.client.detachConnection(c)
+		}
+	}()
+
+	for {
+		data, err := c.packets.ReadPacket()
+		if closeErr := peerClose(err); closeErr != nil {
+			log.Printf("[客户端 %s] 服务端关闭会话: %s", c.clientKey, closeErr.Reason())
+			if c.client != nil {
+				c.client.sessions.setCloseReason(c.sessionID, closeErr)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("读取服务端响应失败: %v", err)
+			return
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		// 将数据发送回原始 UDP 客户端
+		if err := c.sendUDPResponse(data); err != nil {
+			log.Printf("发送 UDP 响应失败: %v", err)
+			return
+		}
+	}
+}
+
+// sendUDPResponse 发送 UDP 响应
+func (c *ClientConnection) sendUDPResponse(data []byte) error {
+	_, err := c.udpConn.WriteTo(data, c.clientAddr)
+	if err != nil {
+		return fmt.Errorf("向 %s 发送 UDP 响应失败: %w", c.clientAddr.String(), err)
+	}
+	return nil
+}
+
+// handleGoaway 服务端不再接受新会话：后续数据报经新的隧道连接发送（连接备用地址，如果有）
+func (c *ClientConnection) handleGoaway(alternate string) {
+	if c.client == nil {
+		return
+	}
+	c.client.remote.redirect(alternate)
+	c.migrate("服务端发送 GOAWAY")
+}
+
+// migrate 从连接表中移除本连接，后续数据报经新的隧道连接发送，本连接继续接收在途的应答，一段时间后关闭
+func (c *ClientConnection) migrate(reason string) {
+	c.client.detachConnection(c)
+	log.Printf("[客户端 %s] %s，后续数据报迁移到新的隧道连接", c.clientKey, reason)
+	time.AfterFunc(goawayDrainTime, func() {
+		c.closeWithReason(ReasonNormal, "会话已迁移到新的隧道连接")
+	})
+}
+
+// closeWithReason 通知服务端关闭原因后关闭连接
+func (c *ClientConnection) closeWithReason(code ReasonCode, message string) {
+	c.tcpHandler.sendClose(code, message)
+	c.Close()
+}
+
+// Close 关闭连接
+func (c *ClientConnection) Close() {
+	c.closeOnce.Do(func() {
+		if c.tcpHandler != nil && c.tcpHandler.conn != nil {
+			c.tcpHandler.conn.Close()
+		}
+		if c.ownsUDPConn && c.udpConn != nil {
+			c.udpConn.Close()
+		}
+		if c.fec != nil {
+			c.fec.stop()
+			log.Printf("[客户端 %s] 前向纠错统计: %s", c.clientKey, c.fec.Stats())
+		}
+		if c.bond != nil {
+			c.bond.close()
+			log.Printf("[客户端 %s] 多路径绑定统计: %s", c.clientKey, c.bond.stats())
+		}
+		if c.codec != nil {
+			log.Printf("[客户端 %s] 压缩统计: %s", c.clientKey, c.codec)
+		}
+		c.limit.close()
+		if c.limit.throttled() {
+			log.Printf("[客户端 %s] 限速统计: %s", c.clientKey, c.limit)
+		}
+		logRTT(c.clientKey, c.rtt)
+		if c.client != nil {
+			c.client.sessions.close(c.sessionID)
+		}
+		if c.release != nil {
+			c.release()
+		}
+	})
+}
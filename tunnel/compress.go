@@ -0,0 +1,326 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ===============================
+// 压缩模块
+// ===============================
+
+// 启用压缩后每个隧道帧（UDP 隧道的数据报，TCP 隧道的一段数据）前加 1 字节标志：
+//   - 0：未压缩（小于阈值或压缩后没有变小）
+//   - 1：使用协商的算法压缩
+//
+// 协商：客户端在会话头 compress 字段按优先顺序列出算法；服务端在回复的会话头（见 acceptSession）中
+// 用 compress 字段给出选中的算法，为空表示不压缩。
+
+const (
+	// 帧标志：未压缩
+	compressFlagRaw = 0
+	// 帧标志：已压缩
+	compressFlagCompressed = 1
+	// 默认压缩阈值：小于该长度的帧不压缩
+	defaultCompressMinSize = 128
+)
+
+// Compressor 帧压缩算法，实现需要可以并发使用
+type Compressor interface {
+	// Name 算法名，用于会话头协商
+	Name() string
+	// Compress 压缩一个帧，结果追加到 dst
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress 解压一个帧，结果追加到 dst，解压后超过 limit 字节时返回错误
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{}
+)
+
+func init() {
+	RegisterCompressor(&deflateCompressor{level: flate.BestSpeed})
+}
+
+// RegisterCompressor 注册压缩算法，同名算法会被替换
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// lookupCompressor 按名称查找压缩算法
+func lookupCompressor(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, exists := compressors[name]
+	return c, exists
+}
+
+// Compressors 返回已注册的压缩算法名
+func Compressors() []string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CompressionOptions 压缩配置
+type CompressionOptions struct {
+	// Algorithms 客户端按优先顺序提供的算法，为空表示不压缩；
+	// 服务端接受的算法，为空表示接受所有已注册的算法
+	Algorithms []string
+	// MinSize 小于该长度的帧不压缩，0 表示默认 128 字节
+	MinSize int
+}
+
+// ParseCompressionAlgorithms 解析逗号分隔的算法列表并检查是否已注册
+func ParseCompressionAlgorithms(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, exists := lookupCompressor(name); !exists {
+			return nil, fmt.Errorf("不支持的压缩算法: %s（可用: %s）", name, strings.Join(Compressors(), ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// offer 返回客户端在会话头中提供的算法列表
+func (o CompressionOptions) offer() string {
+	return strings.Join(o.Algorithms, ",")
+}
+
+// choose 服务端从客户端提供的列表中选择第一个可接受的算法
+func (o CompressionOptions) choose(offer string) (Compressor, bool) {
+	for _, name := range strings.Split(offer, ",") {
+		c, exists := lookupCompressor(name)
+		if !exists {
+			continue
+		}
+		if len(o.Algorithms) == 0 {
+			return c, true
+		}
+		for _, accepted := range o.Algorithms {
+			if accepted == name {
+				return c, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// minSize 返回压缩阈值
+func (o CompressionOptions) minSize() int {
+	if o.MinSize > 0 {
+		return o.MinSize
+	}
+	return defaultCompressMinSize
+}
+
+// negotiateCompression 服务端从客户端提供的算法中选择压缩算法，结果写入回复的会话头
+func negotiateCompression(meta, reply *SessionMeta, opts CompressionOptions) *frameCodec {
+	offer := meta.Get(metaCompress)
+	if offer == "" {
+		return nil
+	}
+	c, ok := opts.choose(offer)
+	if !ok {
+		return nil
+	}
+	reply.Set(metaCompress, c.Name())
+	return newFrameCodec(c, opts.minSize())
+}
+
+// compressionFromReply 客户端根据服务端回复的会话头创建帧编解码器，未选中算法时返回 nil
+func compressionFromReply(reply *SessionMeta, opts CompressionOptions) (*frameCodec, error) {
+	name := reply.Get(metaCompress)
+	if name == "" || len(opts.Algorithms) == 0 {
+		return nil, nil
+	}
+	c, exists := lookupCompressor(name)
+	if !exists {
+		return nil, fmt.Errorf("服务端选择了不支持的压缩算法: %s", name)
+	}
+	return newFrameCodec(c, opts.minSize()), nil
+}
+
+// ===============================
+// 帧编解码
+// ===============================
+
+// frameCodec 按帧压缩和解压，并统计压缩效果
+type frameCodec struct {
+	compressor Compressor
+	minSize    int
+
+	rawBytes     atomic.Uint64 // 编码前的字节数
+	encodedBytes atomic.Uint64 // 编码后的字节数（含标志）
+	compressed   atomic.Uint64 // 压缩的帧数
+	frames       atomic.Uint64 // 编码的帧数
+}
+
+// newFrameCodec 创建帧编解码器
+func newFrameCodec(c Compressor, minSize int) *frameCodec {
+	return &frameCodec{compressor: c, minSize: minSize}
+}
+
+// encode 编码一个帧：达到阈值且压缩后变小时压缩，否则原样发送
+func (fc *frameCodec) encode(data []byte) []byte {
+	fc.frames.Add(1)
+	fc.rawBytes.Add(uint64(len(data)))
+
+	if len(data) >= fc.minSize {
+		out, err := fc.compressor.Compress([]byte{compressFlagCompressed}, data)
+		if err == nil && len(out) < len(data)+1 {
+			fc.compressed.Add(1)
+			fc.encodedBytes.Add(uint64(len(out)))
+			return out
+		}
+	}
+
+	out := make([]byte, 0, len(data)+1)
+	out = append(out, compressFlagRaw)
+	out = append(out, data...)
+	fc.encodedBytes.Add(uint64(len(out)))
+	return out
+}
+
+// decode 解码一个帧
+func (fc *frameCodec) decode(frame []byte) ([]byte, error) {
+	if len(frame) == 0 {
+		return nil, errors.New("压缩帧缺少标志")
+	}
+	switch frame[0] {
+	case compressFlagRaw:
+		return frame[1:], nil
+	case compressFlagCompressed:
+		data, err := fc.compressor.Decompress(nil, frame[1:], maxPacketSize)
+		if err != nil {
+			return nil, fmt.Errorf("解压失败: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("无效的压缩帧标志: %d", frame[0])
+	}
+}
+
+// String 返回压缩统计摘要
+func (fc *frameCodec) String() string {
+	raw, encoded := fc.rawBytes.Load(), fc.encodedBytes.Load()
+	ratio := 100.0
+	if raw > 0 {
+		ratio = float64(encoded) * 100 / float64(raw)
+	}
+	return fmt.Sprintf("%s 压缩 %d/%d 帧，原始 %d 字节，发送 %d 字节（%.1f%%）",
+		fc.compressor.Name(), fc.compressed.Load(), fc.frames.Load(), raw, encoded, ratio)
+}
+
+// compressStream UDP 隧道的压缩处理阶段
+type compressStream struct {
+	inner packetStream
+	codec *frameCodec
+}
+
+// WritePacket 编码并发送数据报
+func (s *compressStream) WritePacket(data []byte) error {
+	return s.inner.WritePacket(s.codec.encode(data))
+}
+
+// ReadPacket 读取并解码数据报
+func (s *compressStream) ReadPacket() ([]byte, error) {
+	for {
+		frame, err := s.inner.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		return s.codec.decode(frame)
+	}
+}
+
+// ===============================
+// DEFLATE 算法
+// ===============================
+
+// deflateCompressor 基于 compress/flate 的压缩算法，复用编码器和解码器
+type deflateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// Name 返回算法名
+func (d *deflateCompressor) Name() string {
+	return "deflate"
+}
+
+// Compress 压缩一个帧
+func (d *deflateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+
+	w, _ := d.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(buf, d.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer d.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress 解压一个帧
+func (d *deflateCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	source := bytes.NewReader(src)
+
+	r, _ := d.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(source)
+	} else if err := r.(flate.Resetter).Reset(source, nil); err != nil {
+		return nil, err
+	}
+	defer d.readers.Put(r)
+
+	buf := bytes.NewBuffer(dst)
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(limit) {
+		return nil, fmt.Errorf("解压后超过 %d 字节", limit)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,292 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// ===============================
+// 控制帧模块
+// ===============================
+
+// 会话协商启用控制帧（会话头 ctrl 字段）后，隧道连接上每个帧的内容为 1 字节帧类型 + 负载，
+// UDP 隧道和 TCP 隧道使用同一套帧类型：
+//   - 0 DATA：负载为一个数据包（UDP 隧道）或一段数据（TCP 隧道）
+//   - 1 PING：负载由发送方自定，接收方原样放在 PONG 中返回
+//   - 2 PONG：对 PING 的应答
+//   - 3 OPEN：4 字节流编号 + 流的元数据，打开一个流
+//   - 4 CLOSE：4 字节流编号 + 2 字节原因码 + 说明文字，流编号 0 表示关闭整个会话
+//   - 5 ERROR：4 字节流编号 + 2 字节原因码 + 说明文字，报告不影响会话继续的错误
+//   - 6 WINDOW_UPDATE：4 字节流编号 + 4 字节增加的接收窗口
+//   - 7 GOAWAY：4 字节最后接受的流编号 + 2 字节原因码 + 备用服务端地址（可为空）
+//
+// 多字节整数均为大端序。收到未知类型的帧时默认忽略，ControlOptions.RejectUnknown 为 true 时
+// 以协议错误关闭会话。
+
+const (
+	// 帧类型：数据
+	frameData = 0
+	// 帧类型：PING
+	framePing = 1
+	// 帧类型：PONG
+	framePong = 2
+	// 帧类型：打开流
+	frameOpen = 3
+	// 帧类型：关闭流或会话
+	frameClose = 4
+	// 帧类型：错误通知
+	frameError = 5
+	// 帧类型：接收窗口更新
+	frameWindowUpdate = 6
+	// 帧类型：不再接受新会话
+	frameGoaway = 7
+	// 帧类型字段大小
+	frameTypeSize = 1
+
+	// 流编号字段大小
+	streamIDSize = 4
+	// 原因码字段大小
+	reasonCodeSize = 2
+	// 发送 CLOSE 帧的最长等待时间，避免关闭时被阻塞的写入拖住
+	controlWriteTimeout = 1 * time.Second
+)
+
+// ControlOptions 控制帧配置
+type ControlOptions struct {
+	// Enabled 客户端在会话头中请求控制帧，使服务端可以告知会话关闭的原因；
+	// 设置了 Keepalive 时总是请求。服务端总是接受客户端的请求
+	Enabled bool
+	// RejectUnknown 收到未知类型的帧时关闭会话，默认忽略
+	RejectUnknown bool
+}
+
+// ReasonCode CLOSE、ERROR 和 GOAWAY 帧中的原因码
+type ReasonCode uint16
+
+const (
+	// ReasonNormal 正常关闭
+	ReasonNormal ReasonCode = iota
+	// ReasonShutdown 对端正在关闭
+	ReasonShutdown
+	// ReasonQuotaExceeded 超过流量配额
+	ReasonQuotaExceeded
+	// ReasonRejected 被访问策略拒绝，例如不允许的目标或原始客户端
+	ReasonRejected
+	// ReasonTargetUnreachable 无法连接或写入目标
+	ReasonTargetUnreachable
+	// ReasonProtocolError 协议错误，例如无效的会话头或帧
+	ReasonProtocolError
+	// ReasonInternalError 对端内部错误
+	ReasonInternalError
+	// ReasonIdleTimeout 两个方向都没有数据超过空闲超时
+	ReasonIdleTimeout
+	// ReasonLingerTimeout 一个方向结束后另一个方向超过半关闭等待时间仍未结束
+	ReasonLingerTimeout
+	// ReasonMaxLifetime 会话超过最长存活时间
+	ReasonMaxLifetime
+)
+
+// String 返回原因码的说明
+func (c ReasonCode) String() string {
+	switch c {
+	case ReasonNormal:
+		return "正常关闭"
+	case ReasonShutdown:
+		return "对端正在关闭"
+	case ReasonQuotaExceeded:
+		return "超过流量配额"
+	case ReasonRejected:
+		return "被访问策略拒绝"
+	case ReasonTargetUnreachable:
+		return "目标不可达"
+	case ReasonProtocolError:
+		return "协议错误"
+	case ReasonInternalError:
+		return "内部错误"
+	case ReasonIdleTimeout:
+		return "空闲超时"
+	case ReasonLingerTimeout:
+		return "半关闭超时"
+	case ReasonMaxLifetime:
+		return "超过最长存活时间"
+	default:
+		return fmt.Sprintf("原因码 %d", uint16(c))
+	}
+}
+
+// CloseError 对端通过 CLOSE 帧关闭了会话
+type CloseError struct {
+	Code    ReasonCode
+	Message string
+}
+
+// Reason 返回关闭原因的说明
+func (e *CloseError) Reason() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return fmt.Sprintf("%s（%s）", e.Code, e.Message)
+}
+
+// Error 实现 error 接口
+func (e *CloseError) Error() string {
+	return "对端关闭会话: " + e.Reason()
+}
+
+// peerClose 返回对端关闭会话的 CloseError，err 不是 CloseError 时返回 nil
+func peerClose(err error) *CloseError {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr
+	}
+	return nil
+}
+
+// controlFrame 解码后的控制帧
+type controlFrame struct {
+	frameType byte
+	stream    uint32
+	code      ReasonCode
+	window    uint32 // WINDOW_UPDATE 增加的接收窗口
+	text      string // CLOSE、ERROR 的说明文字或 GOAWAY 的备用地址
+	data      []byte // OPEN 的元数据
+}
+
+// encodeControl 编码控制帧的负载
+func encodeControl(f controlFrame) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, f.stream)
+	switch f.frameType {
+	case frameOpen:
+		payload = append(payload, f.data...)
+	case frameClose, frameError, frameGoaway:
+		payload = binary.BigEndian.AppendUint16(payload, uint16(f.code))
+		payload = append(payload, f.text...)
+	case frameWindowUpdate:
+		payload = binary.BigEndian.AppendUint32(payload, f.window)
+	}
+	return payload
+}
+
+// decodeControl 解码控制帧的负载
+func decodeControl(frameType byte, payload []byte) (controlFrame, error) {
+	f := controlFrame{frameType: frameType}
+	if len(payload) < streamIDSize {
+		return f, fmt.Errorf("控制帧 %d 过短: %d 字节", frameType, len(payload))
+	}
+	f.stream = binary.BigEndian.Uint32(payload)
+	rest := payload[streamIDSize:]
+
+	switch frameType {
+	case frameOpen:
+		f.data = rest
+	case frameClose, frameError, frameGoaway:
+		if len(rest) < reasonCodeSize {
+			return f, fmt.Errorf("控制帧 %d 缺少原因码", frameType)
+		}
+		f.code = ReasonCode(binary.BigEndian.Uint16(rest))
+		f.text = string(rest[reasonCodeSize:])
+	case frameWindowUpdate:
+		if len(rest) != 4 {
+			return f, fmt.Errorf("窗口更新帧长度无效: %d 字节", len(payload))
+		}
+		f.window = binary.BigEndian.Uint32(rest)
+	}
+	return f, nil
+}
+
+// writeControl 发送控制帧，未启用控制帧时不发送
+func (h *TCPPacketHandler) writeControl(f controlFrame) error {
+	if !h.control {
+		return nil
+	}
+	return h.writeTyped(f.frameType, encodeControl(f))
+}
+
+// sendClose 通知对端关闭会话的原因，未启用控制帧时不发送。最多等待 controlWriteTimeout，
+// 调用方随后应关闭连接
+func (h *TCPPacketHandler) sendClose(code ReasonCode, message string) {
+	if h == nil || !h.control {
+		return
+	}
+	h.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+	h.writeControl(controlFrame{frameType: frameClose, code: code, text: message})
+}
+
+// handleControl 处理数据帧以外的帧，返回非空错误时结束读取
+func (h *TCPPacketHandler) handleControl(frameType byte, payload []byte) error {
+	switch frameType {
+	case framePing:
+		// 在单独的协程中应答，读取协程不能等待可能阻塞的写方向，否则两端写满时互相等待；
+		// 上一个 PONG 尚未写出时丢弃本次应答。本端的写方向可能已经半关闭，应答失败不影响继续读取
+		if h.pongPending.CompareAndSwap(false, true) {
+			go func() {
+				defer h.pongPending.Store(false)
+				h.writeTyped(framePong, payload)
+			}()
+		}
+		return nil
+	case framePong:
+		h.handlePong(payload)
+		return nil
+	case frameOpen, frameClose, frameError, frameWindowUpdate, frameGoaway:
+	default:
+		if h.rejectUnknown {
+			err := fmt.Errorf("未知的帧类型: %d", frameType)
+			h.sendClose(ReasonProtocolError, err.Error())
+			return err
+		}
+		h.unknownOnce.Do(func() {
+			log.Printf("[连接 %s] 忽略未知的帧类型: %d", peerName(h.conn), frameType)
+		})
+		return nil
+	}
+
+	f, err := decodeControl(frameType, payload)
+	if err != nil {
+		return err
+	}
+	if f.stream != 0 && f.frameType != frameGoaway && h.onStream != nil {
+		h.onStream(f)
+		return nil
+	}
+	switch f.frameType {
+	case frameClose:
+		if f.stream == 0 {
+			return &CloseError{Code: f.code, Message: f.text}
+		}
+	case frameError:
+		log.Printf("[连接 %s] 对端报告错误: %s", peerName(h.conn), (&CloseError{Code: f.code, Message: f.text}).Reason())
+	case frameGoaway:
+		log.Printf("[连接 %s] 对端不再接受新会话: %s", peerName(h.conn), f.code)
+		if h.onGoaway != nil {
+			h.onGoaway(f.text)
+		}
+	}
+	// 不使用流的连接上忽略 OPEN、WINDOW_UPDATE 和流的 CLOSE
+	return nil
+}
+
+// rejectSession 拒绝刚建立的会话：协商启用了控制帧时先通过 CLOSE 帧告知对端原因，然后关闭连接
+func rejectSession(conn net.Conn, params sessionParams, code ReasonCode, message string) {
+	if params.control {
+		h := NewTCPPacketHandler(conn)
+		h.enableControl(nil, false)
+		h.sendClose(code, message)
+	}
+	conn.Close()
+}
+
+// notifyClose 通知 TCP 隧道会话的对端关闭原因：分帧连接发送 CLOSE 帧，多路复用的流重置该流，
+// 其他连接不通知。调用方随后应关闭连接
+func notifyClose(conn net.Conn, code ReasonCode, message string) {
+	switch c := conn.(type) {
+	case *framedConn:
+		c.packets.sendClose(code, message)
+	case *muxStream:
+		c.reset(code, message)
+	}
+}
@@ -0,0 +1,18 @@
+// Package tunnel 实现 UDP over TCP 与 TCP over TCP 隧道。
+//
+// 包中提供四种隧道角色，均通过 Options 配置，使用 Serve 运行、Close 停止：
+//
+//   - TunnelClient：监听本地 UDP，将数据报通过隧道转发到服务端
+//   - TunnelServer：接受隧道连接，将数据报转发到目标 UDP 服务
+//   - TCPTunnelClient：监听本地 TCP，将连接通过隧道转发到服务端
+//   - TCPTunnelServer：接受隧道连接，将连接转发到目标 TCP 服务
+//
+// 示例：
+//
+//	server := tunnel.NewTunnelServer(tunnel.Options{
+//		LocalAddr:  ":9090",
+//		RemoteAddr: "127.0.0.1:53",
+//	})
+//	go server.Serve(ctx)
+//	defer server.Close()
+package tunnel
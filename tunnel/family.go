@@ -0,0 +1,326 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ===============================
+// 地址族模块
+// ===============================
+
+// 默认情况下监听和拨号使用 "tcp"/"udp" 网络，地址族由地址本身和系统决定。FamilyOptions 为两个端点
+// 分别指定地址族：本地端点（-local，监听）和远程端点（-remote，拨号；服务端的动态目标也使用远程端点的配置）。
+//
+// 连接主机名时先解析出全部地址，按地址族过滤和排序，IPv4 和 IPv6 交替排列，
+// 再按 Happy Eyeballs（RFC 8305）的方式依次发起连接：上一次尝试在 FallbackDelay 内没有结果时
+// 并行发起下一次，尝试失败时立即发起下一次，第一个成功的连接胜出，其余连接关闭。
+// UDP 连接无法判断对端是否可达，只使用排序后的第一个地址。
+
+const (
+	// 默认 Happy Eyeballs 发起下一次连接尝试前的等待时间（RFC 8305 建议 250ms）
+	defaultFallbackDelay = 250 * time.Millisecond
+)
+
+// AddressFamily 地址族
+type AddressFamily int
+
+const (
+	// FamilyAny 由地址和系统决定
+	FamilyAny AddressFamily = iota
+	// FamilyIPv4 只使用 IPv4
+	FamilyIPv4
+	// FamilyIPv6 只使用 IPv6，监听时不接受 IPv4 映射地址
+	FamilyIPv6
+	// FamilyPreferIPv4 拨号时优先尝试 IPv4 地址
+	FamilyPreferIPv4
+	// FamilyPreferIPv6 拨号时优先尝试 IPv6 地址
+	FamilyPreferIPv6
+	// FamilyDual 监听时使用同时接受 IPv4 和 IPv6 的双栈套接字，系统不支持时监听失败
+	FamilyDual
+)
+
+// String 返回地址族的名称
+func (f AddressFamily) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	case FamilyPreferIPv4:
+		return "prefer-ipv4"
+	case FamilyPreferIPv6:
+		return "prefer-ipv6"
+	case FamilyDual:
+		return "dual"
+	default:
+		return "any"
+	}
+}
+
+// ParseAddressFamily 解析地址族：any、4/ipv4、6/ipv6、prefer-4/prefer-ipv4、prefer-6/prefer-ipv6、dual
+func ParseAddressFamily(value string) (AddressFamily, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "any":
+		return FamilyAny, nil
+	case "4", "ipv4":
+		return FamilyIPv4, nil
+	case "6", "ipv6":
+		return FamilyIPv6, nil
+	case "prefer-4", "prefer-ipv4":
+		return FamilyPreferIPv4, nil
+	case "prefer-6", "prefer-ipv6":
+		return FamilyPreferIPv6, nil
+	case "dual":
+		return FamilyDual, nil
+	default:
+		return FamilyAny, fmt.Errorf("无效的地址族: %s（必须是 any、4、6、prefer-4、prefer-6 或 dual）", value)
+	}
+}
+
+// FamilyOptions 本地端点和远程端点的地址族
+type FamilyOptions struct {
+	// Local 本地监听的地址族：any、IPv4、IPv6 或 Dual，优先类不适用于监听，按 any 处理
+	Local AddressFamily
+	// Remote 连接远程端点（客户端为服务端，服务端为目标）的地址族：any、IPv4、IPv6 或优先类，Dual 按 any 处理
+	Remote AddressFamily
+	// FallbackDelay Happy Eyeballs 发起下一次连接尝试前的等待时间，0 表示默认 250ms，负数表示依次尝试
+	FallbackDelay time.Duration
+}
+
+// allows 地址是否属于允许的地址族
+func (f AddressFamily) allows(addr netip.Addr) bool {
+	switch f {
+	case FamilyIPv4:
+		return addr.Is4()
+	case FamilyIPv6:
+		return addr.Is6()
+	default:
+		return true
+	}
+}
+
+// lookupNetwork 返回解析主机名时使用的网络
+func (f AddressFamily) lookupNetwork() string {
+	switch f {
+	case FamilyIPv4:
+		return "ip4"
+	case FamilyIPv6:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// resolveHost 通过 resolver 解析主机名或 IP 字面量（IPv6 可以带 %zone），返回按地址族过滤和排序的地址，
+// resolver 为空时使用系统解析器
+func resolveHost(ctx context.Context, resolver *Resolver, host string, family AddressFamily) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		if !family.allows(addr) {
+			return nil, fmt.Errorf("地址 %s 不属于地址族 %s", host, family)
+		}
+		return []netip.Addr{addr}, nil
+	}
+
+	addrs, _, err := resolver.lookup(ctx, host, family.lookupNetwork())
+	if err != nil {
+		return nil, err
+	}
+	addrs = sortAddrs(addrs, family)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s 没有 %s 地址", host, family)
+	}
+	return addrs, nil
+}
+
+// sortAddrs 过滤地址族并把地址按 IPv4、IPv6 交替排列：优先类以优先的地址族开头，
+// 其余以解析结果中第一个地址的地址族开头，同一地址族内保持解析结果的顺序
+func sortAddrs(addrs []netip.Addr, family AddressFamily) []netip.Addr {
+	var v4, v6 []netip.Addr
+	for _, addr := range addrs {
+		if !family.allows(addr) {
+			continue
+		}
+		if addr.Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+
+	primary, secondary := v4, v6
+	switch {
+	case family == FamilyPreferIPv6:
+		primary, secondary = v6, v4
+	case family != FamilyPreferIPv4 && len(addrs) > 0 && addrs[0].Is6():
+		primary, secondary = v6, v4
+	}
+
+	sorted := make([]netip.Addr, 0, len(v4)+len(v6))
+	for i := 0; i < max(len(primary), len(secondary)); i++ {
+		if i < len(primary) {
+			sorted = append(sorted, primary[i])
+		}
+		if i < len(secondary) {
+			sorted = append(sorted, secondary[i])
+		}
+	}
+	return sorted
+}
+
+// resolveUDP 按地址族解析 UDP 地址，any 且未配置 resolver 时与 net.ResolveUDPAddr 相同
+func resolveUDP(ctx context.Context, resolver *Resolver, address string, family AddressFamily) (*net.UDPAddr, error) {
+	host, portName, err := net.SplitHostPort(address)
+	if err != nil || host == "" || (resolver == nil && (family == FamilyAny || family == FamilyDual)) {
+		return net.ResolveUDPAddr("udp", address)
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "udp", portName)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := resolveHost(ctx, resolver, host, family)
+	if err != nil {
+		return nil, err
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrs[0], uint16(port))), nil
+}
+
+// dialResult 一次连接尝试的结果
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialFamily 通过 resolver 按地址族解析 address 并以 Happy Eyeballs 的方式通过 dialer 建立流式连接，
+// 每次尝试连接一个 IP 字面量地址
+func dialFamily(ctx context.Context, dialer Dialer, resolver *Resolver, network, address string, family AddressFamily, delay time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := resolveHost(ctx, resolver, host, family)
+	if err != nil {
+		return nil, err
+	}
+	return dialAddrs(ctx, dialer, network, addrs, port, delay)
+}
+
+// dialAddrs 按顺序以 Happy Eyeballs 的方式连接 addrs 中的地址，返回第一个成功的连接
+func dialAddrs(ctx context.Context, dialer Dialer, network string, addrs []netip.Addr, port string, delay time.Duration) (net.Conn, error) {
+	if len(addrs) == 1 {
+		return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].String(), port))
+	}
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 缓冲区足够容纳所有结果，胜出后其余尝试的结果不会阻塞
+	results := make(chan dialResult, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		target := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, target)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	// 上一次尝试在 delay 内没有结果时发起下一次，delay 为负数时只在失败后发起
+	var fallback *time.Timer
+	if delay > 0 {
+		fallback = time.NewTimer(delay)
+		defer fallback.Stop()
+	}
+	var firstErr error
+	start()
+	for pending > 0 {
+		var fallbackC <-chan time.Time
+		if fallback != nil && next < len(addrs) {
+			fallbackC = fallback.C
+		}
+
+		select {
+		case <-fallbackC:
+			start()
+			fallback.Reset(delay)
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// 关闭其余仍在进行的尝试建立的连接
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+				if fallback != nil {
+					if !fallback.Stop() {
+						select {
+						case <-fallback.C:
+						default:
+						}
+					}
+					fallback.Reset(delay)
+				}
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// listenAddress 按地址族返回监听使用的网络和地址：IPv4、IPv6 使用 tcp4/tcp6 或 udp4/udp6，
+// 双栈监听要求通配地址并在 [::] 上监听
+func listenAddress(network, address string, family AddressFamily) (string, string, error) {
+	switch family {
+	case FamilyIPv4:
+		return network + "4", address, nil
+	case FamilyIPv6:
+		return network + "6", address, nil
+	case FamilyDual:
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", "", err
+		}
+		if host != "" && host != "::" {
+			return "", "", fmt.Errorf("双栈监听需要通配地址（如 :%s 或 [::]:%s），而不是 %s", port, port, host)
+		}
+		return network, net.JoinHostPort("::", port), nil
+	default:
+		return network, address, nil
+	}
+}
+
+// checkDualStack 检查双栈监听的套接字确实是 IPv6 套接字：系统不支持 IPv4 映射地址时 Go 会改用 IPv4 套接字
+func checkDualStack(addr net.Addr, family AddressFamily) error {
+	if family != FamilyDual {
+		return nil
+	}
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf("系统不支持双栈套接字，实际监听地址为 %s", addr)
+	}
+	return nil
+}
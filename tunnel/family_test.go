@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestResolveHostLiteral(t *testing.T) {
+	cases := []struct {
+		address string
+		family  AddressFamily
+		want    string // 空表示应返回错误
+	}{
+		{"[::1]:9090", FamilyAny, "::1"},
+		{"[::1]:9090", FamilyIPv6, "::1"},
+		{"[::1]:9090", FamilyIPv4, ""},
+		{"[fe80::1%lo]:9090", FamilyAny, "fe80::1%lo"},
+		{"[fe80::1%lo]:9090", FamilyPreferIPv4, "fe80::1%lo"},
+		{"127.0.0.1:9090", FamilyIPv6, ""},
+		// IPv4 映射地址按 IPv4 处理
+		{"[::ffff:127.0.0.1]:9090", FamilyIPv4, "127.0.0.1"},
+	}
+	for _, c := range cases {
+		host, _, err := net.SplitHostPort(c.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// IP 字面量不经过解析器
+		addrs, err := resolveHost(context.Background(), nil, host, c.family)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("resolveHost(%s, %s) 应返回错误，实际 %v", host, c.family, addrs)
+			}
+			continue
+		}
+		if err != nil || len(addrs) != 1 || addrs[0] != netip.MustParseAddr(c.want) {
+			t.Errorf("resolveHost(%s, %s) = %v, %v，期望 %s", host, c.family, addrs, err, c.want)
+		}
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	cases := []struct {
+		network, address string
+		family           AddressFamily
+		wantNetwork      string
+		wantAddress      string // 空表示应返回错误
+	}{
+		{"tcp", "[::1]:9090", FamilyAny, "tcp", "[::1]:9090"},
+		{"udp", "[::1]:9090", FamilyIPv6, "udp6", "[::1]:9090"},
+		{"tcp", "[fe80::1%lo]:9090", FamilyIPv6, "tcp6", "[fe80::1%lo]:9090"},
+		{"udp", "127.0.0.1:9090", FamilyIPv4, "udp4", "127.0.0.1:9090"},
+		{"tcp", ":9090", FamilyDual, "tcp", "[::]:9090"},
+		{"udp", "[::]:9090", FamilyDual, "udp", "[::]:9090"},
+		// 双栈监听只接受通配地址
+		{"tcp", "[::1]:9090", FamilyDual, "", ""},
+		{"udp", "[fe80::1%lo]:9090", FamilyDual, "", ""},
+		{"tcp", "0.0.0.0:9090", FamilyDual, "", ""},
+		{"tcp", "::1", FamilyDual, "", ""},
+	}
+	for _, c := range cases {
+		network, address, err := listenAddress(c.network, c.address, c.family)
+		if c.wantAddress == "" {
+			if err == nil {
+				t.Errorf("listenAddress(%s, %s, %s) 应返回错误，实际 %s %s", c.network, c.address, c.family, network, address)
+			}
+			continue
+		}
+		if err != nil || network != c.wantNetwork || address != c.wantAddress {
+			t.Errorf("listenAddress(%s, %s, %s) = %s %s, %v，期望 %s %s", c.network, c.address, c.family, network, address, err, c.wantNetwork, c.wantAddress)
+		}
+	}
+}
+
+func TestListenIPv6Loopback(t *testing.T) {
+	listener, err := SocketOptions{}.listen("tcp", "[::1]:0", FamilyIPv6)
+	if err != nil {
+		t.Skipf("本机不支持 IPv6 回环地址: %v", err)
+	}
+	defer listener.Close()
+	if addr := addrPortOf(listener.Addr()).Addr(); addr != netip.IPv6Loopback() {
+		t.Fatalf("监听地址为 %s", listener.Addr())
+	}
+}
@@ -0,0 +1,448 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// 前向纠错模块
+// ===============================
+
+// 启用前向纠错后隧道帧格式：
+//   - 4 字节分组编号（大端序）
+//   - 1 字节分片序号：小于 K 为数据分片，否则为校验分片
+//   - 数据分片：原始数据报
+//   - 校验分片：1 字节本组数据分片数 + 校验数据
+//
+// 计算校验时每个数据分片表示为“2 字节长度 + 数据”，并用 0 填充到组内最长分片的长度；
+// 分组未满时缺少的数据分片视为全 0，因此校验数据恢复出的长度字段即为原始数据报长度。
+
+const (
+	// 前向纠错帧头长度：分组编号 + 分片序号
+	fecHeaderSize = 5
+	// 默认分组刷新超时：分组未满时最多等待多久发送校验分片
+	defaultFECFlushTimeout = 20 * time.Millisecond
+	// 接收端保留的未完成分组数
+	fecMaxGroups = 64
+)
+
+// FECOptions 前向纠错配置
+type FECOptions struct {
+	// DataShards 每组数据分片数，0 表示不启用前向纠错
+	DataShards int
+	// ParityShards 每组校验分片数
+	ParityShards int
+	// FlushTimeout 分组未满时发送校验分片前的最长等待时间，0 表示默认 20 毫秒
+	FlushTimeout time.Duration
+}
+
+// enabled 是否启用前向纠错
+func (o FECOptions) enabled() bool {
+	return o.DataShards > 0
+}
+
+// String 返回会话头中使用的 "K,M" 形式
+func (o FECOptions) String() string {
+	return fmt.Sprintf("%d,%d", o.DataShards, o.ParityShards)
+}
+
+// flushTimeout 返回分组刷新超时
+func (o FECOptions) flushTimeout() time.Duration {
+	if o.FlushTimeout > 0 {
+		return o.FlushTimeout
+	}
+	return defaultFECFlushTimeout
+}
+
+// ParseFECOptions 解析 "K,M" 形式的分片数，空字符串表示不启用
+func ParseFECOptions(value string) (FECOptions, error) {
+	if value == "" {
+		return FECOptions{}, nil
+	}
+
+	dataPart, parityPart, ok := strings.Cut(value, ",")
+	if !ok {
+		return FECOptions{}, fmt.Errorf("无效的前向纠错参数 %q（格式为 数据分片数,校验分片数）", value)
+	}
+	dataShards, err := strconv.Atoi(strings.TrimSpace(dataPart))
+	if err != nil {
+		return FECOptions{}, fmt.Errorf("无效的数据分片数 %q", dataPart)
+	}
+	parityShards, err := strconv.Atoi(strings.TrimSpace(parityPart))
+	if err != nil {
+		return FECOptions{}, fmt.Errorf("无效的校验分片数 %q", parityPart)
+	}
+	if dataShards < 1 || parityShards < 1 || dataShards+parityShards > 256 {
+		return FECOptions{}, fmt.Errorf("无效的前向纠错参数 %q（分片数至少为 1，总数不超过 256）", value)
+	}
+	return FECOptions{DataShards: dataShards, ParityShards: parityShards}, nil
+}
+
+// FECStats 前向纠错统计
+type FECStats struct {
+	// DataShards 收到的数据分片数
+	DataShards uint64
+	// ParityShards 收到的校验分片数
+	ParityShards uint64
+	// Recovered 通过校验分片恢复的数据报数
+	Recovered uint64
+	// Unrecovered 丢失且无法恢复的数据报数
+	Unrecovered uint64
+}
+
+// String 返回统计摘要
+func (s FECStats) String() string {
+	return fmt.Sprintf("收到数据分片 %d、校验分片 %d，恢复 %d 个数据报，%d 个无法恢复",
+		s.DataShards, s.ParityShards, s.Recovered, s.Unrecovered)
+}
+
+// packetStream 双向数据包接口，前向纠错等处理阶段包装在 TCPPacketHandler 之上
+type packetStream interface {
+	PacketReader
+	PacketWriter
+}
+
+// fecGroup 接收端的一个分组
+type fecGroup struct {
+	shards [][]byte // 数据分片为原始数据报，校验分片为校验数据
+	count  int      // 本组数据分片数，收到校验分片前为 -1
+	done   bool     // 已全部收到或已恢复
+}
+
+// fecStream 前向纠错处理阶段
+type fecStream struct {
+	inner packetStream
+	rs    *reedSolomon
+	flush time.Duration
+
+	// 发送端状态
+	writeMu sync.Mutex
+	group   uint32
+	pending [][]byte
+	timer   *time.Timer
+
+	// 接收端状态（只在 ReadPacket 所在协程访问）
+	groups    map[uint32]*fecGroup
+	newest    uint32
+	hasNewest bool
+	ready     [][]byte
+
+	dataShards   atomic.Uint64
+	parityShards atomic.Uint64
+	recovered    atomic.Uint64
+	unrecovered  atomic.Uint64
+}
+
+// newFECStream 在数据包流上启用前向纠错
+func newFECStream(inner packetStream, opts FECOptions) (*fecStream, error) {
+	rs, err := newReedSolomon(opts.DataShards, opts.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &fecStream{
+		inner:  inner,
+		rs:     rs,
+		flush:  opts.flushTimeout(),
+		groups: make(map[uint32]*fecGroup),
+	}, nil
+}
+
+// WritePacket 立即发送数据分片，分组满或超时后发送校验分片
+func (s *fecStream) WritePacket(data []byte) error {
+	if len(data) > 0xFFFF-fecHeaderSize {
+		return fmt.Errorf("数据包过长: %d 字节", len(data))
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	index := len(s.pending)
+	frame := make([]byte, 0, fecHeaderSize+len(data))
+	frame = binary.BigEndian.AppendUint32(frame, s.group)
+	frame = append(frame, byte(index))
+	frame = append(frame, data...)
+	if err := s.inner.WritePacket(frame); err != nil {
+		return err
+	}
+
+	s.pending = append(s.pending, append([]byte(nil), data...))
+	if index == 0 {
+		group := s.group
+		s.timer = time.AfterFunc(s.flush, func() { s.flushGroup(group) })
+	}
+	if len(s.pending) == s.rs.dataShards {
+		return s.finishGroupLocked()
+	}
+	return nil
+}
+
+// flushGroup 超时后为未满的分组发送校验分片
+func (s *fecStream) flushGroup(group uint32) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.group != group || len(s.pending) == 0 {
+		return
+	}
+	if err := s.finishGroupLocked(); err != nil {
+		log.Printf("发送前向纠错校验分片失败: %v", err)
+	}
+}
+
+// finishGroupLocked 计算并发送当前分组的校验分片，调用方需持有 writeMu
+func (s *fecStream) finishGroupLocked() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	group, count, pending := s.group, len(s.pending), s.pending
+	s.group++
+	s.pending = nil
+
+	size := 0
+	for _, data := range pending {
+		if len(data) > size {
+			size = len(data)
+		}
+	}
+	size += packetLengthSize
+	// 校验帧放不进一个隧道帧时本组不发送校验分片
+	if fecHeaderSize+1+size > 0xFFFF {
+		return nil
+	}
+
+	shards := make([][]byte, s.rs.dataShards)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < count {
+			binary.BigEndian.PutUint16(shards[i], uint16(len(pending[i])))
+			copy(shards[i][packetLengthSize:], pending[i])
+		}
+	}
+
+	for i, parity := range s.rs.encode(shards) {
+		frame := make([]byte, 0, fecHeaderSize+1+len(parity))
+		frame = binary.BigEndian.AppendUint32(frame, group)
+		frame = append(frame, byte(s.rs.dataShards+i), byte(count))
+		frame = append(frame, parity...)
+		if err := s.inner.WritePacket(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPacket 返回收到或恢复的数据报，恢复出的数据报可能晚于同组之后的数据报
+func (s *fecStream) ReadPacket() ([]byte, error) {
+	for len(s.ready) == 0 {
+		frame, err := s.inner.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		if err := s.receive(frame); err != nil {
+			log.Printf("丢弃无效的前向纠错帧: %v", err)
+		}
+	}
+
+	data := s.ready[0]
+	s.ready = s.ready[1:]
+	return data, nil
+}
+
+// receive 处理一个前向纠错帧
+func (s *fecStream) receive(frame []byte) error {
+	if len(frame) < fecHeaderSize {
+		return fmt.Errorf("帧过短: %d 字节", len(frame))
+	}
+	groupID := binary.BigEndian.Uint32(frame)
+	index := int(frame[4])
+	payload := frame[fecHeaderSize:]
+	if index >= s.rs.dataShards+s.rs.parityShards {
+		return fmt.Errorf("无效的分片序号 %d", index)
+	}
+
+	group := s.lookupGroup(groupID)
+	if group == nil || group.done || group.shards[index] != nil {
+		// 过旧或重复的分片
+		return nil
+	}
+
+	if index < s.rs.dataShards {
+		s.dataShards.Add(1)
+		group.shards[index] = payload
+		s.ready = append(s.ready, payload)
+	} else {
+		if len(payload) < 1+packetLengthSize {
+			return fmt.Errorf("校验分片过短: %d 字节", len(payload))
+		}
+		if int(payload[0]) > s.rs.dataShards {
+			return fmt.Errorf("无效的分组数据分片数 %d", payload[0])
+		}
+		s.parityShards.Add(1)
+		group.count = int(payload[0])
+		group.shards[index] = payload[1:]
+	}
+
+	s.tryRecover(group)
+	return nil
+}
+
+// lookupGroup 返回分组，必要时创建并淘汰过旧的分组；过旧的分组返回 nil
+func (s *fecStream) lookupGroup(id uint32) *fecGroup {
+	if group, exists := s.groups[id]; exists {
+		return group
+	}
+	if s.hasNewest && seqLess(id, s.newest) && s.newest-id >= fecMaxGroups {
+		return nil
+	}
+
+	group := &fecGroup{
+		shards: make([][]byte, s.rs.dataShards+s.rs.parityShards),
+		count:  -1,
+	}
+	s.groups[id] = group
+
+	if !s.hasNewest || seqLess(s.newest, id) {
+		s.newest, s.hasNewest = id, true
+		for oldID, old := range s.groups {
+			if s.newest-oldID >= fecMaxGroups {
+				s.evict(old)
+				delete(s.groups, oldID)
+			}
+		}
+	}
+	return group
+}
+
+// evict 淘汰分组，统计无法恢复的数据报
+func (s *fecStream) evict(group *fecGroup) {
+	if group.done || group.count < 0 {
+		return
+	}
+	for i := 0; i < group.count; i++ {
+		if group.shards[i] == nil {
+			s.unrecovered.Add(1)
+		}
+	}
+}
+
+// tryRecover 分片足够时恢复缺失的数据分片
+func (s *fecStream) tryRecover(group *fecGroup) {
+	if group.count < 0 {
+		return
+	}
+
+	missing, available := 0, 0
+	for i, shard := range group.shards {
+		switch {
+		case i < group.count && shard == nil:
+			missing++
+		case shard != nil || i < s.rs.dataShards:
+			// 超出本组数据分片数的数据分片视为全 0，总是可用
+			available++
+		}
+	}
+	if missing == 0 {
+		group.done = true
+		group.shards = nil
+		return
+	}
+	if available < s.rs.dataShards {
+		return
+	}
+
+	// 校验分片长度即为填充后的数据分片长度，同组校验分片长度不一致时分片不属于同一次编码
+	size := -1
+	for _, shard := range group.shards[s.rs.dataShards:] {
+		if shard == nil {
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			s.abandon(group)
+			return
+		}
+		size = len(shard)
+	}
+
+	shards := make([][]byte, len(group.shards))
+	for i, shard := range group.shards {
+		switch {
+		case i >= s.rs.dataShards:
+			shards[i] = shard
+		case i >= group.count:
+			shards[i] = make([]byte, size)
+		case shard != nil:
+			if len(shard)+packetLengthSize > size {
+				// 数据分片比校验数据长，分片不属于同一次编码
+				s.abandon(group)
+				return
+			}
+			padded := make([]byte, size)
+			binary.BigEndian.PutUint16(padded, uint16(len(shard)))
+			copy(padded[packetLengthSize:], shard)
+			shards[i] = padded
+		}
+	}
+
+	if err := s.rs.reconstruct(shards); err != nil {
+		log.Printf("前向纠错恢复失败: %v", err)
+		s.abandon(group)
+		return
+	}
+
+	for i := 0; i < group.count; i++ {
+		if group.shards[i] != nil {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(shards[i]))
+		if packetLengthSize+length > size {
+			continue
+		}
+		s.recovered.Add(1)
+		s.ready = append(s.ready, shards[i][packetLengthSize:packetLengthSize+length])
+	}
+	group.done = true
+	group.shards = nil
+}
+
+// abandon 分组的分片互相矛盾，放弃恢复并统计缺失的数据报
+func (s *fecStream) abandon(group *fecGroup) {
+	for i := 0; i < group.count; i++ {
+		if group.shards[i] == nil {
+			s.unrecovered.Add(1)
+		}
+	}
+	group.done = true
+	group.shards = nil
+}
+
+// Stats 返回前向纠错统计
+func (s *fecStream) Stats() FECStats {
+	return FECStats{
+		DataShards:   s.dataShards.Load(),
+		ParityShards: s.parityShards.Load(),
+		Recovered:    s.recovered.Load(),
+		Unrecovered:  s.unrecovered.Load(),
+	}
+}
+
+// stop 停止分组刷新定时器
+func (s *fecStream) stop() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
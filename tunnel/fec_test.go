@@ -0,0 +1,181 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// packetQueue 内存中的数据包队列，按写入顺序读出，读完后返回 io.EOF
+type packetQueue struct {
+	packets [][]byte
+}
+
+func (q *packetQueue) WritePacket(data []byte) error {
+	q.packets = append(q.packets, append([]byte(nil), data...))
+	return nil
+}
+
+func (q *packetQueue) ReadPacket() ([]byte, error) {
+	if len(q.packets) == 0 {
+		return nil, io.EOF
+	}
+	data := q.packets[0]
+	q.packets = q.packets[1:]
+	return data, nil
+}
+
+func TestReedSolomonReconstruct(t *testing.T) {
+	const k, m, size = 4, 2, 64
+	rs, err := newReedSolomon(k, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	data := make([][]byte, k)
+	for i := range data {
+		data[i] = make([]byte, size)
+		rng.Read(data[i])
+	}
+	encoded := append(append([][]byte(nil), data...), rs.encode(data)...)
+
+	// 丢弃任意不超过 M 个分片都能恢复全部数据分片
+	for mask := 0; mask < 1<<(k+m); mask++ {
+		dropped := 0
+		shards := make([][]byte, k+m)
+		for i := range shards {
+			if mask&(1<<i) != 0 {
+				dropped++
+				continue
+			}
+			shards[i] = append([]byte(nil), encoded[i]...)
+		}
+		if dropped > m {
+			continue
+		}
+		if err := rs.reconstruct(shards); err != nil {
+			t.Fatalf("丢弃分片 %06b: %v", mask, err)
+		}
+		for i := 0; i < k; i++ {
+			if !bytes.Equal(shards[i], data[i]) {
+				t.Fatalf("丢弃分片 %06b: 数据分片 %d 恢复错误", mask, i)
+			}
+		}
+	}
+
+	shards := make([][]byte, k+m)
+	copy(shards, encoded[:m+1])
+	if err := rs.reconstruct(shards); !errors.Is(err, errTooFewShards) {
+		t.Fatalf("分片不足时应返回 errTooFewShards，实际为 %v", err)
+	}
+}
+
+func TestReedSolomonUnequalShards(t *testing.T) {
+	rs, err := newReedSolomon(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := [][]byte{nil, nil, make([]byte, 100), make([]byte, 5)}
+	if err := rs.reconstruct(shards); !errors.Is(err, errShardSize) {
+		t.Fatalf("分片长度不一致时应返回 errShardSize，实际为 %v", err)
+	}
+}
+
+func TestFECStreamRecovery(t *testing.T) {
+	opts := FECOptions{DataShards: 4, ParityShards: 2, FlushTimeout: time.Hour}
+	wire := &packetQueue{}
+	sender, err := newFECStream(wire, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.stop()
+
+	sent := []string{"a", "hello", "forward error correction", "xyz"}
+	for _, payload := range sent {
+		if err := sender.WritePacket([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(wire.packets) != 6 {
+		t.Fatalf("应发送 4 个数据分片和 2 个校验分片，实际 %d 个帧", len(wire.packets))
+	}
+
+	// 丢弃第 1 和第 3 个数据分片
+	lossy := &packetQueue{}
+	for i, frame := range wire.packets {
+		if i != 1 && i != 3 {
+			lossy.packets = append(lossy.packets, frame)
+		}
+	}
+	receiver, err := newFECStream(lossy, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+	for {
+		data, err := receiver.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, string(data))
+	}
+	sort.Strings(received)
+	expected := append([]string(nil), sent...)
+	sort.Strings(expected)
+	if len(received) != len(expected) {
+		t.Fatalf("收到 %q，期望 %q", received, expected)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("收到 %q，期望 %q", received, expected)
+		}
+	}
+	if stats := receiver.Stats(); stats.Recovered != 2 || stats.Unrecovered != 0 {
+		t.Fatalf("统计错误: %s", stats)
+	}
+}
+
+func TestFECStreamMismatchedParity(t *testing.T) {
+	receiver, err := newFECStream(&packetQueue{}, FECOptions{DataShards: 2, ParityShards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一分组的两个校验分片长度不同，不能据此恢复（之前会越界）
+	parity := func(index byte, size int) []byte {
+		frame := binary.BigEndian.AppendUint32(nil, 7)
+		frame = append(frame, index, 2)
+		return append(frame, make([]byte, size)...)
+	}
+	if err := receiver.receive(parity(2, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.receive(parity(3, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if len(receiver.ready) != 0 {
+		t.Fatalf("不应恢复出数据报，实际 %d 个", len(receiver.ready))
+	}
+	if stats := receiver.Stats(); stats.Unrecovered != 2 {
+		t.Fatalf("两个数据报应计为无法恢复: %s", stats)
+	}
+
+	// 分组已放弃，之后的分片被忽略
+	data := binary.BigEndian.AppendUint32(nil, 7)
+	data = append(data, 0, 'x')
+	if err := receiver.receive(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(receiver.ready) != 0 {
+		t.Fatalf("已放弃的分组不应再返回数据报")
+	}
+}
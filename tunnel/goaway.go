@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 平滑关闭模块
+// ===============================
+
+// 服务端关闭时先停止接受新连接，在每个启用控制帧的会话上发送 GOAWAY 帧（原因码为对端正在关闭，
+// 可附带备用服务端地址），然后等待会话结束，超过 DrainOptions.Timeout 后关闭剩余的会话。
+// 客户端收到 GOAWAY 后：
+//   - 之后的新会话连接备用地址（没有备用地址时重新连接原地址，例如重启后的服务端或负载均衡）
+//   - UDP 隧道会话的后续数据报改经新的隧道连接发送，旧连接继续接收在途的应答，一段时间后关闭
+//   - TCP 隧道会话无法迁移，在旧连接上继续传输直到结束
+
+const (
+	// UDP 隧道会话迁移后旧连接继续接收应答的时间
+	goawayDrainTime = 2 * time.Second
+	// 等待会话结束时检查剩余会话数的间隔
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// DrainOptions 服务端关闭时的平滑迁移配置
+type DrainOptions struct {
+	// Timeout 关闭时发送 GOAWAY 后等待会话结束的最长时间，0 表示不发送 GOAWAY、立即关闭所有会话
+	Timeout time.Duration
+	// Alternate GOAWAY 中告知客户端的备用服务端地址，为空表示重新连接原地址
+	Alternate string
+}
+
+// sendGoaway 通知对端不再接受新会话，未启用控制帧或 h 为空时不发送
+func (h *TCPPacketHandler) sendGoaway(alternate string) {
+	if h == nil || !h.control {
+		return
+	}
+	h.writeControl(controlFrame{frameType: frameGoaway, code: ReasonShutdown, text: alternate})
+}
+
+// setGoaway 登记向会话发送 GOAWAY 的函数
+func (r *sessionRegistry) setGoaway(id uint64, goaway func(alternate string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, exists := r.sessions[id]; exists {
+		session.goaway = goaway
+	}
+}
+
+// count 返回活动会话数
+func (r *sessionRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// drain 向所有会话发送 GOAWAY 并等待会话结束，最多等待 opts.Timeout
+func (r *sessionRegistry) drain(opts DrainOptions) {
+	if opts.Timeout <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	var goaways []func(string)
+	for _, session := range r.sessions {
+		if session.goaway != nil {
+			goaways = append(goaways, session.goaway)
+		}
+	}
+	total := len(r.sessions)
+	r.mu.Unlock()
+	if total == 0 {
+		return
+	}
+
+	for _, goaway := range goaways {
+		goaway(opts.Alternate)
+	}
+	log.Printf("向 %d 个会话发送 GOAWAY（共 %d 个会话），最多等待 %s", len(goaways), total, opts.Timeout)
+
+	deadline := time.Now().Add(opts.Timeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for remaining := total; remaining > 0; remaining = r.count() {
+		if time.Now().After(deadline) {
+			log.Printf("等待 %s 后仍有 %d 个会话，强制关闭", opts.Timeout, remaining)
+			return
+		}
+		<-ticker.C
+	}
+	log.Printf("所有会话已结束")
+}
+
+// serverAddress 客户端连接的服务端地址，收到带备用地址的 GOAWAY 后切换
+type serverAddress struct {
+	mu   sync.Mutex
+	addr string
+}
+
+// get 返回当前的服务端地址
+func (a *serverAddress) get() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addr
+}
+
+// redirect 之后的新会话连接备用地址，alternate 为空时保持原地址
+func (a *serverAddress) redirect(alternate string) {
+	if alternate == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.addr != alternate {
+		log.Printf("服务端通知迁移，新会话改为连接 %s（原地址 %s）", alternate, a.addr)
+		a.addr = alternate
+	}
+}
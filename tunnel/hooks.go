@@ -0,0 +1,207 @@
+package tunnel
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 会话事件模块
+// ===============================
+
+// SessionInfo 会话信息
+type SessionInfo struct {
+	// ID 会话编号，在同一个隧道实例内唯一
+	ID uint64
+	// Protocol 隧道协议："udp" 或 "tcp"
+	Protocol string
+	// ClientAddr 会话对端地址：客户端为本地应用地址，服务端为隧道连接地址
+	ClientAddr string
+	// Origin 原始客户端地址（服务端从会话头获取），未知时为空
+	Origin string
+	// Target 会话目标地址
+	Target string
+	// User 服务端通过 TLS 客户端证书认证的身份，未认证时为空
+	User string
+	// RTT 隧道连接的往返时延统计，仅在 Sessions 返回的列表中填充
+	RTT RTTStats
+	// CloseReason 通过 CLOSE 控制帧收到或发出的会话关闭原因，或本端超时关闭的原因，仅在 OnSessionClose 中填充
+	CloseReason string
+	// CloseCode 关闭原因的原因码，CloseReason 为空时无意义
+	CloseCode ReasonCode
+
+	rtt *rttRecorder
+	// StartTime 会话建立时间
+	StartTime time.Time
+}
+
+// Hooks 会话事件回调，回调在会话所在的协程中同步执行，不应长时间阻塞
+type Hooks struct {
+	// OnSessionOpen 会话建立后调用
+	OnSessionOpen func(info SessionInfo)
+	// OnSessionClose 会话关闭后调用
+	OnSessionClose func(info SessionInfo)
+}
+
+// trackedSession 登记中的会话
+type trackedSession struct {
+	info   SessionInfo
+	close  func()
+	goaway func(alternate string) // 发送 GOAWAY，会话未启用控制帧时为空
+}
+
+// sessionRegistry 活动会话登记表
+type sessionRegistry struct {
+	mu       sync.Mutex
+	hooks    Hooks
+	nextID   uint64
+	sessions map[uint64]*trackedSession
+	closes   map[ReasonCode]uint64 // 按原因码统计带关闭原因的会话数
+}
+
+// newSessionRegistry 创建会话登记表
+func newSessionRegistry(hooks Hooks) *sessionRegistry {
+	return &sessionRegistry{
+		hooks:    hooks,
+		sessions: make(map[uint64]*trackedSession),
+		closes:   make(map[ReasonCode]uint64),
+	}
+}
+
+// open 登记新会话并触发 OnSessionOpen，返回会话编号
+func (r *sessionRegistry) open(info SessionInfo, closeFn func()) uint64 {
+	r.mu.Lock()
+	r.nextID++
+	info.ID = r.nextID
+	info.StartTime = time.Now()
+	r.sessions[info.ID] = &trackedSession{info: info, close: closeFn}
+	r.mu.Unlock()
+
+	if r.hooks.OnSessionOpen != nil {
+		r.hooks.OnSessionOpen(info)
+	}
+	return info.ID
+}
+
+// close 注销会话并触发 OnSessionClose，重复调用无副作用
+func (r *sessionRegistry) close(id uint64) {
+	r.mu.Lock()
+	session, exists := r.sessions[id]
+	delete(r.sessions, id)
+	if exists && session.info.CloseReason != "" {
+		r.closes[session.info.CloseCode]++
+	}
+	r.mu.Unlock()
+
+	if exists && r.hooks.OnSessionClose != nil {
+		r.hooks.OnSessionClose(session.info)
+	}
+}
+
+// setCloseReason 记录会话的关闭原因，只保留第一次记录的原因；r 为空时忽略
+func (r *sessionRegistry) setCloseReason(id uint64, reason *CloseError) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, exists := r.sessions[id]; exists && session.info.CloseReason == "" {
+		session.info.CloseReason = reason.Reason()
+		session.info.CloseCode = reason.Code
+	}
+}
+
+// closeCounts 返回按原因码统计的带关闭原因的会话数
+func (r *sessionRegistry) closeCounts() map[ReasonCode]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[ReasonCode]uint64, len(r.closes))
+	for code, n := range r.closes {
+		counts[code] = n
+	}
+	return counts
+}
+
+// closeAll 关闭所有活动会话
+func (r *sessionRegistry) closeAll() {
+	r.mu.Lock()
+	sessions := make([]*trackedSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	r.mu.Unlock()
+
+	for _, session := range sessions {
+		session.close()
+		r.close(session.info.ID)
+	}
+}
+
+// list 返回按编号排序的活动会话
+func (r *sessionRegistry) list() []SessionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]SessionInfo, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		info := session.info
+		info.RTT = info.rtt.snapshot()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// ===============================
+// 运行状态
+// ===============================
+
+// lifecycle 隧道运行状态，负责 Serve 与 Close 之间的协调
+type lifecycle struct {
+	ctx       context.Context // Close 时取消，用于中断进行中的拨号
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// newLifecycle 创建运行状态
+func newLifecycle() lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return lifecycle{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// watch 在 ctx 取消时调用 closeFn，隧道关闭后自动退出
+func (l *lifecycle) watch(ctx context.Context, closeFn func() error) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeFn()
+		case <-l.done:
+		}
+	}()
+}
+
+// closed 判断是否已调用 Close
+func (l *lifecycle) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown 标记关闭并执行一次清理函数，返回是否为首次关闭。
+// ctx 在清理完成后才取消，服务端排空会话期间进行中的限速等待不会被中断
+func (l *lifecycle) shutdown(cleanup func()) bool {
+	first := false
+	l.closeOnce.Do(func() {
+		first = true
+		close(l.done)
+		cleanup()
+		l.cancel()
+	})
+	return first
+}
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ===============================
+// HTTP 代理前端模块
+// ===============================
+
+// httpProxyRequest 解析后的 HTTP 代理请求
+type httpProxyRequest struct {
+	// 目标地址（host:port）
+	target string
+	// 后续双向转发使用的本地连接（包含已缓冲的数据）
+	conn net.Conn
+	// 非 CONNECT 请求需要先转发到目标的原始请求
+	request *http.Request
+}
+
+// readHTTPProxyRequest 从本地连接读取 HTTP 代理请求
+//
+// 支持两种请求：
+//   - CONNECT host:port，应答 200 后直接转发字节流
+//   - 绝对 URI 形式的普通请求（GET http://host/path），改写为源站形式后转发
+func readHTTPProxyRequest(localConn net.Conn) (*httpProxyRequest, error) {
+	reader := bufio.NewReader(localConn)
+
+	localConn.SetReadDeadline(time.Now().Add(sessionHeaderTimeout))
+	req, err := http.ReadRequest(reader)
+	localConn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("读取 HTTP 代理请求失败: %w", err)
+	}
+
+	conn := newBufferedConn(localConn, reader)
+
+	if req.Method == http.MethodConnect {
+		target := withDefaultPort(req.Host, "443")
+		if target == "" {
+			writeHTTPProxyError(localConn, http.StatusBadRequest)
+			return nil, fmt.Errorf("CONNECT 请求缺少目标地址")
+		}
+		return &httpProxyRequest{target: target, conn: conn}, nil
+	}
+
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		writeHTTPProxyError(localConn, http.StatusBadRequest)
+		return nil, fmt.Errorf("不支持的代理请求 URI: %s", req.RequestURI)
+	}
+
+	target := withDefaultPort(req.URL.Host, "80")
+
+	// 改写为源站形式请求，并去掉仅对代理有意义的头部
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	// 隧道只能把整条连接转发到一个目标，因此要求源站在响应后关闭连接
+	req.Close = true
+
+	return &httpProxyRequest{target: target, conn: conn, request: req}, nil
+}
+
+// start 在隧道建立后完成代理握手：CONNECT 返回 200，普通请求转发原始请求
+func (p *httpProxyRequest) start(remoteConn io.Writer) error {
+	if p.request == nil {
+		_, err := io.WriteString(p.conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		if err != nil {
+			return fmt.Errorf("发送 CONNECT 应答失败: %w", err)
+		}
+		return nil
+	}
+
+	if err := p.request.Write(remoteConn); err != nil {
+		return fmt.Errorf("转发 HTTP 请求失败: %w", err)
+	}
+	return nil
+}
+
+// fail 隧道建立失败时向代理客户端返回错误
+func (p *httpProxyRequest) fail() {
+	writeHTTPProxyError(p.conn, http.StatusBadGateway)
+}
+
+// writeHTTPProxyError 返回简单的 HTTP 错误应答
+func writeHTTPProxyError(w io.Writer, status int) {
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+}
+
+// withDefaultPort 为缺少端口的主机名补全默认端口
+func withDefaultPort(host, port string) string {
+	if host == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	// 去掉 IPv6 字面量的方括号，由 JoinHostPort 统一添加
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, port)
+}
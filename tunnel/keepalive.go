@@ -0,0 +1,240 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 保活与往返时延模块
+// ===============================
+
+// 启用保活的一端按固定间隔在每条隧道连接上发送 PING 控制帧，负载为 8 字节发送时间（相对于连接建立，
+// 纳秒，大端序），对端原样在 PONG 中返回，发送端据此计算往返时延。等待对端的帧超过超时时间时
+// 认为对端已失效，关闭连接。
+
+const (
+	// PING 负载长度
+	pingPayloadSize = 8
+	// 默认失效超时为保活间隔的倍数
+	defaultKeepaliveTimeoutFactor = 3
+)
+
+// rttBuckets 往返时延直方图的桶上限，最后一个桶之外的计入 Overflow
+var rttBuckets = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// KeepaliveOptions 隧道连接保活配置
+type KeepaliveOptions struct {
+	// Interval PING 间隔，0 表示不发送 PING（客户端不启用时也不请求控制帧）
+	Interval time.Duration
+	// Timeout 等待对端的帧超过该时间时关闭连接，0 表示默认 3 倍 Interval
+	Timeout time.Duration
+}
+
+// enabled 是否启用保活
+func (o KeepaliveOptions) enabled() bool {
+	return o.Interval > 0
+}
+
+// timeout 返回失效超时
+func (o KeepaliveOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return o.Interval * defaultKeepaliveTimeoutFactor
+}
+
+// RTTBucket 往返时延直方图的一个桶
+type RTTBucket struct {
+	// UpperBound 桶上限（含）
+	UpperBound time.Duration `json:"le"`
+	// Count 落在上一个桶上限与本桶上限之间的样本数
+	Count uint64 `json:"count"`
+}
+
+// RTTStats 往返时延统计
+type RTTStats struct {
+	Count    uint64        `json:"count"`
+	Last     time.Duration `json:"last"`
+	Min      time.Duration `json:"min"`
+	Max      time.Duration `json:"max"`
+	Sum      time.Duration `json:"sum"`
+	Buckets  []RTTBucket   `json:"buckets,omitempty"`
+	Overflow uint64        `json:"overflow,omitempty"`
+}
+
+// Mean 返回平均往返时延
+func (s RTTStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// String 返回统计摘要
+func (s RTTStats) String() string {
+	if s.Count == 0 {
+		return "无样本"
+	}
+	return fmt.Sprintf("最近 %s，最小 %s，平均 %s，最大 %s（%d 个样本）",
+		s.Last.Round(time.Microsecond), s.Min.Round(time.Microsecond),
+		s.Mean().Round(time.Microsecond), s.Max.Round(time.Microsecond), s.Count)
+}
+
+// rttRecorder 往返时延记录器，样本同时计入上级记录器（隧道汇总）
+type rttRecorder struct {
+	parent *rttRecorder
+
+	mu       sync.Mutex
+	count    uint64
+	last     time.Duration
+	min      time.Duration
+	max      time.Duration
+	sum      time.Duration
+	buckets  []uint64
+	overflow uint64
+}
+
+// newRTTRecorder 创建往返时延记录器
+func newRTTRecorder(parent *rttRecorder) *rttRecorder {
+	return &rttRecorder{parent: parent, buckets: make([]uint64, len(rttBuckets))}
+}
+
+// record 记录一个样本，r 为空时忽略
+func (r *rttRecorder) record(rtt time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.count == 0 || rtt < r.min {
+		r.min = rtt
+	}
+	if rtt > r.max {
+		r.max = rtt
+	}
+	r.count++
+	r.last = rtt
+	r.sum += rtt
+	bucketed := false
+	for i, bound := range rttBuckets {
+		if rtt <= bound {
+			r.buckets[i]++
+			bucketed = true
+			break
+		}
+	}
+	if !bucketed {
+		r.overflow++
+	}
+	r.mu.Unlock()
+
+	r.parent.record(rtt)
+}
+
+// snapshot 返回当前统计，r 为空时返回零值
+func (r *rttRecorder) snapshot() RTTStats {
+	if r == nil {
+		return RTTStats{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := RTTStats{
+		Count:    r.count,
+		Last:     r.last,
+		Min:      r.min,
+		Max:      r.max,
+		Sum:      r.sum,
+		Buckets:  make([]RTTBucket, len(rttBuckets)),
+		Overflow: r.overflow,
+	}
+	for i, bound := range rttBuckets {
+		stats.Buckets[i] = RTTBucket{UpperBound: bound, Count: r.buckets[i]}
+	}
+	return stats
+}
+
+// logRTT 会话结束时记录往返时延统计，没有样本时不记录
+func logRTT(name string, r *rttRecorder) {
+	if stats := r.snapshot(); stats.Count > 0 {
+		log.Printf("[客户端 %s] 往返时延: %s", name, stats)
+	}
+}
+
+// ===============================
+// 连接保活
+// ===============================
+
+// newSessionHandler 创建隧道连接的帧处理器，会话协商启用控制帧时记录往返时延到 rtt 并开始保活
+func newSessionHandler(conn net.Conn, params sessionParams, rtt *rttRecorder, opts *Options) *TCPPacketHandler {
+	h := NewTCPPacketHandler(conn)
+	if params.control {
+		h.enableControl(rtt, opts.Control.RejectUnknown)
+		h.startKeepalive(opts.Keepalive)
+	}
+	return h
+}
+
+// startKeepalive 在启用控制帧的连接上定期发送 PING，等待对端超过超时时间时关闭连接
+func (h *TCPPacketHandler) startKeepalive(opts KeepaliveOptions) {
+	if !h.control || !opts.enabled() {
+		return
+	}
+	go h.keepalive(opts.Interval, opts.timeout())
+}
+
+// keepalive 保活循环，连接的读取结束后退出
+func (h *TCPPacketHandler) keepalive(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
+
+		// 只在读取方正在等待对端时判断超时，本地消费慢导致的读取停顿不算对端失效
+		if start := h.readStart.Load(); start != 0 && time.Since(h.epoch)-time.Duration(start) > timeout {
+			log.Printf("[连接 %s] 超过 %s 没有收到对端的帧，关闭连接", peerName(h.conn), timeout)
+			h.conn.Close()
+			return
+		}
+
+		// 写方向可能因对端停止读取而阻塞在数据帧上，此时跳过本次 PING，不能阻塞上面的超时检查
+		payload := binary.BigEndian.AppendUint64(nil, uint64(time.Since(h.epoch)))
+		if _, err := h.tryWriteTyped(framePing, payload); err != nil {
+			return
+		}
+	}
+}
+
+// handlePong 根据 PONG 负载中的发送时间计算往返时延
+func (h *TCPPacketHandler) handlePong(payload []byte) {
+	if len(payload) != pingPayloadSize {
+		return
+	}
+	sent := time.Duration(binary.BigEndian.Uint64(payload))
+	if rtt := time.Since(h.epoch) - sent; rtt >= 0 {
+		h.rtt.record(rtt)
+	}
+}
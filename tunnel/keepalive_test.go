@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// controlHandler 在连接上创建启用控制帧的帧处理器
+func controlHandler(conn net.Conn, keepalive KeepaliveOptions) *TCPPacketHandler {
+	opts := Options{Keepalive: keepalive}
+	return newSessionHandler(conn, sessionParams{control: true}, newRTTRecorder(nil), &opts)
+}
+
+func TestKeepaliveStalledWriter(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	h := controlHandler(local, KeepaliveOptions{Interval: 20 * time.Millisecond, Timeout: 200 * time.Millisecond})
+
+	// 对端既不读取也不发送：数据帧的写入一直阻塞，保活仍要检测到对端失效并关闭连接
+	go h.WritePacket(make([]byte, 1000))
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := h.ReadPacket()
+		readErr <- err
+	}()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("读取应因连接关闭而失败")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("写方向阻塞时没有检测到对端失效")
+	}
+}
+
+func TestPingWithBlockedWriter(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	h := controlHandler(local, KeepaliveOptions{})
+
+	// 本端的写方向阻塞在数据帧上（对端暂不读取）
+	go h.WritePacket(make([]byte, 1000))
+
+	// 对端发来 PING 后继续发送数据，本端的读取不能因等待写出 PONG 而停止
+	peer := NewTCPPacketHandler(remote)
+	peer.enableControl(nil, false)
+	go func() {
+		peer.writeTyped(framePing, binary.BigEndian.AppendUint64(nil, 1))
+		peer.WritePacket([]byte("data"))
+	}()
+
+	received := make(chan []byte, 1)
+	go func() {
+		data, err := h.ReadPacket()
+		if err == nil {
+			received <- data
+		}
+	}()
+	select {
+	case data := <-received:
+		if string(data) != "data" {
+			t.Fatalf("收到 %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("读取协程被 PONG 的写入阻塞")
+	}
+}
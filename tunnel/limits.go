@@ -0,0 +1,212 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 连接数限制模块
+// ===============================
+
+const (
+	// 接受连接失败后的最短退避时间
+	acceptBackoffMin = 5 * time.Millisecond
+	// 接受连接失败后的最长退避时间
+	acceptBackoffMax = 1 * time.Second
+)
+
+// ConnLimits 监听端的并发连接数和新建连接速率限制，UDP 客户端按本地会话计
+type ConnLimits struct {
+	// MaxConns 全局并发连接数上限，0 表示不限制
+	MaxConns int
+	// MaxConnsPerIP 每个来源 IP 的并发连接数上限，0 表示不限制
+	MaxConnsPerIP int
+	// AcceptRate 每秒接受的新连接数上限（允许同样数量的突发），0 表示不限制
+	AcceptRate float64
+	// QueueTimeout 超过全局并发数或新建速率时暂停接受新连接、等待名额的最长时间，超时后拒绝；
+	// 0 表示立即拒绝。每个来源 IP 的上限和 UDP 客户端的新会话总是立即拒绝
+	QueueTimeout time.Duration
+}
+
+// enabled 是否设置了任一限制
+func (l ConnLimits) enabled() bool {
+	return l.MaxConns > 0 || l.MaxConnsPerIP > 0 || l.AcceptRate > 0
+}
+
+// errConnLimit 超过连接数限制
+var errConnLimit = errors.New("超过连接数限制")
+
+// connLimiter 并发连接数和新建速率限制器
+type connLimiter struct {
+	limits ConnLimits
+	slots  chan struct{} // 全局名额，未限制时为 nil
+	rate   *tokenBucket  // 新建速率，未限制时为 nil
+
+	mu    sync.Mutex
+	perIP map[netip.Addr]int
+}
+
+// newConnLimiter 创建连接数限制器，未设置限制时返回 nil
+func newConnLimiter(limits ConnLimits) *connLimiter {
+	if !limits.enabled() {
+		return nil
+	}
+	l := &connLimiter{limits: limits, perIP: make(map[netip.Addr]int)}
+	if limits.MaxConns > 0 {
+		l.slots = make(chan struct{}, limits.MaxConns)
+	}
+	if limits.AcceptRate > 0 {
+		l.rate = newTokenBucket(limits.AcceptRate, 1)
+	}
+	return l
+}
+
+// acquire 为来源地址为 addr 的新连接占用名额，返回释放名额的函数（可重复调用）。
+// queue 为 true 时按 QueueTimeout 等待全局名额和新建速率，否则超限立即返回错误；l 为空时不限制
+func (l *connLimiter) acquire(ctx context.Context, addr net.Addr, queue bool) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	var deadline <-chan time.Time
+	if queue && l.limits.QueueTimeout > 0 {
+		timer := time.NewTimer(l.limits.QueueTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	if err := l.waitRate(ctx, deadline); err != nil {
+		return nil, err
+	}
+
+	ip := addrPortOf(addr).Addr().Unmap()
+	trackIP := l.limits.MaxConnsPerIP > 0 && ip.IsValid()
+	if trackIP {
+		l.mu.Lock()
+		if n := l.perIP[ip]; n >= l.limits.MaxConnsPerIP {
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: 来源 %s 已有 %d 个并发连接", errConnLimit, ip, n)
+		}
+		l.perIP[ip]++
+		l.mu.Unlock()
+	}
+
+	if err := l.waitSlot(ctx, deadline); err != nil {
+		if trackIP {
+			l.releaseIP(ip)
+		}
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if l.slots != nil {
+				<-l.slots
+			}
+			if trackIP {
+				l.releaseIP(ip)
+			}
+		})
+	}, nil
+}
+
+// waitRate 按新建速率取出一个令牌，deadline 为空时不等待
+func (l *connLimiter) waitRate(ctx context.Context, deadline <-chan time.Time) error {
+	if l.rate == nil {
+		return nil
+	}
+	for {
+		wait := l.rate.tryTake(1)
+		if wait == 0 {
+			return nil
+		}
+		if deadline == nil {
+			return fmt.Errorf("%w: 新建连接超过每秒 %g 个", errConnLimit, l.limits.AcceptRate)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-deadline:
+			timer.Stop()
+			return fmt.Errorf("%w: 等待 %s 后新建连接仍超过每秒 %g 个", errConnLimit, l.limits.QueueTimeout, l.limits.AcceptRate)
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// waitSlot 占用一个全局名额，deadline 为空时不等待
+func (l *connLimiter) waitSlot(ctx context.Context, deadline <-chan time.Time) error {
+	if l.slots == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if deadline == nil {
+		return fmt.Errorf("%w: 并发连接数已达上限 %d", errConnLimit, l.limits.MaxConns)
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-deadline:
+		return fmt.Errorf("%w: 等待 %s 后并发连接数仍达上限 %d", errConnLimit, l.limits.QueueTimeout, l.limits.MaxConns)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseIP 释放来源 IP 的一个名额
+func (l *connLimiter) releaseIP(ip netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+}
+
+// acceptBackoff 接受连接失败（例如文件描述符耗尽 EMFILE）时的退避，避免空转
+type acceptBackoff struct {
+	delay time.Duration
+}
+
+// next 返回下一次失败后的等待时间：从 5ms 开始倍增，最长 1s
+func (b *acceptBackoff) next() time.Duration {
+	b.delay *= 2
+	if b.delay < acceptBackoffMin {
+		b.delay = acceptBackoffMin
+	}
+	if b.delay > acceptBackoffMax {
+		b.delay = acceptBackoffMax
+	}
+	return b.delay
+}
+
+// wait 等待 next 返回的时间，done 关闭时提前返回
+func (b *acceptBackoff) wait(done <-chan struct{}) {
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+}
+
+// reset 接受成功后重置退避时间
+func (b *acceptBackoff) reset() {
+	b.delay = 0
+}
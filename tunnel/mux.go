@@ -0,0 +1,789 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 多路复用模块
+// ===============================
+
+// TCP 隧道客户端在会话头中用 mux 字段请求多路复用（值为本端每个流的接收窗口），服务端在回复的会话头中
+// 给出自己的接收窗口。多路复用需要控制帧，此后一条隧道连接承载多个流，每个流对应一个本地 TCP 连接：
+//   - OPEN：客户端打开流，流编号为递增的奇数，负载中的元数据即该流的会话头字段（target、src）
+//   - DATA：负载为 4 字节流编号 + 数据（启用压缩时为压缩后的数据）
+//   - CLOSE（流编号非 0）：发送方不再发送数据（半关闭），双方都半关闭后流结束
+//   - ERROR（流编号非 0）：重置流，双方立即丢弃该流，原因码说明重置的原因
+//   - WINDOW_UPDATE：接收方读走数据后把相应的发送窗口归还给发送方
+//
+// 发送方在流的发送窗口用完时阻塞，接收方为每个流最多缓存一个窗口的数据，因此一个流的消费者停止读取
+// 不会阻塞同一连接上的其他流。每个流同时最多有一个数据帧在发送队列中，队列按先后顺序发送，
+// 各流因此轮流占用连接；控制帧优先于数据帧发送。流支持读写超时：读超时限制等待数据的时间，
+// 写超时限制等待发送窗口和发送队列的时间，超时返回 os.ErrDeadlineExceeded。
+
+const (
+	// 默认每个流的接收窗口
+	defaultMuxWindow = 256 * 1024
+	// 默认每条隧道连接的流数上限
+	defaultMuxMaxStreams = 256
+	// 控制帧发送队列长度
+	muxControlQueue = 64
+)
+
+// MuxOptions TCP 隧道多路复用配置
+type MuxOptions struct {
+	// Enabled 客户端把本地连接作为流复用到共享的隧道连接上；服务端总是接受客户端的请求
+	Enabled bool
+	// Window 本端每个流的接收窗口（字节），0 表示默认 256KB
+	Window uint32
+	// MaxStreams 每条隧道连接同时打开的流数上限，0 表示默认 256。客户端达到上限时建立新的隧道连接，
+	// 服务端拒绝超出上限的流
+	MaxStreams int
+}
+
+// window 返回每个流的接收窗口
+func (o MuxOptions) window() uint32 {
+	if o.Window > 0 {
+		return o.Window
+	}
+	return defaultMuxWindow
+}
+
+// maxStreams 返回每条隧道连接的流数上限
+func (o MuxOptions) maxStreams() int {
+	if o.MaxStreams > 0 {
+		return o.MaxStreams
+	}
+	return defaultMuxMaxStreams
+}
+
+// parseMuxWindow 解析会话头中的接收窗口
+func parseMuxWindow(value string) (uint32, error) {
+	window, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || window == 0 {
+		return 0, fmt.Errorf("无效的多路复用接收窗口: %q", value)
+	}
+	return uint32(window), nil
+}
+
+var (
+	// errStreamClosed 流已被本端关闭
+	errStreamClosed = errors.New("流已关闭")
+	// errMuxUnavailable 隧道连接已关闭、收到 GOAWAY 或流数已满，不能再打开流
+	errMuxUnavailable = errors.New("隧道连接不能再打开新的流")
+)
+
+// muxFrame 待发送的帧
+type muxFrame struct {
+	frameType byte
+	payload   []byte
+	done      chan error // 写入完成后通知发送方，可为空
+}
+
+// muxSession 一条多路复用的隧道连接
+type muxSession struct {
+	conn       net.Conn
+	packets    *TCPPacketHandler
+	codec      *frameCodec // 帧压缩，可为空
+	window     uint32      // 本端每个流的接收窗口
+	peerWindow uint32      // 对端每个流的接收窗口，即本端每个流的初始发送窗口
+	maxStreams int
+	onOpen     func(stream *muxStream) // 服务端：对端打开流时在新协程中调用
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+	nextID  uint32
+	goaway  bool
+	err     error
+
+	data       chan muxFrame
+	control    chan muxFrame
+	done       chan struct{}
+	closeOnce  sync.Once
+	goawayOnce sync.Once
+}
+
+// newMuxSession 在已协商多路复用的隧道连接上创建会话并开始发送，调用方需要调用 serve 开始接收
+func newMuxSession(conn net.Conn, packets *TCPPacketHandler, params sessionParams, opts MuxOptions) *muxSession {
+	m := &muxSession{
+		conn:       conn,
+		packets:    packets,
+		codec:      params.codec,
+		window:     opts.window(),
+		peerWindow: params.mux,
+		maxStreams: opts.maxStreams(),
+		streams:    make(map[uint32]*muxStream),
+		data:       make(chan muxFrame),
+		control:    make(chan muxFrame, muxControlQueue),
+		done:       make(chan struct{}),
+	}
+	packets.onStream = m.handleControl
+	go m.writeLoop()
+	return m
+}
+
+// serve 接收帧并分发到各个流，连接关闭后返回
+func (m *muxSession) serve() {
+	for {
+		payload, err := m.packets.ReadPacket()
+		if err != nil {
+			m.close(err)
+			return
+		}
+		if len(payload) < streamIDSize {
+			m.close(errors.New("多路复用数据帧缺少流编号"))
+			return
+		}
+
+		id := binary.BigEndian.Uint32(payload)
+		data := payload[streamIDSize:]
+		if m.codec != nil {
+			if data, err = m.codec.decode(data); err != nil {
+				m.close(err)
+				return
+			}
+		}
+		// 已重置的流可能还有在途的数据，直接丢弃
+		if stream := m.stream(id); stream != nil {
+			if err := stream.receive(data); err != nil {
+				go stream.reset(ReasonProtocolError, err.Error())
+			}
+		}
+	}
+}
+
+// writeLoop 发送队列中的帧，控制帧优先
+func (m *muxSession) writeLoop() {
+	for {
+		var f muxFrame
+		select {
+		case f = <-m.control:
+		default:
+			select {
+			case f = <-m.control:
+			case f = <-m.data:
+			case <-m.done:
+				return
+			}
+		}
+
+		err := m.packets.writeTyped(f.frameType, f.payload)
+		if f.done != nil {
+			f.done <- err
+		}
+		if err != nil {
+			m.close(err)
+			return
+		}
+	}
+}
+
+// send 把帧放入 queue，帧带有 done 时等待写入完成
+func (m *muxSession) send(f muxFrame, queue chan muxFrame) error {
+	_, err := m.sendDeadline(f, queue, time.Time{})
+	return err
+}
+
+// sendDeadline 与 send 相同，deadline 非零时超时返回 os.ErrDeadlineExceeded；
+// queued 表示帧已进入队列，超时之后仍会被发送
+func (m *muxSession) sendDeadline(f muxFrame, queue chan muxFrame, deadline time.Time) (queued bool, err error) {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case queue <- f:
+	case <-m.done:
+		return false, m.closeErr()
+	case <-expired:
+		return false, os.ErrDeadlineExceeded
+	}
+	if f.done == nil {
+		return true, nil
+	}
+	select {
+	case err := <-f.done:
+		return true, err
+	case <-m.done:
+		return true, m.closeErr()
+	case <-expired:
+		return true, os.ErrDeadlineExceeded
+	}
+}
+
+// sendControl 发送流的控制帧，不等待写入完成
+func (m *muxSession) sendControl(f controlFrame) {
+	m.send(muxFrame{frameType: f.frameType, payload: encodeControl(f)}, m.control)
+}
+
+// handleControl 处理流编号非 0 的控制帧，在读取协程中调用
+func (m *muxSession) handleControl(f controlFrame) {
+	if f.frameType == frameOpen {
+		m.accept(f)
+		return
+	}
+
+	stream := m.stream(f.stream)
+	if stream == nil {
+		return
+	}
+	switch f.frameType {
+	case frameClose:
+		stream.remoteClose()
+	case frameError:
+		stream.remoteReset(&CloseError{Code: f.code, Message: f.text})
+	case frameWindowUpdate:
+		stream.addSendWindow(f.window)
+	}
+}
+
+// accept 服务端处理对端打开的流
+func (m *muxSession) accept(f controlFrame) {
+	reject := func(code ReasonCode, message string) {
+		go m.sendControl(controlFrame{frameType: frameError, stream: f.stream, code: code, text: message})
+	}
+	if m.onOpen == nil || f.stream%2 == 0 {
+		reject(ReasonProtocolError, "只能由客户端打开奇数编号的流")
+		return
+	}
+	values, err := url.ParseQuery(string(f.data))
+	if err != nil {
+		reject(ReasonProtocolError, "无效的流元数据")
+		return
+	}
+
+	m.mu.Lock()
+	if _, exists := m.streams[f.stream]; exists || f.stream <= m.nextID {
+		m.mu.Unlock()
+		reject(ReasonProtocolError, "重复的流编号")
+		return
+	}
+	if len(m.streams) >= m.maxStreams {
+		m.mu.Unlock()
+		reject(ReasonRejected, fmt.Sprintf("超过每条连接 %d 个流的上限", m.maxStreams))
+		return
+	}
+	m.nextID = f.stream
+	stream := newMuxStream(m, f.stream)
+	stream.meta = &SessionMeta{values: values}
+	m.streams[f.stream] = stream
+	m.mu.Unlock()
+
+	go m.onOpen(stream)
+}
+
+// open 客户端打开一个流，元数据中的字段作为该流的会话头
+func (m *muxSession) open(meta *SessionMeta) (*muxStream, error) {
+	m.mu.Lock()
+	if m.err != nil || m.goaway || len(m.streams) >= m.maxStreams {
+		m.mu.Unlock()
+		return nil, errMuxUnavailable
+	}
+	m.nextID += 2
+	stream := newMuxStream(m, m.nextID-1)
+	m.streams[stream.id] = stream
+	m.mu.Unlock()
+
+	// 控制帧优先发送，OPEN 总是先于该流的数据帧
+	f := controlFrame{frameType: frameOpen, stream: stream.id, data: []byte(meta.values.Encode())}
+	if err := m.send(muxFrame{frameType: frameOpen, payload: encodeControl(f)}, m.control); err != nil {
+		m.remove(stream.id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// available 是否还可以打开新的流
+func (m *muxSession) available() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err == nil && !m.goaway && len(m.streams) < m.maxStreams
+}
+
+// markGoaway 收到 GOAWAY 后不再在该连接上打开新的流，已有的流继续传输
+func (m *muxSession) markGoaway() {
+	m.mu.Lock()
+	m.goaway = true
+	m.mu.Unlock()
+}
+
+// sendGoaway 通知客户端不再在该连接上打开新的流，重复调用只发送一次
+func (m *muxSession) sendGoaway(alternate string) {
+	m.goawayOnce.Do(func() { m.packets.sendGoaway(alternate) })
+}
+
+// stream 按编号查找流
+func (m *muxSession) stream(id uint32) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+// remove 移除已结束的流
+func (m *muxSession) remove(id uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, id)
+}
+
+// close 关闭隧道连接并结束所有流，重复调用无副作用
+func (m *muxSession) close(err error) {
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		m.err = err
+		streams := make([]*muxStream, 0, len(m.streams))
+		for _, stream := range m.streams {
+			streams = append(streams, stream)
+		}
+		m.streams = make(map[uint32]*muxStream)
+		m.mu.Unlock()
+
+		close(m.done)
+		m.conn.Close()
+		for _, stream := range streams {
+			stream.fail(err)
+		}
+	})
+}
+
+// closeWithReason 通知对端关闭原因后关闭隧道连接和所有流
+func (m *muxSession) closeWithReason(code ReasonCode, message string) {
+	m.packets.sendClose(code, message)
+	m.close(&CloseError{Code: code, Message: message})
+}
+
+// closed 连接已关闭时返回 true
+func (m *muxSession) closed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeErr 返回连接关闭的原因
+func (m *muxSession) closeErr() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		return net.ErrClosed
+	}
+	return m.err
+}
+
+// ===============================
+// 流
+// ===============================
+
+// muxStream 多路复用连接上的一个流，实现 net.Conn
+type muxStream struct {
+	session *muxSession
+	id      uint32
+	meta    *SessionMeta // 服务端：OPEN 帧中的元数据
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	buf         []byte // 已收到、尚未读取的数据
+	unacked     uint32 // 已读取、尚未归还窗口的字节数
+	sendWindow  uint32
+	localClosed bool  // 本端已半关闭
+	peerClosed  bool  // 对端已半关闭
+	err         error // 流被重置、关闭或连接已关闭
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer // 读超时到达时唤醒等待中的读取
+	writeTimer    *time.Timer // 写超时到达时唤醒等待发送窗口的写入
+}
+
+// newMuxStream 创建流
+func newMuxStream(m *muxSession, id uint32) *muxStream {
+	s := &muxStream{session: m, id: id, sendWindow: m.peerWindow}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Read 读取流的数据，对端半关闭且数据读完后返回 io.EOF，流被对端重置时返回 *CloseError
+func (s *muxStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 && !s.peerClosed && s.err == nil {
+		if pastDeadline(s.readDeadline) {
+			s.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return 0, err
+	}
+	if len(s.buf) == 0 {
+		s.mu.Unlock()
+		return 0, io.EOF
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	s.unacked += uint32(n)
+	var update uint32
+	if s.unacked >= s.session.window/2 && !s.peerClosed {
+		update, s.unacked = s.unacked, 0
+	}
+	s.mu.Unlock()
+
+	if update > 0 {
+		s.session.sendControl(controlFrame{frameType: frameWindowUpdate, stream: s.id, window: update})
+	}
+	return n, nil
+}
+
+// receive 缓存收到的数据，超过接收窗口时返回错误
+func (s *muxStream) receive(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	if s.peerClosed {
+		return errors.New("对端半关闭后继续发送数据")
+	}
+	if uint64(len(s.buf))+uint64(s.unacked)+uint64(len(data)) > uint64(s.session.window) {
+		return fmt.Errorf("对端超过流的接收窗口 %d 字节", s.session.window)
+	}
+	s.buf = append(s.buf, data...)
+	s.cond.Broadcast()
+	return nil
+}
+
+// Write 在发送窗口内分段发送数据，窗口用完时等待对端归还
+func (s *muxStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		s.mu.Lock()
+		for s.sendWindow == 0 && s.err == nil && !s.localClosed {
+			if pastDeadline(s.writeDeadline) {
+				s.mu.Unlock()
+				return written, os.ErrDeadlineExceeded
+			}
+			s.cond.Wait()
+		}
+		if s.err != nil || s.localClosed {
+			err := s.err
+			if err == nil {
+				err = errStreamClosed
+			}
+			s.mu.Unlock()
+			return written, err
+		}
+		if pastDeadline(s.writeDeadline) {
+			s.mu.Unlock()
+			return written, os.ErrDeadlineExceeded
+		}
+		n := min(len(p)-written, framedChunkSize, int(s.sendWindow))
+		s.sendWindow -= uint32(n)
+		deadline := s.writeDeadline
+		s.mu.Unlock()
+
+		chunk := p[written : written+n]
+		if s.session.codec != nil {
+			chunk = s.session.codec.encode(chunk)
+		}
+		payload := make([]byte, 0, streamIDSize+len(chunk))
+		payload = binary.BigEndian.AppendUint32(payload, s.id)
+		payload = append(payload, chunk...)
+		queued, err := s.session.sendDeadline(muxFrame{frameType: frameData, payload: payload, done: make(chan error, 1)}, s.session.data, deadline)
+		if queued {
+			written += n
+		} else {
+			// 数据帧没有发出，归还占用的发送窗口
+			s.addSendWindow(uint32(n))
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// addSendWindow 对端归还发送窗口
+func (s *muxStream) addSendWindow(n uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if uint64(s.sendWindow)+uint64(n) > 1<<31 {
+		return
+	}
+	s.sendWindow += n
+	s.cond.Broadcast()
+}
+
+// CloseWrite 半关闭：通知对端本端不再发送数据
+func (s *muxStream) CloseWrite() error {
+	s.mu.Lock()
+	if s.err != nil || s.localClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	finished := s.peerClosed
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	// 与数据帧走同一队列，保证 CLOSE 在本流已发送的数据之后
+	f := controlFrame{frameType: frameClose, stream: s.id, code: ReasonNormal}
+	err := s.session.send(muxFrame{frameType: frameClose, payload: encodeControl(f), done: make(chan error, 1)}, s.session.data)
+	if finished {
+		s.session.remove(s.id)
+	}
+	return err
+}
+
+// remoteClose 对端半关闭
+func (s *muxStream) remoteClose() {
+	s.mu.Lock()
+	s.peerClosed = true
+	finished := s.localClosed
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if finished {
+		s.session.remove(s.id)
+	}
+}
+
+// Close 关闭流：双方都已半关闭时直接结束，否则重置流，对端丢弃该流上尚未处理的数据
+func (s *muxStream) Close() error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	finished := s.localClosed && s.peerClosed
+	s.mu.Unlock()
+
+	if finished {
+		s.fail(errStreamClosed)
+		s.session.remove(s.id)
+		return nil
+	}
+	s.reset(ReasonNormal, "")
+	return nil
+}
+
+// reset 重置流并通知对端原因
+func (s *muxStream) reset(code ReasonCode, message string) {
+	if !s.fail(errStreamClosed) {
+		return
+	}
+	s.session.remove(s.id)
+	s.session.sendControl(controlFrame{frameType: frameError, stream: s.id, code: code, text: message})
+}
+
+// remoteReset 对端重置了流
+func (s *muxStream) remoteReset(err *CloseError) {
+	s.fail(err)
+	s.session.remove(s.id)
+}
+
+// fail 结束流并唤醒等待中的读写，返回是否为首次结束
+func (s *muxStream) fail(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false
+	}
+	s.err = err
+	s.buf = nil
+	s.cond.Broadcast()
+	return true
+}
+
+// LocalAddr 返回隧道连接的本地地址
+func (s *muxStream) LocalAddr() net.Addr {
+	return s.session.conn.LocalAddr()
+}
+
+// RemoteAddr 返回隧道连接的对端地址
+func (s *muxStream) RemoteAddr() net.Addr {
+	return s.session.conn.RemoteAddr()
+}
+
+// SetDeadline 设置读写超时
+func (s *muxStream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读超时，零值表示不超时
+func (s *muxStream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	s.readTimer = s.resetTimer(s.readTimer, t)
+	return nil
+}
+
+// SetWriteDeadline 设置写超时，零值表示不超时
+func (s *muxStream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	s.writeTimer = s.resetTimer(s.writeTimer, t)
+	return nil
+}
+
+// resetTimer 停止原有的定时器，到达新的截止时间时唤醒等待者，调用方需持有锁
+func (s *muxStream) resetTimer(timer *time.Timer, deadline time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	// 立即唤醒，等待者按新的截止时间重新检查
+	s.cond.Broadcast()
+	if deadline.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(deadline), func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+}
+
+// pastDeadline 截止时间已到时返回 true，零值表示不超时
+func pastDeadline(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// ===============================
+// 隧道连接池
+// ===============================
+
+// muxPool 多路复用隧道连接的集合：客户端在流数达到上限或收到 GOAWAY 时建立新的连接，
+// 服务端记录已接受的连接，关闭时通知对端
+type muxPool struct {
+	mu       sync.Mutex
+	sessions []*muxSession
+	dial     func() (*muxSession, error) // 客户端建立新的隧道连接，服务端为空
+	dialing  *muxDial                    // 正在建立的隧道连接，没有时为空
+}
+
+// muxDial 正在建立的隧道连接。拨号在 muxPool.mu 之外进行（最长可达拨号超时），
+// 同时需要新连接的 open 等待同一次拨号，完成后关闭 done
+type muxDial struct {
+	done chan struct{}
+	err  error
+}
+
+// open 在可用的隧道连接上打开流，没有可用连接时建立新的连接
+func (p *muxPool) open(meta *SessionMeta) (*muxStream, error) {
+	for {
+		for _, m := range p.live() {
+			if stream, err := m.open(meta); err == nil {
+				return stream, nil
+			}
+		}
+
+		p.mu.Lock()
+		d := p.dialing
+		if d != nil {
+			// 其他 open 正在建立连接，等待完成后重新选择
+			p.mu.Unlock()
+			<-d.done
+			if d.err != nil {
+				return nil, d.err
+			}
+			continue
+		}
+		d = &muxDial{done: make(chan struct{})}
+		p.dialing = d
+		p.mu.Unlock()
+
+		m, err := p.dial()
+		p.mu.Lock()
+		p.dialing = nil
+		if err == nil {
+			p.sessions = append(p.sessions, m)
+			log.Printf("建立新的多路复用隧道连接 %s，当前 %d 条", peerName(m.conn), len(p.sessions))
+		}
+		p.mu.Unlock()
+		d.err = err
+		close(d.done)
+		if err != nil {
+			return nil, err
+		}
+		return m.open(meta)
+	}
+}
+
+// live 移除已关闭的隧道连接，返回其余连接的副本
+func (p *muxPool) live() []*muxSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	live := p.sessions[:0]
+	for _, m := range p.sessions {
+		if !m.closed() {
+			live = append(live, m)
+		}
+	}
+	clear(p.sessions[len(live):])
+	p.sessions = live
+	return append([]*muxSession(nil), live...)
+}
+
+// retire 对端地址不在 addrs 中的隧道连接不再打开新的流，已打开的流继续传输，返回受影响的连接数
+func (p *muxPool) retire(addrs []netip.Addr) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	retired := 0
+	for _, m := range p.sessions {
+		if !m.closed() && addrMoved(addrs, m.conn.RemoteAddr()) {
+			m.markGoaway()
+			retired++
+		}
+	}
+	return retired
+}
+
+// add 记录服务端接受的隧道连接
+func (p *muxPool) add(m *muxSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions = append(p.sessions, m)
+}
+
+// remove 移除已关闭的隧道连接
+func (p *muxPool) remove(m *muxSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, session := range p.sessions {
+		if session == m {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// closeAll 通知对端关闭原因后关闭所有隧道连接
+func (p *muxPool) closeAll(code ReasonCode, message string) {
+	p.mu.Lock()
+	sessions := p.sessions
+	p.sessions = nil
+	p.mu.Unlock()
+	for _, m := range sessions {
+		m.closeWithReason(code, message)
+	}
+}
@@ -0,0 +1,244 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testMuxWindow = 16 * 1024
+
+// muxPair 在 net.Pipe 上建立一对多路复用会话，服务端打开的流放入返回的通道
+func muxPair(t *testing.T) (*muxSession, <-chan *muxStream) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	params := sessionParams{control: true, mux: testMuxWindow}
+	opts := Options{Mux: MuxOptions{Window: testMuxWindow}}
+
+	client := newMuxSession(clientConn, newSessionHandler(clientConn, params, newRTTRecorder(nil), &opts), params, opts.Mux)
+	server := newMuxSession(serverConn, newSessionHandler(serverConn, params, newRTTRecorder(nil), &opts), params, opts.Mux)
+	accepted := make(chan *muxStream, 16)
+	server.onOpen = func(stream *muxStream) { accepted <- stream }
+	go client.serve()
+	go server.serve()
+	t.Cleanup(func() {
+		client.close(net.ErrClosed)
+		server.close(net.ErrClosed)
+	})
+	return client, accepted
+}
+
+// openPair 客户端打开一个流并等待服务端接受
+func openPair(t *testing.T, client *muxSession, accepted <-chan *muxStream) (*muxStream, *muxStream) {
+	t.Helper()
+	stream, err := client.open(NewSessionMeta())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case peer := <-accepted:
+		return stream, peer
+	case <-time.After(5 * time.Second):
+		t.Fatal("服务端没有收到 OPEN")
+		return nil, nil
+	}
+}
+
+// randomBytes 生成 n 字节的随机数据
+func randomBytes(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestMuxWindowExhaustion(t *testing.T) {
+	client, accepted := muxPair(t)
+	stream, peer := openPair(t, client, accepted)
+
+	// 对端不读取时最多发送一个接收窗口的数据
+	payload := randomBytes(4*testMuxWindow, 1)
+	stream.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	written, err := stream.Write(payload)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("窗口用完时写入应超时，实际 %v", err)
+	}
+	if written != testMuxWindow {
+		t.Fatalf("窗口用完前写入 %d 字节，期望 %d 字节", written, testMuxWindow)
+	}
+
+	// 对端读取后归还窗口，剩余数据继续发送
+	stream.SetWriteDeadline(time.Time{})
+	errs := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(payload[written:])
+		errs <- err
+	}()
+	received := make([]byte, len(payload))
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(peer, received); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("收到的数据与发送的不一致")
+	}
+}
+
+func TestMuxStalledStream(t *testing.T) {
+	client, accepted := muxPair(t)
+	stalled, _ := openPair(t, client, accepted)
+	stream, peer := openPair(t, client, accepted)
+
+	// 一个流的消费者不读取，发送方阻塞在该流的窗口上
+	go stalled.Write(randomBytes(4*testMuxWindow, 1))
+
+	// 同一连接上的其他流不受影响
+	payload := randomBytes(8*testMuxWindow, 2)
+	go stream.Write(payload)
+	received := make([]byte, len(payload))
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(peer, received); err != nil {
+		t.Fatalf("被阻塞的流影响了其他流: %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("收到的数据与发送的不一致")
+	}
+}
+
+func TestMuxCloseAfterData(t *testing.T) {
+	client, accepted := muxPair(t)
+	stream, peer := openPair(t, client, accepted)
+
+	// CLOSE 在本流已发送的数据之后到达，对端读完数据后才读到 EOF
+	payload := randomBytes(3*testMuxWindow+123, 1)
+	go func() {
+		stream.Write(payload)
+		stream.CloseWrite()
+	}()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatalf("EOF 前收到 %d 字节，期望 %d 字节", len(received), len(payload))
+	}
+
+	// 半关闭后另一个方向仍可发送
+	if _, err := peer.Write([]byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	peer.CloseWrite()
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(stream)
+	if err != nil || string(reply) != "reply" {
+		t.Fatalf("收到 %q, %v", reply, err)
+	}
+}
+
+func TestMuxReset(t *testing.T) {
+	client, accepted := muxPair(t)
+	stream, peer := openPair(t, client, accepted)
+
+	// 服务端重置流，客户端读取时得到原因
+	peer.reset(ReasonRejected, "测试")
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := stream.Read(make([]byte, 1))
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != ReasonRejected {
+		t.Fatalf("读取应返回重置原因，实际 %v", err)
+	}
+	if _, err := peer.Write([]byte("x")); err == nil {
+		t.Fatal("重置后写入应失败")
+	}
+
+	// 重置的流不影响新打开的流
+	stream, peer = openPair(t, client, accepted)
+	go stream.Write([]byte("hello"))
+	received := make([]byte, 5)
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(peer, received); err != nil || string(received) != "hello" {
+		t.Fatalf("收到 %q, %v", received, err)
+	}
+}
+
+func TestMuxReadDeadline(t *testing.T) {
+	client, accepted := muxPair(t)
+	stream, peer := openPair(t, client, accepted)
+
+	peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := peer.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("没有数据时读取应超时，实际 %v", err)
+	}
+
+	// 清除超时后照常读取
+	peer.SetReadDeadline(time.Time{})
+	go stream.Write([]byte("x"))
+	if n, err := peer.Read(make([]byte, 1)); n != 1 || err != nil {
+		t.Fatalf("读取 %d 字节, %v", n, err)
+	}
+}
+
+func TestMuxPoolRetireNonIP(t *testing.T) {
+	client, _ := muxPair(t)
+	pool := &muxPool{}
+	pool.add(client)
+
+	// net.Pipe 的对端地址不是 IP 地址，不能据此判断服务端地址已变化
+	if retired := pool.retire([]netip.Addr{netip.MustParseAddr("192.0.2.1")}); retired != 0 {
+		t.Fatalf("对端地址不是 IP 的连接被标记为过期: %d", retired)
+	}
+	if !client.available() {
+		t.Fatal("连接不应收到 GOAWAY 标记")
+	}
+}
+
+func TestMuxPoolDialOutsideLock(t *testing.T) {
+	client, _ := muxPair(t)
+	release := make(chan struct{})
+	var dials atomic.Int32
+	pool := &muxPool{dial: func() (*muxSession, error) {
+		dials.Add(1)
+		<-release
+		return client, nil
+	}}
+
+	// 拨号期间不持有连接池的锁，同时打开的流等待同一次拨号
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := pool.open(NewSessionMeta())
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		pool.retire(nil)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("拨号期间连接池的锁被占用")
+	}
+
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("拨号 %d 次，期望 1 次", n)
+	}
+}
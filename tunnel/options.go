@@ -0,0 +1,166 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+// ===============================
+// 配置模块
+// ===============================
+
+// Dialer 建立出站连接的拨号器，*net.Dialer 满足该接口
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Options 隧道配置，四种隧道角色共用，不适用于某个角色的字段会被忽略
+type Options struct {
+	// LocalAddr 本地监听地址：客户端为 UDP/TCP 监听地址，服务端为隧道 TCP 监听地址
+	LocalAddr string
+	// RemoteAddr 远程地址：客户端为隧道服务端地址，服务端为默认目标地址
+	RemoteAddr string
+
+	// Listener 注入的 TCP 监听器，非空时忽略 LocalAddr（TunnelServer、TCPTunnelClient、TCPTunnelServer）
+	Listener net.Listener
+	// PacketConn 注入的数据报套接字，非空时忽略 LocalAddr（TunnelClient）
+	PacketConn net.PacketConn
+	// SocketFile 创建的 Unix 套接字文件的权限和属主
+	SocketFile SocketFileOptions
+	// Dialer TCP 目标连接及默认 TCP 传输使用的拨号器，为空时使用应用了 Socket 和 Bind 选项、带超时的拨号器
+	Dialer Dialer
+	// Socket 隧道创建的 TCP 和 UDP 套接字的选项：保活、TCP_NODELAY、缓冲区、SO_MARK 和 DSCP
+	Socket SocketOptions
+	// Bind 出站连接的源 IP、源端口范围和网络接口：客户端为隧道连接，服务端为目标连接
+	Bind BindOptions
+	// Family 本地端点（监听）和远程端点（客户端的隧道连接，服务端的目标连接）的地址族
+	Family FamilyOptions
+	// Resolver 远程端点主机名的解析器：按 TTL 缓存并在解析结果变化后迁移会话，为空时每次拨号通过系统解析器解析
+	Resolver *Resolver
+	// Transport 隧道连接的传输层，为空时使用 TCPTransport
+	Transport Transport
+
+	// Hooks 会话事件回调
+	Hooks Hooks
+
+	// HTTPProxy TCP 客户端作为 HTTP 代理接受 CONNECT 和绝对 URI 请求
+	HTTPProxy bool
+	// TProxy 客户端透明代理模式（仅 Linux）
+	TProxy bool
+	// DynamicTarget 服务端允许客户端在会话头中指定目标地址
+	DynamicTarget bool
+	// AcceptProxyProtocol TCP 监听端解析入站连接的 PROXY 协议头；服务端只能与 TCPTransport 同时使用
+	AcceptProxyProtocol bool
+	// ProxyProtocol TCP 服务端向目标发送的 PROXY 协议版本
+	ProxyProtocol int
+
+	// OriginAllow UDP 服务端允许的原始客户端网段，为空表示不限制。
+	//
+	// 原始客户端地址来自隧道客户端发送的会话头，服务端无法验证：OriginAllow 和 OriginRate
+	// 只在隧道客户端可信时有效（例如 TLS 传输并用 CA 校验客户端证书），不可信的客户端可以伪造地址。
+	// 会话头中没有原始客户端地址时 OriginAllow 拒绝会话，OriginRate 按隧道连接的地址限速
+	OriginAllow []netip.Prefix
+	// OriginRate UDP 服务端每个原始客户端 IP 每秒允许的数据包数，0 表示不限制
+	OriginRate float64
+	// OriginHeader UDP 服务端向目标发送的数据报前附加来源地址头
+	OriginHeader bool
+
+	// FEC UDP 客户端请求的前向纠错分片数；服务端按客户端请求启用，只使用其中的 FlushTimeout
+	FEC FECOptions
+	// Bond UDP 客户端的多路径绑定配置；服务端按会话头自动合并同一绑定会话的连接
+	Bond BondOptions
+	// Compression 隧道帧压缩：客户端提供的算法或服务端接受的算法，以及压缩阈值
+	Compression CompressionOptions
+
+	// RateLimits 本端发往隧道的流量限速：UDP 超限丢弃，TCP 超限延迟
+	RateLimits RateLimits
+	// Accounting 服务端按客户端证书身份统计流量并执行配额，为空表示不统计
+	Accounting *Accounting
+	// ACL 监听端按对端 IP 的访问控制：客户端检查本地 UDP/TCP 对端，服务端检查隧道连接对端，为空表示不限制
+	ACL *ACL
+	// ConnLimits 监听端的并发连接数和新建连接速率限制
+	ConnLimits ConnLimits
+	// Keepalive 隧道连接的 PING 间隔和失效超时：客户端启用时请求控制帧，服务端按自身配置发送 PING
+	Keepalive KeepaliveOptions
+	// Control 控制帧：客户端是否请求控制帧，以及如何处理未知类型的帧
+	Control ControlOptions
+	// Drain 服务端关闭时向客户端发送 GOAWAY 并等待会话结束
+	Drain DrainOptions
+	// Mux TCP 隧道多路复用：多个本地连接共享隧道连接，每个流独立流量控制
+	Mux MuxOptions
+	// Pool TCP 隧道客户端预先建立的空闲隧道连接，新的本地连接直接取用
+	Pool PoolOptions
+	// Timeouts TCP 隧道会话的空闲超时、半关闭超时和最长存活时间
+	Timeouts TimeoutOptions
+}
+
+// dialer 返回配置的拨号器
+func (o *Options) dialer() Dialer {
+	if o.Dialer != nil {
+		return o.Dialer
+	}
+	return NewDialer(o.Socket, o.Bind)
+}
+
+// transport 返回配置的隧道传输层，未配置时创建使用地址族 family 的 TCPTransport
+func (o *Options) transport(family AddressFamily) Transport {
+	if o.Transport != nil {
+		return o.Transport
+	}
+	return &TCPTransport{
+		Dialer:        o.Dialer,
+		Socket:        o.Socket,
+		Bind:          o.Bind,
+		Family:        family,
+		FallbackDelay: o.Family.FallbackDelay,
+		Resolver:      o.Resolver,
+	}
+}
+
+// listenLocal 返回注入的监听器或在 LocalAddr 上监听本地 TCP 或 Unix 流式套接字
+func (o *Options) listenLocal() (net.Listener, error) {
+	if o.Listener != nil {
+		return o.Listener, nil
+	}
+	return listenStream(o.LocalAddr, o.SocketFile, o.Socket, o.Family.Local)
+}
+
+// listenTunnel 返回注入的监听器或通过传输层在 LocalAddr 上监听隧道连接
+func (o *Options) listenTunnel() (net.Listener, error) {
+	if o.Listener != nil {
+		return o.Listener, nil
+	}
+
+	listener, err := o.transport(o.Family.Local).Listen(o.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.SocketFile.applyToAddr(listener.Addr()); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// dialTunnel 通过传输层建立带超时的隧道连接
+func (o *Options) dialTunnel(ctx context.Context, address string) (net.Conn, error) {
+	return o.dialTunnelWith(ctx, nil, address)
+}
+
+// dialTunnelWith 通过指定的传输层建立带超时的隧道连接，transport 为空时使用配置的传输层
+func (o *Options) dialTunnelWith(ctx context.Context, transport Transport, address string) (net.Conn, error) {
+	if transport == nil {
+		transport = o.transport(o.Family.Remote)
+	}
+	ctx, cancel := context.WithTimeout(ctx, tcpConnTimeout)
+	defer cancel()
+	return transport.Dial(ctx, address)
+}
+
+// dialTCP 使用配置的拨号器建立带超时的 TCP 或 Unix 流式连接
+func (o *Options) dialTCP(ctx context.Context, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, tcpConnTimeout)
+	defer cancel()
+	return dialStream(ctx, o.dialer(), o.Resolver, address, o.Family.Remote, o.Family.FallbackDelay)
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ===============================
+// 原始来源地址模块
+// ===============================
+
+// 来源地址头格式（服务端启用 -origin-header 后加在每个发往目标的数据报之前）：
+//   - 1 字节地址族：4 表示 IPv4，6 表示 IPv6
+//   - 4 或 16 字节 IP 地址
+//   - 2 字节端口（大端序，uint16）
+
+const (
+	originFamilyIPv4 = 4
+	originFamilyIPv6 = 6
+)
+
+// appendOriginHeader 在数据报前加上来源地址头
+func appendOriginHeader(dst []byte, origin netip.AddrPort, data []byte) []byte {
+	ip := origin.Addr().Unmap()
+	if ip.Is4() {
+		dst = append(dst, originFamilyIPv4)
+	} else {
+		dst = append(dst, originFamilyIPv6)
+	}
+	dst = append(dst, ip.AsSlice()...)
+	dst = binary.BigEndian.AppendUint16(dst, origin.Port())
+	return append(dst, data...)
+}
+
+// ParsePrefixList 解析逗号分隔的 CIDR 列表，单个 IP 视为主机路由
+func ParsePrefixList(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("无效的地址 %q: %w", item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的网段 %q: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesContain 判断地址是否属于任一网段
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 数据包最大大小
+	maxPacketSize = 65536
+	// UDP 读取超时时间
+	udpReadTimeout = 30 * time.Second
+	// TCP 连接超时时间
+	tcpConnTimeout = 10 * time.Second
+	// UDP 连接重试次数
+	udpRetryCount = 3
+	// UDP 连接重试间隔
+	udpRetryInterval = 1 * time.Second
+	// 数据包长度字段大小
+	packetLengthSize = 2
+	// TCP 隧道分帧连接每个帧的最大数据长度
+	framedChunkSize = 16 * 1024
+)
+
+// ===============================
+// 数据包处理模块
+// ===============================
+
+// PacketWriter 数据包写入接口
+type PacketWriter interface {
+	WritePacket(data []byte) error
+}
+
+// PacketReader 数据包读取接口
+type PacketReader interface {
+	ReadPacket() ([]byte, error)
+}
+
+// TCPPacketHandler TCP 数据包处理器
+type TCPPacketHandler struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	// 以下字段仅在启用控制帧（见 control.go）后使用
+	control       bool
+	rejectUnknown bool      // 收到未知类型的帧时结束读取
+	epoch         time.Time // PING 负载中发送时间的起点
+	rtt           *rttRecorder
+	readStart     atomic.Int64 // 开始等待下一个帧的时间（相对于 epoch 的纳秒数加 1），不在等待时为 0
+	done          chan struct{}
+	doneOnce      sync.Once
+	unknownOnce   sync.Once
+	pongPending   atomic.Bool            // 已有 PONG 在等待写入
+	onGoaway      func(alternate string) // 收到 GOAWAY 时在读取协程中调用，不应阻塞
+	onStream      func(f controlFrame)   // 多路复用：收到流的控制帧时在读取协程中调用，不应阻塞
+}
+
+// NewTCPPacketHandler 创建 TCP 数据包处理器
+func NewTCPPacketHandler(conn net.Conn) *TCPPacketHandler {
+	return &TCPPacketHandler{conn: conn, done: make(chan struct{})}
+}
+
+// enableControl 启用控制帧：此后每个帧带类型字段，收到的 PING 自动应答，PONG 的往返时延记录到 rtt；
+// rejectUnknown 为 true 时收到未知类型的帧结束读取
+func (h *TCPPacketHandler) enableControl(rtt *rttRecorder, rejectUnknown bool) {
+	h.control = true
+	h.rejectUnknown = rejectUnknown
+	h.epoch = time.Now()
+	h.rtt = rtt
+}
+
+// WritePacket 写入数据包到 TCP 连接
+//
+// 长度和数据合并为一次写入，使按消息传输的传输层可以把每个帧作为整体发送。
+func (h *TCPPacketHandler) WritePacket(data []byte) error {
+	if !h.control {
+		return h.writeFrame(data)
+	}
+	return h.writeTyped(frameData, data)
+}
+
+// writeTyped 写入带类型的帧
+func (h *TCPPacketHandler) writeTyped(frameType byte, payload []byte) error {
+	body := make([]byte, frameTypeSize+len(payload))
+	body[0] = frameType
+	copy(body[frameTypeSize:], payload)
+	return h.writeFrame(body)
+}
+
+// tryWriteTyped 写入带类型的帧，其他协程正在写入时不等待，返回 false
+func (h *TCPPacketHandler) tryWriteTyped(frameType byte, payload []byte) (bool, error) {
+	body := make([]byte, frameTypeSize+len(payload))
+	body[0] = frameType
+	copy(body[frameTypeSize:], payload)
+	frame, err := encodeFrame(body)
+	if err != nil {
+		return false, err
+	}
+
+	if !h.writeMu.TryLock() {
+		return false, nil
+	}
+	defer h.writeMu.Unlock()
+	if _, err := h.conn.Write(frame); err != nil {
+		return true, fmt.Errorf("写入数据包失败: %w", err)
+	}
+	return true, nil
+}
+
+// writeFrame 写入一个长度前缀的帧
+func (h *TCPPacketHandler) writeFrame(body []byte) error {
+	frame, err := encodeFrame(body)
+	if err != nil {
+		return err
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if _, err := h.conn.Write(frame); err != nil {
+		return fmt.Errorf("写入数据包失败: %w", err)
+	}
+
+	return nil
+}
+
+// encodeFrame 在 body 前加上长度字段
+func encodeFrame(body []byte) ([]byte, error) {
+	if len(body) > 0xFFFF {
+		return nil, fmt.Errorf("数据包过长: %d 字节", len(body))
+	}
+	frame := make([]byte, packetLengthSize+len(body))
+	binary.BigEndian.PutUint16(frame, uint16(len(body)))
+	copy(frame[packetLengthSize:], body)
+	return frame, nil
+}
+
+// ReadPacket 从 TCP 连接读取数据包，启用控制帧时在内部处理控制帧，对端关闭会话时返回 *CloseError
+func (h *TCPPacketHandler) ReadPacket() ([]byte, error) {
+	if !h.control {
+		return h.readFrame()
+	}
+
+	for {
+		h.readStart.Store(int64(time.Since(h.epoch)) + 1)
+		body, err := h.readFrame()
+		h.readStart.Store(0)
+		if err != nil {
+			h.doneOnce.Do(func() { close(h.done) })
+			return nil, err
+		}
+		if len(body) == 0 {
+			continue
+		}
+
+		payload := body[frameTypeSize:]
+		if body[0] == frameData {
+			return payload, nil
+		}
+		if err := h.handleControl(body[0], payload); err != nil {
+			h.doneOnce.Do(func() { close(h.done) })
+			return nil, err
+		}
+	}
+}
+
+// readFrame 读取一个长度前缀的帧
+func (h *TCPPacketHandler) readFrame() ([]byte, error) {
+	lengthBytes := make([]byte, packetLengthSize)
+	if _, err := io.ReadFull(h.conn, lengthBytes); err != nil {
+		return nil, fmt.Errorf("读取数据长度失败: %w", err)
+	}
+
+	length := binary.BigEndian.Uint16(lengthBytes)
+	if length == 0 {
+		return []byte{}, nil
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(h.conn, data); err != nil {
+		return nil, fmt.Errorf("读取数据内容失败: %w", err)
+	}
+
+	return data, nil
+}
+
+// ===============================
+// TCP 隧道分帧连接
+// ===============================
+
+// framedConn TCP 隧道的分帧连接：写入的数据分段成帧（可选压缩），读取时解帧。
+// 启用压缩或控制帧的 TCP 隧道会话使用该连接代替原始字节流。
+type framedConn struct {
+	net.Conn
+	packets *TCPPacketHandler
+	codec   *frameCodec // 帧压缩，可为空
+	pending []byte
+}
+
+// newFramedConn 在隧道连接上按帧收发数据
+func newFramedConn(conn net.Conn, packets *TCPPacketHandler, codec *frameCodec) *framedConn {
+	return &framedConn{Conn: conn, packets: packets, codec: codec}
+}
+
+// Read 读取解帧后的数据
+func (c *framedConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		frame, err := c.packets.ReadPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		if c.codec == nil {
+			c.pending = frame
+			continue
+		}
+		c.pending, err = c.codec.decode(frame)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write 分段成帧并写入
+func (c *framedConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + framedChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		frame := p[written:end]
+		if c.codec != nil {
+			frame = c.codec.encode(frame)
+		}
+		if err := c.packets.WritePacket(frame); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// CloseWrite 半关闭底层连接
+func (c *framedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 预建连接池模块
+// ===============================
+
+// TCP 隧道客户端为每个本地连接建立隧道连接时需要完成拨号（TLS 传输还有握手）才能开始转发。
+// 启用连接池后客户端在后台预先建立若干条隧道连接，新的本地连接直接取用，随后发送会话头。
+// 池中的连接尚未发送会话头。服务端等待会话头 10 秒后按不发送会话头的旧版本客户端处理该连接，
+// 此时再发送会话头会被当作数据转发，会话无法建立，因此：
+//   - 连接空闲超过 PoolOptions.MaxIdle（默认 8 秒，不超过 MaxPoolIdle）后丢弃
+//   - 定期检查空闲连接是否已被对端关闭，取用时再检查一次
+//   - 补充连接的速率不超过 PoolOptions.RefillRate，服务端不可用时不会频繁重连
+//
+// 收到 GOAWAY 后丢弃池中的全部连接，之后按新的服务端地址补充。
+
+const (
+	// 默认空闲连接的最长保留时间，小于服务端等待会话头的时间
+	defaultPoolMaxIdle = 8 * time.Second
+	// MaxPoolIdle 空闲连接最长保留时间的上限，为取出连接后发送会话头留出 1 秒
+	MaxPoolIdle = sessionHeaderTimeout - time.Second
+	// 默认每秒最多新建的连接数
+	defaultPoolRefillRate = 10
+	// 默认空闲连接的检查间隔
+	defaultPoolHealthInterval = 1 * time.Second
+	// 检查连接是否已被对端关闭时等待读取的时间
+	poolProbeTimeout = 1 * time.Millisecond
+)
+
+// PoolOptions TCP 隧道客户端的预建连接池配置
+type PoolOptions struct {
+	// Size 保持的空闲隧道连接数，0 表示不启用
+	Size int
+	// MaxIdle 空闲连接的最长保留时间，0 表示默认 8 秒；超过 MaxPoolIdle 时按 MaxPoolIdle 处理，
+	// 否则服务端可能已按旧版本客户端处理该连接
+	MaxIdle time.Duration
+	// RefillRate 每秒最多新建的连接数，0 表示默认 10
+	RefillRate float64
+	// HealthInterval 检查空闲连接是否已被对端关闭的间隔，0 表示默认 1 秒
+	HealthInterval time.Duration
+}
+
+// enabled 是否启用连接池
+func (o PoolOptions) enabled() bool {
+	return o.Size > 0
+}
+
+// maxIdle 返回空闲连接的最长保留时间，不超过 MaxPoolIdle
+func (o PoolOptions) maxIdle() time.Duration {
+	if o.MaxIdle > 0 {
+		return min(o.MaxIdle, MaxPoolIdle)
+	}
+	return defaultPoolMaxIdle
+}
+
+// refillRate 返回每秒最多新建的连接数
+func (o PoolOptions) refillRate() float64 {
+	if o.RefillRate > 0 {
+		return o.RefillRate
+	}
+	return defaultPoolRefillRate
+}
+
+// healthInterval 返回空闲连接的检查间隔
+func (o PoolOptions) healthInterval() time.Duration {
+	if o.HealthInterval > 0 {
+		return o.HealthInterval
+	}
+	return defaultPoolHealthInterval
+}
+
+// pooledConn 池中的空闲连接
+type pooledConn struct {
+	conn    net.Conn
+	address string    // 连接的服务端地址
+	created time.Time // 建立时间
+}
+
+// connPool 预建的空闲隧道连接
+type connPool struct {
+	opts   PoolOptions
+	remote *serverAddress
+	dial   func(ctx context.Context, address string) (net.Conn, error)
+	rate   *tokenBucket
+
+	mu     sync.Mutex
+	idle   []pooledConn // 按建立时间排序，先建立的先取用
+	hits   int
+	misses int
+	wake   chan struct{} // 取走连接或清空后通知补充
+}
+
+// newConnPool 创建连接池，未启用时返回 nil
+func newConnPool(opts PoolOptions, remote *serverAddress, dial func(ctx context.Context, address string) (net.Conn, error)) *connPool {
+	if !opts.enabled() {
+		return nil
+	}
+	return &connPool{
+		opts:   opts,
+		remote: remote,
+		dial:   dial,
+		rate:   newTokenBucket(opts.refillRate(), 1),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// get 取出一条可用的空闲连接，没有时返回 nil，调用方自行拨号。p 为空时返回 nil
+func (p *connPool) get() net.Conn {
+	if p == nil {
+		return nil
+	}
+	defer p.notify()
+
+	address := p.remote.get()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		pc := p.idle[0]
+		p.idle = p.idle[1:]
+		if p.usable(pc, address, time.Now()) && probeIdle(pc.conn) {
+			p.hits++
+			return pc.conn
+		}
+		pc.conn.Close()
+	}
+	p.misses++
+	return nil
+}
+
+// flush 关闭所有空闲连接，收到 GOAWAY 或服务端地址变化时调用。p 为空时无操作
+func (p *connPool) flush() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, pc := range idle {
+		pc.conn.Close()
+	}
+	if len(idle) > 0 {
+		log.Printf("丢弃连接池中的 %d 条空闲连接", len(idle))
+	}
+	p.notify()
+}
+
+// run 保持空闲连接数并定期检查，ctx 取消后关闭所有空闲连接
+func (p *connPool) run(ctx context.Context) {
+	log.Printf("启用隧道连接池，保持 %d 条空闲连接，最长空闲 %s", p.opts.Size, p.opts.maxIdle())
+	defer func() {
+		p.mu.Lock()
+		idle := p.idle
+		p.idle = nil
+		log.Printf("隧道连接池已关闭，取用 %d 次，未命中 %d 次", p.hits, p.misses)
+		p.mu.Unlock()
+		for _, pc := range idle {
+			pc.conn.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(p.opts.healthInterval())
+	defer ticker.Stop()
+	for {
+		p.refill(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.check()
+		case <-p.wake:
+		}
+	}
+}
+
+// refill 按补充速率建立连接，直到空闲连接数达到 Size
+func (p *connPool) refill(ctx context.Context) {
+	for p.count() < p.opts.Size {
+		if wait := p.rate.tryTake(1); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			continue
+		}
+
+		address := p.remote.get()
+		conn, err := p.dial(ctx, address)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("连接池建立到 %s 的连接失败: %v", address, err)
+			}
+			return
+		}
+
+		p.mu.Lock()
+		p.idle = append(p.idle, pooledConn{conn: conn, address: address, created: time.Now()})
+		p.mu.Unlock()
+	}
+}
+
+// check 丢弃超时、地址已变更或已被对端关闭的空闲连接
+func (p *connPool) check() {
+	address := p.remote.get()
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	live := p.idle[:0]
+	for _, pc := range p.idle {
+		if p.usable(pc, address, now) && probeIdle(pc.conn) {
+			live = append(live, pc)
+			continue
+		}
+		pc.conn.Close()
+	}
+	clear(p.idle[len(live):])
+	p.idle = live
+}
+
+// usable 连接未超过最长空闲时间且连接的是当前的服务端地址
+func (p *connPool) usable(pc pooledConn, address string, now time.Time) bool {
+	return pc.address == address && now.Sub(pc.created) < p.opts.maxIdle()
+}
+
+// count 返回空闲连接数
+func (p *connPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// notify 通知后台补充连接
+func (p *connPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// probeIdle 检查尚未发送会话头的连接是否可用：服务端在收到会话头之前不会发送数据，
+// 短暂读取时超时说明连接正常，读到 EOF、数据或其他错误说明连接已不可用
+func probeIdle(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(poolProbeTimeout)); err != nil {
+		return false
+	}
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
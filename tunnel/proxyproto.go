@@ -0,0 +1,283 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ===============================
+// PROXY 协议模块
+// ===============================
+
+// PROXY 协议版本
+const (
+	ProxyProtocolNone = 0
+	ProxyProtocolV1   = 1
+	ProxyProtocolV2   = 2
+)
+
+const (
+	// v1 头部最大长度（含 CRLF）
+	proxyV1MaxLength = 107
+	// v2 固定头部长度（签名 12 字节 + 版本命令 + 地址族 + 长度）
+	proxyV2HeaderLength = 16
+)
+
+// v2 头部签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ParseProxyProtocolVersion 解析命令行中的 PROXY 协议版本
+func ParseProxyProtocolVersion(value string) (int, error) {
+	switch strings.ToLower(value) {
+	case "", "none":
+		return ProxyProtocolNone, nil
+	case "v1", "1":
+		return ProxyProtocolV1, nil
+	case "v2", "2":
+		return ProxyProtocolV2, nil
+	default:
+		return 0, fmt.Errorf("无效的 PROXY 协议版本: %s（必须是 'v1' 或 'v2'）", value)
+	}
+}
+
+// writeProxyHeader 向目标连接写入 PROXY 协议头
+//
+// src 为空时写入 UNKNOWN（v1）或 LOCAL（v2）头，目标按真实连接地址处理。
+func writeProxyHeader(w io.Writer, version int, src, dst netip.AddrPort) error {
+	var header []byte
+	switch version {
+	case ProxyProtocolV1:
+		header = buildProxyV1Header(src, dst)
+	case ProxyProtocolV2:
+		header = buildProxyV2Header(src, dst)
+	default:
+		return nil
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("写入 PROXY 协议头失败: %w", err)
+	}
+	return nil
+}
+
+// buildProxyV1Header 构造文本格式的 v1 头部
+func buildProxyV1Header(src, dst netip.AddrPort) []byte {
+	if !src.IsValid() || !dst.IsValid() {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	family := "TCP4"
+	if srcIP.Is6() || dstIP.Is6() {
+		family = "TCP6"
+		srcIP, dstIP = as16(srcIP), as16(dstIP)
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+		family, srcIP, dstIP, src.Port(), dst.Port()))
+}
+
+// buildProxyV2Header 构造二进制格式的 v2 头部
+func buildProxyV2Header(src, dst netip.AddrPort) []byte {
+	header := make([]byte, 0, proxyV2HeaderLength+36)
+	header = append(header, proxyV2Signature...)
+
+	if !src.IsValid() || !dst.IsValid() {
+		// LOCAL 命令，无地址信息
+		header = append(header, 0x20, 0x00)
+		return binary.BigEndian.AppendUint16(header, 0)
+	}
+
+	srcIP, dstIP := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcIP.Is4() && dstIP.Is4() {
+		// PROXY 命令，TCP over IPv4
+		header = append(header, 0x21, 0x11)
+		header = binary.BigEndian.AppendUint16(header, 12)
+		header = append(header, srcIP.AsSlice()...)
+		header = append(header, dstIP.AsSlice()...)
+	} else {
+		// PROXY 命令，TCP over IPv6
+		header = append(header, 0x21, 0x21)
+		header = binary.BigEndian.AppendUint16(header, 36)
+		header = append(header, as16(srcIP).AsSlice()...)
+		header = append(header, as16(dstIP).AsSlice()...)
+	}
+	header = binary.BigEndian.AppendUint16(header, src.Port())
+	return binary.BigEndian.AppendUint16(header, dst.Port())
+}
+
+// as16 将 IPv4 地址转换为 IPv4 映射的 IPv6 地址
+func as16(ip netip.Addr) netip.Addr {
+	return netip.AddrFrom16(ip.As16())
+}
+
+// readProxyHeader 读取 v1 或 v2 格式的 PROXY 协议头
+//
+// 返回的地址无效表示头部未携带地址（UNKNOWN 或 LOCAL）。
+func readProxyHeader(r *bufio.Reader) (src, dst netip.AddrPort, err error) {
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return src, dst, fmt.Errorf("读取 PROXY 协议头失败: %w", err)
+	}
+
+	if bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2Header(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyV1Header(r)
+	}
+	return src, dst, fmt.Errorf("连接未携带 PROXY 协议头")
+}
+
+// readProxyV1Header 解析 v1 文本头部
+func readProxyV1Header(r *bufio.Reader) (src, dst netip.AddrPort, err error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return src, dst, fmt.Errorf("读取 PROXY v1 头部失败: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return src, dst, fmt.Errorf("PROXY v1 头部过长或格式错误")
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return src, dst, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return src, dst, fmt.Errorf("无效的 PROXY v1 头部: %q", line)
+	}
+
+	if src, err = parseProxyV1Addr(fields[2], fields[4]); err != nil {
+		return src, dst, err
+	}
+	if dst, err = parseProxyV1Addr(fields[3], fields[5]); err != nil {
+		return src, dst, err
+	}
+	return src, dst, nil
+}
+
+// parseProxyV1Addr 解析 v1 头部中的地址和端口
+func parseProxyV1Addr(ipStr, portStr string) (netip.AddrPort, error) {
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("无效的 PROXY v1 地址 %q: %w", ipStr, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("无效的 PROXY v1 端口 %q: %w", portStr, err)
+	}
+	return netip.AddrPortFrom(ip, uint16(port)), nil
+}
+
+// readProxyV2Header 解析 v2 二进制头部
+func readProxyV2Header(r *bufio.Reader) (src, dst netip.AddrPort, err error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return src, dst, fmt.Errorf("读取 PROXY v2 头部失败: %w", err)
+	}
+
+	verCmd, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	if verCmd>>4 != 2 {
+		return src, dst, fmt.Errorf("不支持的 PROXY v2 版本: %#x", verCmd)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return src, dst, fmt.Errorf("读取 PROXY v2 地址失败: %w", err)
+	}
+
+	// LOCAL 命令或非 TCP/UDP 地址族时忽略地址信息（TLV 扩展同样忽略）
+	if verCmd&0x0F == 0x00 {
+		return src, dst, nil
+	}
+
+	switch family >> 4 {
+	case 0x1: // IPv4
+		if len(body) < 12 {
+			return src, dst, fmt.Errorf("PROXY v2 IPv4 地址长度不足")
+		}
+		src = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[0:4])), binary.BigEndian.Uint16(body[8:]))
+		dst = netip.AddrPortFrom(netip.AddrFrom4([4]byte(body[4:8])), binary.BigEndian.Uint16(body[10:]))
+	case 0x2: // IPv6
+		if len(body) < 36 {
+			return src, dst, fmt.Errorf("PROXY v2 IPv6 地址长度不足")
+		}
+		src = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[0:16])).Unmap(), binary.BigEndian.Uint16(body[32:]))
+		dst = netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[16:32])).Unmap(), binary.BigEndian.Uint16(body[34:]))
+	}
+	return src, dst, nil
+}
+
+// ===============================
+// 接收端 PROXY 协议解析
+// ===============================
+
+// proxiedConn 使用 PROXY 协议头中地址的连接
+type proxiedConn struct {
+	*bufferedConn
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// RemoteAddr 返回 PROXY 协议头中的源地址
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// LocalAddr 返回 PROXY 协议头中的目标地址
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// acceptProxyHeader 读取新连接上的 PROXY 协议头，返回使用原始地址的连接
+func acceptProxyHeader(conn net.Conn) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(sessionHeaderTimeout))
+	src, dst, err := readProxyHeader(reader)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	proxied := &proxiedConn{
+		bufferedConn: newBufferedConn(conn, reader),
+		remoteAddr:   conn.RemoteAddr(),
+		localAddr:    conn.LocalAddr(),
+	}
+	if src.IsValid() {
+		proxied.remoteAddr = net.TCPAddrFromAddrPort(src)
+	}
+	if dst.IsValid() {
+		proxied.localAddr = net.TCPAddrFromAddrPort(dst)
+	}
+	return proxied, nil
+}
+
+// addrPortOf 从网络地址中提取 IP 和端口，无法解析时返回无效值
+func addrPortOf(addr net.Addr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
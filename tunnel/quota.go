@@ -0,0 +1,408 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// 流量配额模块
+// ===============================
+
+// 服务端按客户端的认证身份（TLS 客户端证书，见 peerIdentity）统计隧道两个方向转发的数据量，
+// 跨会话累计。超过每日或每月配额时拒绝新会话并关闭进行中的会话。计数保存在 JSON 文件中，
+// 重启后继续累计；日和月按服务端本地时间划分。
+
+const (
+	// 默认持久化间隔
+	defaultAccountingSaveInterval = 30 * time.Second
+	// 日计数的日期格式
+	accountingDayLayout = "2006-01-02"
+	// 月计数的日期格式
+	accountingMonthLayout = "2006-01"
+)
+
+// errQuotaExceeded 用户流量超过配额
+var errQuotaExceeded = errors.New("超过流量配额")
+
+// Quota 用户流量配额（两个方向合计的字节数），0 表示不限制
+type Quota struct {
+	Daily   uint64
+	Monthly uint64
+}
+
+// ParseQuota 解析 "每日配额[,每月配额]" 形式的配额，字节数可带 K/M/G/T 后缀（1024 进制），
+// 任一部分为空或 0 表示不限制该项
+func ParseQuota(value string) (Quota, error) {
+	var quota Quota
+	dailyPart, monthlyPart, _ := strings.Cut(value, ",")
+	for _, item := range []struct {
+		value string
+		quota *uint64
+	}{
+		{dailyPart, &quota.Daily},
+		{monthlyPart, &quota.Monthly},
+	} {
+		part := strings.TrimSpace(item.value)
+		if part == "" {
+			continue
+		}
+		size, err := parseByteSize(part)
+		if err != nil {
+			return Quota{}, fmt.Errorf("无效的流量配额: %s", value)
+		}
+		*item.quota = uint64(size)
+	}
+	return quota, nil
+}
+
+// AccountingOptions 流量统计配置
+type AccountingOptions struct {
+	// Default 未单独配置的用户使用的配额
+	Default Quota
+	// Users 按用户名单独配置的配额
+	Users map[string]Quota
+	// File 保存计数的 JSON 文件，为空表示只在内存中统计
+	File string
+	// SaveInterval 定期保存的间隔，0 表示默认 30 秒
+	SaveInterval time.Duration
+}
+
+// UserUsage 用户的流量使用情况
+type UserUsage struct {
+	User           string `json:"user"`
+	Day            string `json:"day"`
+	DayBytes       uint64 `json:"day_bytes"`
+	Month          string `json:"month"`
+	MonthBytes     uint64 `json:"month_bytes"`
+	TotalBytes     uint64 `json:"total_bytes"`
+	DailyQuota     uint64 `json:"daily_quota,omitempty"`
+	MonthlyQuota   uint64 `json:"monthly_quota,omitempty"`
+	ActiveSessions int    `json:"active_sessions"`
+}
+
+// userAccount 单个用户的计数，除活动会话数外都会保存到文件
+type userAccount struct {
+	Day        string `json:"day"`
+	DayBytes   uint64 `json:"day_bytes"`
+	Month      string `json:"month"`
+	MonthBytes uint64 `json:"month_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+
+	active int
+}
+
+// rollover 进入新的一天或新的一个月时清零对应的计数，返回是否有变化
+func (u *userAccount) rollover(now time.Time) bool {
+	changed := false
+	if day := now.Format(accountingDayLayout); u.Day != day {
+		u.Day, u.DayBytes = day, 0
+		changed = true
+	}
+	if month := now.Format(accountingMonthLayout); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+		changed = true
+	}
+	return changed
+}
+
+// Accounting 按用户统计流量并执行配额，可以由多个隧道服务端共用
+type Accounting struct {
+	opts   AccountingOptions
+	mu     sync.Mutex
+	users  map[string]*userAccount
+	dirty  bool
+	saveMu sync.Mutex // 串行化文件写入
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAccounting 创建流量统计，配置了 File 时加载已有计数并定期保存
+func NewAccounting(opts AccountingOptions) (*Accounting, error) {
+	a := &Accounting{
+		opts:  opts,
+		users: make(map[string]*userAccount),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+
+	if opts.File == "" {
+		close(a.done)
+	} else {
+		go a.saveLoop()
+	}
+	return a, nil
+}
+
+// load 从文件加载计数，文件不存在时从零开始
+func (a *Accounting) load() error {
+	if a.opts.File == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.opts.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取流量统计文件失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.users); err != nil {
+		return fmt.Errorf("解析流量统计文件 %s 失败: %w", a.opts.File, err)
+	}
+	for name, u := range a.users {
+		if u == nil {
+			delete(a.users, name)
+		}
+	}
+	return nil
+}
+
+// saveLoop 定期保存计数，直到调用 Close
+func (a *Accounting) saveLoop() {
+	defer close(a.done)
+
+	interval := a.opts.SaveInterval
+	if interval <= 0 {
+		interval = defaultAccountingSaveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				log.Printf("保存流量统计失败: %v", err)
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Save 把计数写入文件（先写临时文件再重命名），计数没有变化时直接返回
+func (a *Accounting) Save() error {
+	if a.opts.File == "" {
+		return nil
+	}
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+
+	a.mu.Lock()
+	if !a.dirty {
+		a.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(a.users, "", "  ")
+	a.dirty = false
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := a.opts.File + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		err = os.Rename(tmp, a.opts.File)
+	}
+	if err != nil {
+		a.mu.Lock()
+		a.dirty = true
+		a.mu.Unlock()
+		return fmt.Errorf("写入流量统计文件失败: %w", err)
+	}
+	return nil
+}
+
+// Close 停止定期保存并写入最终计数
+func (a *Accounting) Close() error {
+	a.closeOnce.Do(func() { close(a.stop) })
+	<-a.done
+	return a.Save()
+}
+
+// quota 返回用户的配额
+func (a *Accounting) quota(user string) Quota {
+	if quota, exists := a.opts.Users[user]; exists {
+		return quota
+	}
+	return a.opts.Default
+}
+
+// accountLocked 返回用户的计数并按当前日期滚动，调用方需持有 a.mu
+func (a *Accounting) accountLocked(user string, now time.Time) *userAccount {
+	u, exists := a.users[user]
+	if !exists {
+		u = &userAccount{}
+		a.users[user] = u
+	}
+	if u.rollover(now) {
+		a.dirty = true
+	}
+	return u
+}
+
+// exceeded 检查用户是否已用完每日或每月配额
+func (a *Accounting) exceeded(user string, u *userAccount) error {
+	quota := a.quota(user)
+	if quota.Daily > 0 && u.DayBytes >= quota.Daily {
+		return fmt.Errorf("%w: 今日已用 %d 字节，每日配额 %d 字节", errQuotaExceeded, u.DayBytes, quota.Daily)
+	}
+	if quota.Monthly > 0 && u.MonthBytes >= quota.Monthly {
+		return fmt.Errorf("%w: 本月已用 %d 字节，每月配额 %d 字节", errQuotaExceeded, u.MonthBytes, quota.Monthly)
+	}
+	return nil
+}
+
+// open 为用户的新会话开始计费，已超过配额时返回错误；a 为空或 user 为空（未认证）时不统计
+func (a *Accounting) open(user string) (*quotaSession, error) {
+	if a == nil || user == "" {
+		return nil, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.accountLocked(user, time.Now())
+	if err := a.exceeded(user, u); err != nil {
+		return nil, err
+	}
+	u.active++
+	return &quotaSession{accounting: a, user: user}, nil
+}
+
+// Usage 返回按用户名排序的流量使用情况
+func (a *Accounting) Usage() []UserUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	usage := make([]UserUsage, 0, len(a.users))
+	for name := range a.users {
+		u := a.accountLocked(name, now)
+		quota := a.quota(name)
+		usage = append(usage, UserUsage{
+			User:           name,
+			Day:            u.Day,
+			DayBytes:       u.DayBytes,
+			Month:          u.Month,
+			MonthBytes:     u.MonthBytes,
+			TotalBytes:     u.TotalBytes,
+			DailyQuota:     quota.Daily,
+			MonthlyQuota:   quota.Monthly,
+			ActiveSessions: u.active,
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].User < usage[j].User })
+	return usage
+}
+
+// ServeHTTP 管理接口：GET 以 JSON 返回全部用户的使用情况，?user=名称 只返回指定用户
+func (a *Accounting) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "只支持 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	usage := a.Usage()
+	if user := r.URL.Query().Get("user"); user != "" {
+		var matched []UserUsage
+		for _, u := range usage {
+			if u.User == user {
+				matched = append(matched, u)
+			}
+		}
+		if len(matched) == 0 {
+			http.Error(w, "没有该用户的流量记录", http.StatusNotFound)
+			return
+		}
+		usage = matched
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// quotaSession 一个会话的流量计费
+type quotaSession struct {
+	accounting *Accounting
+	user       string
+	bytes      atomic.Uint64
+	closeOnce  sync.Once
+}
+
+// add 累计 n 字节，累计后超过配额时返回错误；qs 为空时不统计
+func (qs *quotaSession) add(n int) error {
+	if qs == nil || n <= 0 {
+		return nil
+	}
+	qs.bytes.Add(uint64(n))
+
+	a := qs.accounting
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.accountLocked(qs.user, time.Now())
+	u.DayBytes += uint64(n)
+	u.MonthBytes += uint64(n)
+	u.TotalBytes += uint64(n)
+	a.dirty = true
+	return a.exceeded(qs.user, u)
+}
+
+// close 结束会话计费，重复调用无副作用
+func (qs *quotaSession) close() {
+	if qs == nil {
+		return
+	}
+	qs.closeOnce.Do(func() {
+		a := qs.accounting
+		a.mu.Lock()
+		if u := a.users[qs.user]; u != nil && u.active > 0 {
+			u.active--
+		}
+		a.mu.Unlock()
+	})
+}
+
+// writer 返回统计写入字节数的 Writer，超过配额时写入返回错误；qs 为空时直接返回 w
+func (qs *quotaSession) writer(w io.Writer) io.Writer {
+	if qs == nil {
+		return w
+	}
+	return &quotaWriter{w: w, session: qs}
+}
+
+// String 返回会话用量摘要
+func (qs *quotaSession) String() string {
+	return fmt.Sprintf("用户 %s 本次会话传输 %d 字节", qs.user, qs.bytes.Load())
+}
+
+// quotaWriter 计费的 Writer
+type quotaWriter struct {
+	w       io.Writer
+	session *quotaSession
+}
+
+// Write 写入数据并计费
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if quotaErr := w.session.add(n); err == nil && quotaErr != nil {
+		err = quotaErr
+	}
+	return n, err
+}
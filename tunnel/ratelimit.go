@@ -0,0 +1,415 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// 限速模块
+// ===============================
+
+const (
+	// 按来源限速器在最后一个会话结束后的保留时间
+	limiterIdleTimeout = 1 * time.Minute
+	// 触发空闲清理的来源数量
+	limiterPruneThreshold = 1024
+	// TCP 整形时每次写入的最大字节数
+	shapeChunkSize = 16 * 1024
+)
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 桶容量
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建令牌桶，初始为满桶
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill 按经过的时间补充令牌，调用方需持有锁
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// reserve 取出 n 个令牌（允许透支），返回令牌恢复为非负需要等待的时间
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// tryTake 有 n 个令牌时取出并返回 0，否则不取出并返回令牌足够需要等待的时间
+func (b *tokenBucket) tryTake(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// has 判断是否有 n 个令牌，不取出
+func (b *tokenBucket) has(n float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	return b.tokens >= n
+}
+
+// RateLimit 令牌桶限速参数，字段为 0 表示不限制该项
+type RateLimit struct {
+	// BytesPerSecond 每秒允许的字节数
+	BytesPerSecond float64
+	// PacketsPerSecond 每秒允许的数据包数（TCP 隧道按写入次数计）
+	PacketsPerSecond float64
+}
+
+// ParseRateLimit 解析 "字节速率[,包速率]" 形式的限速，字节速率可带 K/M/G 后缀（1024 进制），
+// 任一部分为空或 0 表示不限制该项
+func ParseRateLimit(value string) (RateLimit, error) {
+	if value == "" {
+		return RateLimit{}, nil
+	}
+
+	bytesPart, packetsPart, _ := strings.Cut(value, ",")
+	var limit RateLimit
+	if bytesPart = strings.TrimSpace(bytesPart); bytesPart != "" {
+		rate, err := parseByteSize(bytesPart)
+		if err != nil {
+			return RateLimit{}, fmt.Errorf("无效的字节速率: %s", value)
+		}
+		limit.BytesPerSecond = rate
+	}
+	if packetsPart = strings.TrimSpace(packetsPart); packetsPart != "" {
+		rate, err := strconv.ParseFloat(packetsPart, 64)
+		if err != nil || rate < 0 {
+			return RateLimit{}, fmt.Errorf("无效的包速率: %s", value)
+		}
+		limit.PacketsPerSecond = rate
+	}
+	return limit, nil
+}
+
+// parseByteSize 解析可带 K/M/G/T 后缀（1024 进制）的非负字节数
+func parseByteSize(value string) (float64, error) {
+	multiplier := 1.0
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	case "T":
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("无效的字节数: %s", value)
+	}
+	return size * multiplier, nil
+}
+
+// enabled 是否设置了任一限制
+func (l RateLimit) enabled() bool {
+	return l.BytesPerSecond > 0 || l.PacketsPerSecond > 0
+}
+
+// RateLimits 按范围的限速配置，作用于本端发往隧道的流量：
+// 客户端限制本地客户端上传的数据，服务端限制目标返回的数据
+type RateLimits struct {
+	// Tunnel 整个隧道共享的限制
+	Tunnel RateLimit
+	// Session 每个会话的限制
+	Session RateLimit
+	// SourceIP 每个来源 IP 的限制（客户端为本地客户端 IP，服务端为会话头中的原始客户端 IP）
+	SourceIP RateLimit
+}
+
+// rateLimiter 字节数和数据包数两个令牌桶
+type rateLimiter struct {
+	bytes   *tokenBucket
+	packets *tokenBucket
+
+	owner  *sourceLimiter // 按来源 IP 的限速器所属的 sourceLimiter，其他限速器为空
+	source netip.Addr
+}
+
+// newRateLimiter 创建限速器，未设置限制时返回 nil
+func newRateLimiter(l RateLimit) *rateLimiter {
+	if !l.enabled() {
+		return nil
+	}
+	r := &rateLimiter{}
+	if l.BytesPerSecond > 0 {
+		// 桶容量至少容纳一个最大的数据报，避免大数据报永远无法通过
+		r.bytes = newTokenBucket(l.BytesPerSecond, maxPacketSize)
+	}
+	if l.PacketsPerSecond > 0 {
+		r.packets = newTokenBucket(l.PacketsPerSecond, l.PacketsPerSecond)
+	}
+	return r
+}
+
+// has 判断是否可以立即发送 n 字节的一个数据包
+func (r *rateLimiter) has(n int) bool {
+	return (r.bytes == nil || r.bytes.has(float64(n))) &&
+		(r.packets == nil || r.packets.has(1))
+}
+
+// reserve 取出 n 字节的一个数据包所需的令牌，返回需要等待的时间
+func (r *rateLimiter) reserve(n int) time.Duration {
+	var wait time.Duration
+	if r.bytes != nil {
+		wait = r.bytes.reserve(float64(n))
+	}
+	if r.packets != nil {
+		if w := r.packets.reserve(1); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// sourceLimiter 按来源 IP 的限速器。每个来源的限速器按使用它的会话计数，
+// 最后一个会话结束超过 limiterIdleTimeout 后才会被清理，使用中的限速器不会被清理
+type sourceLimiter struct {
+	mu      sync.Mutex
+	limit   RateLimit
+	sources map[netip.Addr]*sourceEntry
+}
+
+// sourceEntry 一个来源 IP 的限速器及其使用情况
+type sourceEntry struct {
+	limiter  *rateLimiter
+	refs     int       // 使用该限速器的会话数
+	released time.Time // 最后一个会话结束的时间
+}
+
+// newSourceLimiter 创建按来源 IP 的限速器，未设置限制时返回 nil
+func newSourceLimiter(limit RateLimit) *sourceLimiter {
+	if !limit.enabled() {
+		return nil
+	}
+	return &sourceLimiter{
+		limit:   limit,
+		sources: make(map[netip.Addr]*sourceEntry),
+	}
+}
+
+// get 返回来源 IP 的限速器并增加其会话计数，会话结束时由 trafficLimiter.close 释放。
+// 来源无效时返回 nil
+func (l *sourceLimiter) get(source netip.Addr) *rateLimiter {
+	if l == nil || !source.IsValid() {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, exists := l.sources[source]
+	if !exists {
+		if len(l.sources) >= limiterPruneThreshold {
+			l.pruneLocked(time.Now())
+		}
+		limiter := newRateLimiter(l.limit)
+		limiter.owner = l
+		limiter.source = source
+		entry = &sourceEntry{limiter: limiter}
+		l.sources[source] = entry
+	}
+	entry.refs++
+	return entry.limiter
+}
+
+// release 减少来源 IP 的会话计数
+func (l *sourceLimiter) release(source netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry, exists := l.sources[source]; exists && entry.refs > 0 {
+		entry.refs--
+		entry.released = time.Now()
+	}
+}
+
+// pruneLocked 清理没有会话使用且空闲超时的来源，调用方需持有锁
+func (l *sourceLimiter) pruneLocked(now time.Time) {
+	for source, entry := range l.sources {
+		if entry.refs == 0 && now.Sub(entry.released) > limiterIdleTimeout {
+			delete(l.sources, source)
+		}
+	}
+}
+
+// policeMu 监管时检查和取出令牌在同一把锁内完成，共享同一令牌桶的会话不会同时通过检查而超出限制
+var policeMu sync.Mutex
+
+// trafficLimiter 一个会话适用的限速器组合（隧道、来源 IP、会话），并统计被限速的流量
+type trafficLimiter struct {
+	limiters  []*rateLimiter
+	closeOnce sync.Once
+
+	droppedPackets atomic.Uint64 // 监管丢弃的数据包数
+	droppedBytes   atomic.Uint64 // 监管丢弃的字节数
+	delayed        atomic.Uint64 // 整形延迟的写入次数
+	delayTotal     atomic.Int64  // 整形累计延迟（纳秒）
+}
+
+// newTrafficLimiter 组合限速器，全部为 nil 时返回 nil
+func newTrafficLimiter(limiters ...*rateLimiter) *trafficLimiter {
+	t := &trafficLimiter{}
+	for _, limiter := range limiters {
+		if limiter != nil {
+			t.limiters = append(t.limiters, limiter)
+		}
+	}
+	if len(t.limiters) == 0 {
+		return nil
+	}
+	return t
+}
+
+// police 监管：所有限速器都有足够令牌时取出并返回 true，否则丢弃并计数
+func (t *trafficLimiter) police(n int) bool {
+	if t == nil {
+		return true
+	}
+	policeMu.Lock()
+	defer policeMu.Unlock()
+	for _, limiter := range t.limiters {
+		if !limiter.has(n) {
+			t.droppedPackets.Add(1)
+			t.droppedBytes.Add(uint64(n))
+			return false
+		}
+	}
+	for _, limiter := range t.limiters {
+		limiter.reserve(n)
+	}
+	return true
+}
+
+// close 会话结束时释放按来源 IP 的限速器，之后该来源没有其他会话时可以被清理。
+// 重复调用无影响，t 为空时无操作
+func (t *trafficLimiter) close() {
+	if t == nil {
+		return
+	}
+	t.closeOnce.Do(func() {
+		for _, limiter := range t.limiters {
+			if limiter.owner != nil {
+				limiter.owner.release(limiter.source)
+			}
+		}
+	})
+}
+
+// shape 整形：取出令牌并等待到令牌恢复，ctx 取消时提前返回
+func (t *trafficLimiter) shape(ctx context.Context, n int) error {
+	var wait time.Duration
+	for _, limiter := range t.limiters {
+		if w := limiter.reserve(n); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	t.delayed.Add(1)
+	t.delayTotal.Add(int64(wait))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writer 返回按限速整形的 Writer，未限速时直接返回 w
+func (t *trafficLimiter) writer(ctx context.Context, w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &shapedWriter{ctx: ctx, w: w, limiter: t}
+}
+
+// String 返回被限速流量的统计
+func (t *trafficLimiter) String() string {
+	return fmt.Sprintf("丢弃 %d 个数据包（%d 字节），延迟 %d 次写入（共 %v）",
+		t.droppedPackets.Load(), t.droppedBytes.Load(), t.delayed.Load(),
+		time.Duration(t.delayTotal.Load()).Round(time.Millisecond))
+}
+
+// throttled 是否有流量被限速
+func (t *trafficLimiter) throttled() bool {
+	return t != nil && (t.droppedPackets.Load() > 0 || t.delayed.Load() > 0)
+}
+
+// shapedWriter 按限速分段写入
+type shapedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *trafficLimiter
+}
+
+// Write 每段写入前等待令牌
+func (s *shapedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + shapeChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := s.limiter.shape(s.ctx, end-written); err != nil {
+			return written, err
+		}
+		n, err := s.w.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSourceLimiterKeepsActiveSources(t *testing.T) {
+	limiter := newSourceLimiter(RateLimit{PacketsPerSecond: 10})
+	active := netip.MustParseAddr("192.0.2.1")
+	session := newTrafficLimiter(limiter.get(active))
+
+	// 会话仍在使用的来源即使很久没有新会话也不会被清理
+	limiter.mu.Lock()
+	limiter.pruneLocked(time.Now().Add(2 * limiterIdleTimeout))
+	limiter.mu.Unlock()
+	if limiter.get(active) != session.limiters[0] {
+		t.Fatal("使用中的来源限速器被清理")
+	}
+
+	// 所有会话结束并空闲超时后才清理
+	session.close()
+	session.close()
+	limiter.release(active)
+	limiter.mu.Lock()
+	limiter.pruneLocked(time.Now().Add(2 * limiterIdleTimeout))
+	_, exists := limiter.sources[active]
+	limiter.mu.Unlock()
+	if exists {
+		t.Fatal("没有会话使用的来源限速器应被清理")
+	}
+}
+
+func TestPoliceSharedBucket(t *testing.T) {
+	shared := newRateLimiter(RateLimit{PacketsPerSecond: 100})
+
+	// 多个会话并发监管同一个令牌桶，通过的数据包数不超过桶容量（加上测试期间补充的少量令牌）
+	var passed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session := newTrafficLimiter(shared, newRateLimiter(RateLimit{PacketsPerSecond: 1000}))
+			for j := 0; j < 100; j++ {
+				if session.police(1) {
+					passed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := passed.Load(); n > 105 {
+		t.Fatalf("通过 %d 个数据包，超过共享令牌桶的容量 100", n)
+	}
+}
@@ -0,0 +1,203 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ===============================
+// Reed-Solomon 编码模块
+// ===============================
+
+// 系统码：前 K 个分片为原始数据，后 M 个校验分片由 Cauchy 矩阵生成，
+// 任意 K 个分片即可恢复全部数据分片。运算在 GF(2^8) 上进行，本原多项式为 x^8+x^4+x^3+x^2+1。
+
+// GF(2^8) 本原多项式
+const gfPolynomial = 0x11d
+
+var (
+	gfExp      [512]byte
+	gfLog      [256]byte
+	gfMulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPolynomial
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+// gfInv 求乘法逆元，a 不能为 0
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// gfMulAdd 计算 dst ^= c * src
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	row := &gfMulTable[c]
+	for i, v := range src {
+		dst[i] ^= row[v]
+	}
+}
+
+// errTooFewShards 可用分片不足，无法恢复
+var errTooFewShards = errors.New("可用分片不足，无法恢复")
+
+// errShardSize 可用分片长度不一致，分片不属于同一次编码
+var errShardSize = errors.New("分片长度不一致")
+
+// reedSolomon Reed-Solomon 编解码器
+type reedSolomon struct {
+	dataShards   int
+	parityShards int
+	parity       [][]byte // M×K 的 Cauchy 矩阵
+}
+
+// newReedSolomon 创建编解码器，数据分片和校验分片总数不能超过 256
+func newReedSolomon(dataShards, parityShards int) (*reedSolomon, error) {
+	if dataShards < 1 || parityShards < 1 || dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("无效的分片数: 数据 %d，校验 %d", dataShards, parityShards)
+	}
+
+	// x_i = K + i，y_j = j，两组取值互不相同，保证 x_i ^ y_j 不为 0
+	parity := make([][]byte, parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, dataShards)
+		for j := range parity[i] {
+			parity[i][j] = gfInv(byte(dataShards+i) ^ byte(j))
+		}
+	}
+	return &reedSolomon{dataShards: dataShards, parityShards: parityShards, parity: parity}, nil
+}
+
+// encode 根据等长的数据分片计算校验分片
+func (rs *reedSolomon) encode(data [][]byte) [][]byte {
+	size := len(data[0])
+	parity := make([][]byte, rs.parityShards)
+	for i := range parity {
+		parity[i] = make([]byte, size)
+		for j, shard := range data {
+			gfMulAdd(parity[i], shard, rs.parity[i][j])
+		}
+	}
+	return parity
+}
+
+// reconstruct 恢复缺失的数据分片
+//
+// shards 长度为 K+M，缺失的分片为 nil，其余分片必须等长；恢复结果直接写回 shards。
+func (rs *reedSolomon) reconstruct(shards [][]byte) error {
+	k := rs.dataShards
+	if len(shards) != k+rs.parityShards {
+		return fmt.Errorf("分片数 %d 与编码参数不符", len(shards))
+	}
+	size := -1
+	for _, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return errShardSize
+		}
+		size = len(shard)
+	}
+
+	// 选取 K 个可用分片及其对应的编码矩阵行
+	rows := make([][]byte, 0, k)
+	inputs := make([][]byte, 0, k)
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		row := make([]byte, k)
+		if i < k {
+			row[i] = 1
+		} else {
+			copy(row, rs.parity[i-k])
+		}
+		rows = append(rows, row)
+		inputs = append(inputs, shard)
+		if len(rows) == k {
+			break
+		}
+	}
+	if len(rows) < k {
+		return errTooFewShards
+	}
+
+	inverse, err := gfInvertMatrix(rows)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < k; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shard := make([]byte, size)
+		for j, input := range inputs {
+			gfMulAdd(shard, input, inverse[i][j])
+		}
+		shards[i] = shard
+	}
+	return nil
+}
+
+// gfInvertMatrix 用高斯-约当消元求方阵的逆
+func gfInvertMatrix(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("编码矩阵不可逆")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		if scale := gfInv(work[col][col]); scale != 1 {
+			scaled := make([]byte, 2*n)
+			gfMulAdd(scaled, work[col], scale)
+			work[col] = scaled
+		}
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				gfMulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,580 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ===============================
+// DNS 解析模块
+// ===============================
+
+// 默认情况下每次拨号都通过系统解析器解析主机名，已建立的会话不再解析。配置 Resolver 后：
+//   - 解析结果按 TTL 缓存，同一主机名的并发解析合并为一次查询；
+//   - 可以向指定的 DNS 服务器查询（A/AAAA，UDP 应答被截断时改用 TCP），得到记录的真实 TTL；
+//     系统解析器不提供 TTL，使用固定的 SystemTTL；
+//   - 重新解析失败时继续使用上一次的结果；
+//   - 客户端在服务端主机名的解析结果变化后，把连接到旧地址的会话迁移到新地址，
+//     UDP 服务端的每个会话在目标主机名的解析结果变化后改为连接新地址。
+//
+// TCP 目标连接和 TCP 隧道中已建立的流无法迁移，只有之后的新连接使用新地址。
+
+const (
+	// 默认系统解析器结果的缓存时间
+	defaultSystemTTL = 30 * time.Second
+	// 默认 TTL 下限，同时也是解析失败后的重试间隔
+	defaultMinTTL = 5 * time.Second
+	// 默认 TTL 上限
+	defaultMaxTTL = time.Hour
+	// 默认单次查询超时
+	defaultResolveTimeout = 5 * time.Second
+	// 缓存条目数超过该值时清理过期条目
+	resolverCacheSize = 1024
+	// DNS 消息的最大长度（UDP 应答按 EDNS 常见的缓冲区大小接收）
+	dnsMaxMessage = 4096
+)
+
+// DNS 记录类型和标志
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeAAAA  = 28
+	dnsClassIN   = 1
+
+	dnsFlagResponse  = 0x8000
+	dnsFlagTruncated = 0x0200
+	dnsFlagRecursion = 0x0100
+	dnsRcodeMask     = 0x000f
+	dnsRcodeNXDomain = 3
+)
+
+// ResolverOptions 主机名解析配置
+type ResolverOptions struct {
+	// Server DNS 服务器地址（host:port），为空时使用系统解析器
+	Server string
+	// SystemTTL 系统解析器结果的缓存时间，0 表示默认 30 秒
+	SystemTTL time.Duration
+	// MinTTL、MaxTTL 缓存时间的下限和上限，0 表示默认 5 秒和 1 小时
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// Timeout 单次查询超时，0 表示默认 5 秒
+	Timeout time.Duration
+}
+
+// Resolver 带 TTL 缓存的主机名解析器，为空时每次都通过系统解析器解析、不缓存
+type Resolver struct {
+	opts ResolverOptions
+
+	mu      sync.Mutex
+	cache   map[resolverKey]resolverEntry
+	pending map[resolverKey]*resolverCall
+}
+
+// resolverKey 缓存键：主机名和解析使用的网络（ip、ip4 或 ip6）
+type resolverKey struct {
+	host    string
+	network string
+}
+
+// resolverEntry 缓存的解析结果
+type resolverEntry struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// resolverCall 进行中的查询，完成后关闭 done
+type resolverCall struct {
+	done chan struct{}
+	resolverEntry
+	err error
+}
+
+// NewResolver 创建解析器，Server 未指定端口时使用 53
+func NewResolver(opts ResolverOptions) (*Resolver, error) {
+	if opts.Server != "" {
+		server, err := normalizeDNSServer(opts.Server)
+		if err != nil {
+			return nil, err
+		}
+		opts.Server = server
+	}
+	if opts.SystemTTL < 0 || opts.MinTTL < 0 || opts.MaxTTL < 0 || opts.Timeout < 0 {
+		return nil, fmt.Errorf("DNS 缓存时间和超时不能为负数")
+	}
+	r := &Resolver{
+		opts:    opts,
+		cache:   make(map[resolverKey]resolverEntry),
+		pending: make(map[resolverKey]*resolverCall),
+	}
+	if r.minTTL() > r.maxTTL() {
+		return nil, fmt.Errorf("DNS 最小 TTL %v 大于最大 TTL %v", r.minTTL(), r.maxTTL())
+	}
+	return r, nil
+}
+
+// normalizeDNSServer 为 DNS 服务器地址补充默认端口 53，IPv6 地址可以不带方括号
+func normalizeDNSServer(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+	host := strings.Trim(server, "[]")
+	if host == "" {
+		return "", fmt.Errorf("无效的 DNS 服务器地址: %q", server)
+	}
+	return net.JoinHostPort(host, "53"), nil
+}
+
+// String 返回解析器的描述，用于日志
+func (r *Resolver) String() string {
+	if r == nil {
+		return "系统解析器（不缓存）"
+	}
+	if r.opts.Server == "" {
+		return fmt.Sprintf("系统解析器（缓存 %v）", r.systemTTL())
+	}
+	return fmt.Sprintf("DNS 服务器 %s（TTL %v~%v）", r.opts.Server, r.minTTL(), r.maxTTL())
+}
+
+// systemTTL 返回系统解析器结果的缓存时间
+func (r *Resolver) systemTTL() time.Duration {
+	if r.opts.SystemTTL > 0 {
+		return r.opts.SystemTTL
+	}
+	return defaultSystemTTL
+}
+
+// minTTL 返回 TTL 下限
+func (r *Resolver) minTTL() time.Duration {
+	if r.opts.MinTTL > 0 {
+		return r.opts.MinTTL
+	}
+	return defaultMinTTL
+}
+
+// maxTTL 返回 TTL 上限
+func (r *Resolver) maxTTL() time.Duration {
+	if r.opts.MaxTTL > 0 {
+		return r.opts.MaxTTL
+	}
+	return defaultMaxTTL
+}
+
+// timeout 返回单次查询超时
+func (r *Resolver) timeout() time.Duration {
+	if r.opts.Timeout > 0 {
+		return r.opts.Timeout
+	}
+	return defaultResolveTimeout
+}
+
+// lookup 按 network（ip、ip4 或 ip6）解析主机名，返回去除 IPv4 映射的地址和结果的过期时间。
+// 缓存未过期时直接返回，返回的切片不能修改；r 为空时直接调用系统解析器，过期时间为零值
+func (r *Resolver) lookup(ctx context.Context, host, network string) ([]netip.Addr, time.Time, error) {
+	if r == nil {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+		return unmapAddrs(addrs), time.Time{}, err
+	}
+
+	key := resolverKey{host: strings.ToLower(strings.TrimSuffix(host, ".")), network: network}
+	r.mu.Lock()
+	if entry, ok := r.cache[key]; ok && time.Now().Before(entry.expires) {
+		r.mu.Unlock()
+		return entry.addrs, entry.expires, nil
+	}
+	call, ok := r.pending[key]
+	if !ok {
+		call = &resolverCall{done: make(chan struct{})}
+		r.pending[key] = call
+		// 查询不受调用方 ctx 影响，调用方取消后其余等待者仍能得到结果
+		go r.refresh(key, call)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.addrs, call.expires, call.err
+	case <-ctx.Done():
+		return nil, time.Time{}, ctx.Err()
+	}
+}
+
+// refresh 查询 key 并更新缓存，失败时继续使用过期的结果（主机名不存在除外）
+func (r *Resolver) refresh(key resolverKey, call *resolverCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout())
+	addrs, ttl, err := r.query(ctx, key.host, key.network)
+	cancel()
+
+	now := time.Now()
+	r.mu.Lock()
+	delete(r.pending, key)
+	if err == nil {
+		ttl = min(max(ttl, r.minTTL()), r.maxTTL())
+		call.resolverEntry = resolverEntry{addrs: addrs, expires: now.Add(ttl)}
+		if len(r.cache) >= resolverCacheSize {
+			r.purge(now)
+		}
+		r.cache[key] = call.resolverEntry
+	} else if old, ok := r.cache[key]; ok && !isNotFound(err) {
+		old.expires = now.Add(r.minTTL())
+		r.cache[key] = old
+		call.resolverEntry = old
+		log.Printf("[DNS] 重新解析 %s 失败，继续使用上一次的结果 %v: %v", key.host, old.addrs, err)
+	} else {
+		call.err = err
+	}
+	r.mu.Unlock()
+	close(call.done)
+}
+
+// purge 删除过期的缓存条目，调用方持有 r.mu
+func (r *Resolver) purge(now time.Time) {
+	for key, entry := range r.cache {
+		if !now.Before(entry.expires) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// isNotFound 判断解析错误是否表示主机名不存在
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// query 查询主机名，返回地址和 TTL：系统解析器使用固定的 SystemTTL，
+// DNS 服务器分别查询 A 和 AAAA 记录，IPv4 地址排在前面
+func (r *Resolver) query(ctx context.Context, host, network string) ([]netip.Addr, time.Duration, error) {
+	if r.opts.Server == "" {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+		if err != nil {
+			return nil, 0, err
+		}
+		return unmapAddrs(addrs), r.systemTTL(), nil
+	}
+
+	var types []uint16
+	switch network {
+	case "ip4":
+		types = []uint16{dnsTypeA}
+	case "ip6":
+		types = []uint16{dnsTypeAAAA}
+	default:
+		types = []uint16{dnsTypeA, dnsTypeAAAA}
+	}
+
+	type answer struct {
+		addrs []netip.Addr
+		ttl   time.Duration
+		err   error
+	}
+	answers := make([]answer, len(types))
+	var wg sync.WaitGroup
+	for i, qtype := range types {
+		wg.Add(1)
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+			a := &answers[i]
+			a.addrs, a.ttl, a.err = r.exchange(ctx, host, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var addrs []netip.Addr
+	var ttl time.Duration
+	var firstErr error
+	for _, a := range answers {
+		if a.err != nil {
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			continue
+		}
+		// 没有记录的应答（NODATA）不影响 TTL，按 TTL 下限缓存
+		if len(a.addrs) > 0 && (ttl == 0 || a.ttl < ttl) {
+			ttl = a.ttl
+		}
+		addrs = append(addrs, a.addrs...)
+	}
+	if len(addrs) > 0 {
+		return addrs, ttl, nil
+	}
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: r.opts.Server, IsNotFound: true}
+}
+
+// exchange 向 DNS 服务器查询一种记录，应答被截断时改用 TCP 重新查询
+func (r *Resolver) exchange(ctx context.Context, host string, qtype uint16) ([]netip.Addr, time.Duration, error) {
+	id := uint16(rand.Uint32())
+	query, err := buildDNSQuery(id, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msg, err := exchangeDNS(ctx, "udp", r.opts.Server, id, query)
+	if err == nil && binary.BigEndian.Uint16(msg[2:])&dnsFlagTruncated != 0 {
+		msg, err = exchangeDNS(ctx, "tcp", r.opts.Server, id, query)
+	}
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.opts.Server, IsTimeout: errors.Is(err, context.DeadlineExceeded)}
+	}
+
+	addrs, ttl, err := parseDNSResponse(msg, qtype)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			dnsErr.Name, dnsErr.Server = host, r.opts.Server
+			return nil, 0, dnsErr
+		}
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.opts.Server}
+	}
+	return addrs, ttl, nil
+}
+
+// exchangeDNS 通过 UDP 或 TCP（两字节长度前缀）发送查询并读取编号为 id 的应答
+func exchangeDNS(ctx context.Context, network, server string, id uint16, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		frame := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(frame, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, err
+		}
+		if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+			return nil, fmt.Errorf("DNS 应答无效")
+		}
+		return msg, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, dnsMaxMessage)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略编号不符的应答（之前超时的查询或伪造的应答）
+		if n >= 12 && binary.BigEndian.Uint16(buffer) == id {
+			return buffer[:n], nil
+		}
+	}
+}
+
+// buildDNSQuery 构造请求递归解析的查询消息
+func buildDNSQuery(id uint16, host string, qtype uint16) ([]byte, error) {
+	name := strings.TrimSuffix(host, ".")
+	if name == "" || len(name) > 253 {
+		return nil, fmt.Errorf("无效的主机名: %q", host)
+	}
+
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1) // 问题数
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("无效的主机名: %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	return msg, nil
+}
+
+// parseDNSResponse 解析应答中 qtype 类型的地址记录，TTL 取地址记录和 CNAME 记录中的最小值
+func parseDNSResponse(msg []byte, qtype uint16) ([]netip.Addr, time.Duration, error) {
+	if len(msg) < 12 {
+		return nil, 0, fmt.Errorf("DNS 应答过短")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, 0, fmt.Errorf("DNS 应答无效")
+	}
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, 0, &net.DNSError{Err: "no such host", IsNotFound: true}
+	default:
+		return nil, 0, fmt.Errorf("DNS 服务器返回错误码 %d", rcode)
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4
+	}
+
+	var addrs []netip.Addr
+	ttl := time.Duration(-1)
+	for i := 0; i < records; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, fmt.Errorf("DNS 应答被截断")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		class := binary.BigEndian.Uint16(msg[off+2:])
+		recordTTL := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, 0, fmt.Errorf("DNS 应答被截断")
+		}
+		data := msg[off : off+length]
+		off += length
+
+		if class != dnsClassIN {
+			continue
+		}
+		switch {
+		case rtype == qtype && rtype == dnsTypeA && length == 4:
+			addrs = append(addrs, netip.AddrFrom4([4]byte(data)))
+		case rtype == qtype && rtype == dnsTypeAAAA && length == 16:
+			addrs = append(addrs, netip.AddrFrom16([16]byte(data)).Unmap())
+		case rtype == dnsTypeCNAME:
+		default:
+			continue
+		}
+		if ttl < 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+	}
+	return addrs, max(ttl, 0), nil
+}
+
+// skipDNSName 跳过 off 处的域名（可能以压缩指针结尾），返回之后的偏移
+func skipDNSName(msg []byte, off int) (int, error) {
+	for off < len(msg) {
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return 0, fmt.Errorf("DNS 应答被截断")
+			}
+			return off + 2, nil
+		case length&0xc0 != 0:
+			return 0, fmt.Errorf("DNS 应答包含无效的域名")
+		}
+		off += 1 + length
+	}
+	return 0, fmt.Errorf("DNS 应答被截断")
+}
+
+// unmapAddrs 去除 IPv4 映射的 IPv6 地址
+func unmapAddrs(addrs []netip.Addr) []netip.Addr {
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs
+}
+
+// watch 在 address 中主机名的解析结果过期后重新解析，地址集合变化时以新的地址（按地址族排序）调用 changed，
+// 直到 ctx 取消。address 每次重新读取，可以随 GOAWAY 切换；IP 字面量和 Unix 地址不需要解析，r 为空时直接返回
+func (r *Resolver) watch(ctx context.Context, address func() string, family AddressFamily, changed func(host string, addrs []netip.Addr)) {
+	if r == nil {
+		return
+	}
+
+	var lastHost string
+	var last []netip.Addr
+	for {
+		wait := r.minTTL()
+		host, _, err := net.SplitHostPort(address())
+		if _, parseErr := netip.ParseAddr(host); err == nil && host != "" && parseErr != nil {
+			addrs, expires, err := r.lookup(ctx, host, family.lookupNetwork())
+			if err == nil {
+				addrs = sortAddrs(addrs, family)
+				if len(addrs) > 0 {
+					if host == lastHost && !sameAddrs(last, addrs) {
+						log.Printf("[DNS] %s 的地址由 %v 变为 %v", host, last, addrs)
+						changed(host, addrs)
+					}
+					lastHost, last = host, addrs
+				}
+				// 稍晚于过期时间，保证下一次 lookup 重新查询
+				wait = time.Until(expires) + 10*time.Millisecond
+			} else if ctx.Err() == nil {
+				log.Printf("[DNS] 重新解析 %s 失败: %v", host, err)
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// sameAddrs 两组地址是否相同（忽略顺序）
+func sameAddrs(a, b []netip.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, netip.Addr.Compare)
+	slices.SortFunc(b, netip.Addr.Compare)
+	return slices.Equal(a, b)
+}
+
+// addrMoved 连接的对端地址不在解析结果 addrs 中时返回 true；
+// 对端地址不是 IP 地址（内存传输或自定义传输）时无法比较，返回 false
+func addrMoved(addrs []netip.Addr, remote net.Addr) bool {
+	addr := addrPortOf(remote).Addr()
+	return addr.IsValid() && !containsAddr(addrs, addr)
+}
+
+// containsAddr addrs 是否包含 addr（忽略 zone 和 IPv4 映射）
+func containsAddr(addrs []netip.Addr, addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, a := range addrs {
+		if a.WithZone("") == addr {
+			return true
+		}
+	}
+	return false
+}
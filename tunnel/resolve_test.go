@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestAddrMoved(t *testing.T) {
+	addrs := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+	cases := []struct {
+		remote net.Addr
+		moved  bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9090}, false},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 9090}, false},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 9090}, false},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 9090}, true},
+		// 内存传输等非 IP 地址无法比较，不视为已变化
+		{memoryAddr("server"), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if moved := addrMoved(addrs, c.remote); moved != c.moved {
+			t.Errorf("addrMoved(%v) = %v，期望 %v", c.remote, moved, c.moved)
+		}
+	}
+}
@@ -0,0 +1,659 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 服务端模块
+// ===============================
+
+// TunnelServer UDP 隧道服务端
+type TunnelServer struct {
+	opts        Options
+	listenTCP   string
+	targetUDP   string
+	originLimit *sourceLimiter // 按原始客户端 IP 限制发往目标的数据包
+	tunnelLimit *rateLimiter   // 整个隧道的应答限速
+	sourceLimit *sourceLimiter // 按原始客户端 IP 的应答限速
+	connLimit   *connLimiter
+	rtt         *rttRecorder // 全部隧道连接的往返时延
+	listener    net.Listener
+	mu          sync.Mutex
+	bonds       map[string]*bondStream // 多路径绑定会话，按绑定编号索引
+	sessions    *sessionRegistry
+	lifecycle
+}
+
+// NewTunnelServer 创建新的隧道服务端
+func NewTunnelServer(opts Options) *TunnelServer {
+	return &TunnelServer{
+		opts:        opts,
+		listenTCP:   opts.LocalAddr,
+		targetUDP:   opts.RemoteAddr,
+		bonds:       make(map[string]*bondStream),
+		sessions:    newSessionRegistry(opts.Hooks),
+		lifecycle:   newLifecycle(),
+		originLimit: newSourceLimiter(RateLimit{PacketsPerSecond: opts.OriginRate}),
+		tunnelLimit: newRateLimiter(opts.RateLimits.Tunnel),
+		sourceLimit: newSourceLimiter(opts.RateLimits.SourceIP),
+		connLimit:   newConnLimiter(opts.ConnLimits),
+		rtt:         newRTTRecorder(nil),
+	}
+}
+
+// Serve 启动服务端并接受连接，直到 ctx 取消或调用 Close
+func (s *TunnelServer) Serve(ctx context.Context) error {
+	log.Printf("启动服务端模式 - 监听 TCP: %s, 目标 UDP: %s", s.listenTCP, s.targetUDP)
+
+	listener, err := s.opts.listenTunnel()
+	if err != nil {
+		return fmt.Errorf("监听 TCP 失败: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	defer s.Close()
+
+	if s.closed() {
+		return nil
+	}
+	s.watch(ctx, s.Close)
+
+	log.Printf("UDP 隧道服务端已启动，监听地址: %s", listener.Addr())
+
+	return s.acceptConnections()
+}
+
+// Close 停止接受连接，配置了 Drain 时向客户端发送 GOAWAY 并等待会话结束，然后关闭所有会话
+func (s *TunnelServer) Close() error {
+	s.shutdown(func() {
+		s.mu.Lock()
+		listener := s.listener
+		s.mu.Unlock()
+		if listener != nil {
+			listener.Close()
+		}
+		s.sessions.drain(s.opts.Drain)
+		s.sessions.closeAll()
+	})
+	return nil
+}
+
+// Sessions 返回当前活动会话
+func (s *TunnelServer) Sessions() []SessionInfo {
+	return s.sessions.list()
+}
+
+// RTT 返回全部隧道连接的往返时延统计，需要客户端启用保活
+func (s *TunnelServer) RTT() RTTStats {
+	return s.rtt.snapshot()
+}
+
+// CloseCounts 返回按原因码统计的带关闭原因的会话数（控制帧通知的关闭和超时关闭）
+func (s *TunnelServer) CloseCounts() map[ReasonCode]uint64 {
+	return s.sessions.closeCounts()
+}
+
+// acceptConnections 接受客户端连接
+func (s *TunnelServer) acceptConnections() error {
+	aclName := "隧道监听 " + s.listener.Addr().String()
+	var backoff acceptBackoff
+	for {
+		tcpConn, err := s.listener.Accept()
+		if err != nil {
+			if s.closed() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("接受连接失败: %v，%s 后重试", err, backoff.next())
+			backoff.wait(s.done)
+			continue
+		}
+		backoff.reset()
+
+		if !s.opts.ACL.check(tcpConn.RemoteAddr(), aclName) {
+			tcpConn.Close()
+			continue
+		}
+
+		// 超过并发数时按配置立即拒绝，或暂停接受新连接等待名额
+		release, err := s.connLimit.acquire(s.ctx, tcpConn.RemoteAddr(), true)
+		if err != nil {
+			if !s.closed() {
+				log.Printf("拒绝来自 %s 的连接: %v", tcpConn.RemoteAddr().String(), err)
+			}
+			tcpConn.Close()
+			continue
+		}
+
+		log.Printf("接受来自 %s 的连接", tcpConn.RemoteAddr().String())
+
+		// 为每个连接启动处理协程
+		go func() {
+			defer release()
+			s.handleClientConnection(tcpConn)
+		}()
+	}
+}
+
+// handleClientConnection 处理客户端连接（新版）
+func (s *TunnelServer) handleClientConnection(tcpConn net.Conn) {
+	rawConn := tcpConn
+	if s.opts.AcceptProxyProtocol {
+		proxied, err := acceptProxyHeader(tcpConn)
+		if err != nil {
+			log.Printf("[客户端 %s] %v", tcpConn.RemoteAddr().String(), err)
+			tcpConn.Close()
+			return
+		}
+		tcpConn = proxied
+	}
+
+	// 读取会话头，获取原始 UDP 客户端地址；旧版本客户端没有会话头
+	conn, meta, err := acceptSessionHeader(tcpConn)
+	if err != nil {
+		log.Printf("[客户端 %s] %v", tcpConn.RemoteAddr().String(), err)
+		tcpConn.Close()
+		return
+	}
+	tcpConn = conn
+	if meta == nil {
+		log.Printf("[客户端 %s] 未收到会话头，按旧版本客户端处理（不协商任何功能）", tcpConn.RemoteAddr().String())
+		meta = NewSessionMeta()
+	}
+
+	// 多路径绑定会话的第一条路径在发送会话应答之前登记，客户端收到应答后才建立其余路径，
+	// 保证其余路径总能找到已登记的会话
+	user := peerIdentity(rawConn)
+	bondID := meta.Get(metaBond)
+	bondMode, bondErr := ParseBondMode(meta.Get(metaBondMode))
+	var bond, joining *bondStream
+	if bondID != "" && bondErr == nil {
+		s.mu.Lock()
+		if joining = s.bonds[bondID]; joining == nil {
+			bond = newBondStream(bondMode)
+			bond.identity = user
+			s.bonds[bondID] = bond
+		}
+		s.mu.Unlock()
+	}
+	if bond != nil {
+		// 会话结束或建立失败时注销并关闭绑定会话（会话建立后由 ServerConnection 关闭，重复关闭无影响）
+		defer func() {
+			s.mu.Lock()
+			if s.bonds[bondID] == bond {
+				delete(s.bonds, bondID)
+			}
+			s.mu.Unlock()
+			bond.close()
+		}()
+	}
+
+	params, err := acceptSession(tcpConn, meta, &s.opts)
+	if err != nil {
+		log.Printf("[客户端 %s] %v", tcpConn.RemoteAddr().String(), err)
+		tcpConn.Close()
+		return
+	}
+	if params.mux != 0 {
+		log.Printf("[客户端 %s] UDP 隧道不支持多路复用", tcpConn.RemoteAddr().String())
+		rejectSession(tcpConn, params, ReasonProtocolError, "UDP 隧道不支持多路复用")
+		return
+	}
+
+	origin, err := netip.ParseAddrPort(meta.Get(metaSource))
+	if err != nil && meta.Get(metaSource) != "" {
+		log.Printf("[客户端 %s] 无效的原始客户端地址 %q: %v", tcpConn.RemoteAddr().String(), meta.Get(metaSource), err)
+	}
+
+	if !s.originAllowed(origin) {
+		log.Printf("[客户端 %s] 拒绝原始客户端 %s：不在允许的网段内", tcpConn.RemoteAddr().String(), origin)
+		rejectSession(tcpConn, params, ReasonRejected, "原始客户端不在允许的网段内")
+		return
+	}
+
+	targetUDP := s.targetUDP
+	if target := meta.Get(metaTarget); target != "" {
+		if !s.opts.DynamicTarget {
+			log.Printf("[客户端 %s] 拒绝客户端指定的目标 %s（未启用动态目标）", tcpConn.RemoteAddr().String(), target)
+			rejectSession(tcpConn, params, ReasonRejected, "服务端未启用动态目标")
+			return
+		}
+		targetUDP = target
+	}
+
+	if bondID != "" && bondErr != nil {
+		log.Printf("[客户端 %s] %v", tcpConn.RemoteAddr().String(), bondErr)
+		rejectSession(tcpConn, params, ReasonProtocolError, bondErr.Error())
+		return
+	}
+
+	// 多路径绑定会话的后续路径加入已有会话，路径断开前一直占用连接数名额
+	// 使用 TLS 客户端证书时只有与第一条路径身份相同的客户端可以加入，其他客户端即使知道会话编号也无法加入
+	if joining != nil {
+		if user != joining.identity {
+			log.Printf("[客户端 %s] 拒绝加入绑定会话 %s：客户端身份 %q 与第一条路径 %q 不一致", tcpConn.RemoteAddr().String(), bondID, user, joining.identity)
+			rejectSession(tcpConn, params, ReasonRejected, "客户端身份与绑定会话不一致")
+			return
+		}
+		pathDone := joining.addPath(newSessionHandler(tcpConn, params, newRTTRecorder(s.rtt), &s.opts))
+		if pathDone == nil {
+			log.Printf("[客户端 %s] 绑定会话 %s 已结束", tcpConn.RemoteAddr().String(), bondID)
+			rejectSession(tcpConn, params, ReasonRejected, "绑定会话已结束")
+			return
+		}
+		log.Printf("[客户端 %s] 加入绑定会话 %s，当前路径: %s", peerName(tcpConn), bondID, joining.pathNames())
+		<-pathDone
+		return
+	}
+
+	var fec FECOptions
+	if value := meta.Get(metaFEC); value != "" {
+		fec, err = ParseFECOptions(value)
+		if err != nil {
+			log.Printf("[客户端 %s] %v", tcpConn.RemoteAddr().String(), err)
+			rejectSession(tcpConn, params, ReasonProtocolError, err.Error())
+			return
+		}
+		fec.FlushTimeout = s.opts.FEC.FlushTimeout
+	}
+
+	account, err := s.opts.Accounting.open(user)
+	if err != nil {
+		log.Printf("[客户端 %s] 拒绝用户 %s 的会话: %v", tcpConn.RemoteAddr().String(), user, err)
+		rejectSession(tcpConn, params, ReasonQuotaExceeded, err.Error())
+		return
+	}
+
+	serverConn, err := newServerConnection(tcpConn, targetUDP, newSocketDialer(s.opts.Socket, s.opts.Bind), s.opts.Resolver, s.opts.Family.Remote)
+	if err != nil {
+		log.Printf("创建服务端连接失败: %v", err)
+		rejectSession(tcpConn, params, ReasonTargetUnreachable, err.Error())
+		account.close()
+		return
+	}
+	serverConn.account = account
+	if params.control {
+		serverConn.rtt = newRTTRecorder(s.rtt)
+		serverConn.tcpHandler.enableControl(serverConn.rtt, s.opts.Control.RejectUnknown)
+		serverConn.tcpHandler.startKeepalive(s.opts.Keepalive)
+	}
+	serverConn.origin = origin
+	serverConn.originHeader = s.opts.OriginHeader
+	// 未提供原始客户端地址的客户端（例如旧版本客户端）按隧道连接的地址限速，不能借此绕过按 IP 的限速
+	source := origin.Addr()
+	if !origin.IsValid() {
+		source = addrPortOf(tcpConn.RemoteAddr()).Addr()
+	}
+	serverConn.originLimit = newTrafficLimiter(s.originLimit.get(source))
+	serverConn.limit = newTrafficLimiter(s.tunnelLimit, s.sourceLimit.get(source), newRateLimiter(s.opts.RateLimits.Session))
+	if bond != nil {
+		bond.addPath(serverConn.tcpHandler)
+		serverConn.bond = bond
+		serverConn.packets = bond
+	}
+	if fec.enabled() {
+		serverConn.fec, err = newFECStream(serverConn.packets, fec)
+		if err != nil {
+			log.Printf("[客户端 %s] %v", serverConn.clientAddr, err)
+			serverConn.Close()
+			return
+		}
+		serverConn.packets = serverConn.fec
+	}
+	if params.codec != nil {
+		serverConn.codec = params.codec
+		serverConn.packets = &compressStream{inner: serverConn.packets, codec: params.codec}
+	}
+
+	info := SessionInfo{
+		Protocol:   "udp",
+		ClientAddr: serverConn.clientAddr,
+		Target:     targetUDP,
+		User:       user,
+		rtt:        serverConn.rtt,
+	}
+	if origin.IsValid() {
+		info.Origin = origin.String()
+	}
+	serverConn.sessions = s.sessions
+	serverConn.sessionID = s.sessions.open(info, func() {
+		serverConn.closeWithReason(ReasonShutdown, "服务端正在关闭")
+	})
+	defer s.sessions.close(serverConn.sessionID)
+	if params.control {
+		s.sessions.setGoaway(serverConn.sessionID, serverConn.tcpHandler.sendGoaway)
+	}
+
+	log.Printf("[客户端 %s] 连接已建立，原始 UDP 客户端: %s，目标: %s，开始处理数据", serverConn.clientAddr, origin, targetUDP)
+	serverConn.Start()
+}
+
+// originAllowed 检查原始客户端是否在允许的网段内。原始客户端地址由隧道客户端在会话头中提供，
+// 服务端无法验证，只有隧道客户端可信（例如通过 TLS 客户端证书认证）时检查才有意义
+func (s *TunnelServer) originAllowed(origin netip.AddrPort) bool {
+	if len(s.opts.OriginAllow) == 0 {
+		return true
+	}
+	return origin.IsValid() && prefixesContain(s.opts.OriginAllow, origin.Addr())
+}
+
+// ServerConnection 服务端连接管理
+type ServerConnection struct {
+	tcpHandler   *TCPPacketHandler
+	packets      packetStream // 数据包读写，依次叠加多路径绑定、前向纠错和压缩
+	bond         *bondStream
+	fec          *fecStream
+	codec        *frameCodec
+	udpMu        sync.Mutex // 保护 udpConn 和 udpClosed，重新连接或迁移时替换 udpConn
+	udpConn      net.Conn
+	udpClosed    bool
+	clientAddr   string
+	targetUDP    string          // 保存目标UDP地址
+	dialer       *socketDialer   // 连接目标 UDP 的拨号器，重新连接时使用
+	resolver     *Resolver       // 解析目标主机名的解析器，可为空
+	family       AddressFamily   // 解析目标 UDP 地址的地址族
+	origin       netip.AddrPort  // 原始 UDP 客户端地址，未知时无效
+	originHeader bool            // 发往目标的数据报附加来源地址头
+	originLimit  *trafficLimiter // 按原始客户端 IP 限制发往目标的数据包，可为空
+	limit        *trafficLimiter // 应答限速，可为空
+	account      *quotaSession   // 用户流量计费，未认证时为空
+	rtt          *rttRecorder    // 隧道连接的往返时延，未启用控制帧时为空
+	sessions     *sessionRegistry
+	sessionID    uint64
+	closeOnce    sync.Once
+}
+
+// NewServerConnection 创建新的服务端连接
+func NewServerConnection(tcpConn net.Conn, targetUDP string) (*ServerConnection, error) {
+	return newServerConnection(tcpConn, targetUDP, newSocketDialer(SocketOptions{}, BindOptions{}), nil, FamilyAny)
+}
+
+// newServerConnection 创建服务端连接，通过 resolver 按地址族 family 解析目标地址并通过 dialer 连接目标 UDP 服务
+func newServerConnection(tcpConn net.Conn, targetUDP string, dialer *socketDialer, resolver *Resolver, family AddressFamily) (*ServerConnection, error) {
+	// 连接到目标 UDP 服务
+	udpConn, err := dialUDPTarget(targetUDP, dialer, resolver, family)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpHandler := NewTCPPacketHandler(tcpConn)
+	return &ServerConnection{
+		tcpHandler: tcpHandler,
+		packets:    tcpHandler,
+		udpConn:    udpConn,
+		clientAddr: peerName(tcpConn),
+		targetUDP:  targetUDP,
+		dialer:     dialer,
+		resolver:   resolver,
+		family:     family,
+	}, nil
+}
+
+// dialUDPTarget 连接目标数据报服务：UDP 地址或 unixgram:// 路径
+func dialUDPTarget(targetUDP string, dialer *socketDialer, resolver *Resolver, family AddressFamily) (net.Conn, error) {
+	network, addr := splitAddress(targetUDP, "udp")
+	if network == "unixgram" {
+		conn, err := dialUnixgram(addr)
+		if err != nil {
+			return nil, fmt.Errorf("连接到目标 Unix 数据报套接字失败: %w", err)
+		}
+		return conn, nil
+	}
+
+	udpAddr, err := resolveUDP(context.Background(), resolver, addr, family)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标 UDP 地址失败: %w", err)
+	}
+
+	udpConn, err := dialer.dialUDP(udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("连接到目标 UDP 失败: %w", err)
+	}
+	return udpConn, nil
+}
+
+// Start 启动连接处理
+func (sc *ServerConnection) Start() {
+	defer sc.Close()
+
+	// 启动 UDP 响应处理协程
+	go sc.handleUDPResponse(sc.target())
+
+	// 目标主机名的解析结果变化后迁移到新地址
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sc.watchTarget(ctx)
+
+	// 处理来自客户端的数据
+	sc.handleClientData()
+}
+
+// target 返回当前的目标连接
+func (sc *ServerConnection) target() net.Conn {
+	sc.udpMu.Lock()
+	defer sc.udpMu.Unlock()
+	return sc.udpConn
+}
+
+// replaceTarget 把目标连接换成 conn 并开始接收它的应答，返回原来的连接；会话已关闭时关闭 conn 并返回 false
+func (sc *ServerConnection) replaceTarget(conn net.Conn) (net.Conn, bool) {
+	sc.udpMu.Lock()
+	if sc.udpClosed {
+		sc.udpMu.Unlock()
+		conn.Close()
+		return nil, false
+	}
+	old := sc.udpConn
+	sc.udpConn = conn
+	sc.udpMu.Unlock()
+
+	go sc.handleUDPResponse(conn)
+	return old, true
+}
+
+// watchTarget 目标主机名的解析结果变化且不再包含当前连接的地址时，连接新地址，
+// 旧连接继续接收在途的应答，一段时间后关闭
+func (sc *ServerConnection) watchTarget(ctx context.Context) {
+	network, addr := splitAddress(sc.targetUDP, "udp")
+	if network == "unixgram" {
+		return
+	}
+	sc.resolver.watch(ctx, func() string { return addr }, sc.family, func(host string, addrs []netip.Addr) {
+		current := sc.target()
+		if current == nil || !addrMoved(addrs, current.RemoteAddr()) {
+			return
+		}
+		newConn, err := dialUDPTarget(sc.targetUDP, sc.dialer, sc.resolver, sc.family)
+		if err != nil {
+			log.Printf("[客户端 %s] 目标 %s 的地址已变化，连接新地址失败: %v", sc.clientAddr, sc.targetUDP, err)
+			return
+		}
+		old, ok := sc.replaceTarget(newConn)
+		if !ok {
+			return
+		}
+		log.Printf("[客户端 %s] 目标 %s 的地址已变化，会话从 %s 迁移到 %s", sc.clientAddr, sc.targetUDP, old.RemoteAddr(), newConn.RemoteAddr())
+		time.AfterFunc(goawayDrainTime, func() { old.Close() })
+	})
+}
+
+// handleUDPResponse 处理目标连接 conn 的 UDP 响应，conn 被替换后关闭时退出
+func (sc *ServerConnection) handleUDPResponse(conn net.Conn) {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(udpReadTimeout))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			if conn != sc.target() {
+				return
+			}
+			log.Printf("[客户端 %s] 读取 UDP 响应失败: %v", sc.clientAddr, err)
+			return
+		}
+
+		// 超过限速的应答直接丢弃
+		if !sc.limit.police(n) {
+			continue
+		}
+
+		if err := sc.account.add(n); err != nil {
+			log.Printf("[客户端 %s] %v，关闭会话", sc.clientAddr, err)
+			sc.closeWithReason(ReasonQuotaExceeded, err.Error())
+			return
+		}
+
+		// 发送响应回客户端
+		if err := sc.packets.WritePacket(buffer[:n]); err != nil {
+			log.Printf("[客户端 %s] 发送 TCP 响应失败: %v", sc.clientAddr, err)
+			return
+		}
+	}
+}
+
+// handleClientData 处理客户端数据
+func (sc *ServerConnection) handleClientData() {
+	for {
+		data, err := sc.packets.ReadPacket()
+		if closeErr := peerClose(err); closeErr != nil {
+			log.Printf("[客户端 %s] 客户端关闭会话: %s", sc.clientAddr, closeErr.Reason())
+			sc.sessions.setCloseReason(sc.sessionID, closeErr)
+			return
+		}
+		if err != nil {
+			log.Printf("[客户端 %s] 读取客户端数据失败: %v", sc.clientAddr, err)
+			return
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		// 按原始客户端限速，超限的数据包直接丢弃
+		if !sc.originLimit.police(len(data)) {
+			continue
+		}
+
+		if err := sc.account.add(len(data)); err != nil {
+			log.Printf("[客户端 %s] %v，关闭会话", sc.clientAddr, err)
+			sc.closeWithReason(ReasonQuotaExceeded, err.Error())
+			return
+		}
+
+		if sc.originHeader && sc.origin.IsValid() {
+			data = appendOriginHeader(nil, sc.origin, data)
+		}
+
+		// 转发到目标 UDP 服务
+		if err := sc.forwardToUDP(data); err != nil {
+			log.Printf("[客户端 %s] 转发到 UDP 失败: %v", sc.clientAddr, err)
+			sc.closeWithReason(ReasonTargetUnreachable, err.Error())
+			return
+		}
+	}
+}
+
+// forwardToUDP 转发数据到 UDP
+func (sc *ServerConnection) forwardToUDP(data []byte) error {
+	_, err := sc.target().Write(data)
+	if err != nil {
+		// 如果UDP连接失败，尝试重新建立连接
+		if sc.reconnectUDP() == nil {
+			// 重试发送
+			_, retryErr := sc.target().Write(data)
+			if retryErr == nil {
+				log.Printf("[客户端 %s] UDP连接重建成功，数据发送完成", sc.clientAddr)
+				return nil
+			}
+		}
+		return fmt.Errorf("向 UDP 服务写入数据失败: %w", err)
+	}
+	return nil
+}
+
+// reconnectUDP 重新连接UDP（带重试机制）
+func (sc *ServerConnection) reconnectUDP() error {
+	if udpConn := sc.target(); udpConn != nil {
+		udpConn.Close()
+	}
+
+	// 使用保存的目标UDP地址重试连接
+	var lastErr error
+	for i := 0; i < udpRetryCount; i++ {
+		newConn, err := dialUDPTarget(sc.targetUDP, sc.dialer, sc.resolver, sc.family)
+		if err == nil {
+			if _, ok := sc.replaceTarget(newConn); !ok {
+				return fmt.Errorf("会话已关闭")
+			}
+			log.Printf("[客户端 %s] UDP连接已重建到 %s (重试 %d/%d)", sc.clientAddr, sc.targetUDP, i+1, udpRetryCount)
+			return nil
+		}
+
+		lastErr = err
+		log.Printf("[客户端 %s] UDP重连失败 (尝试 %d/%d): %v", sc.clientAddr, i+1, udpRetryCount, err)
+
+		if i < udpRetryCount-1 {
+			time.Sleep(udpRetryInterval)
+		}
+	}
+
+	return fmt.Errorf("重新连接到目标 UDP 失败（已重试 %d 次）: %w", udpRetryCount, lastErr)
+}
+
+// closeWithReason 通知客户端关闭原因后关闭连接
+func (sc *ServerConnection) closeWithReason(code ReasonCode, message string) {
+	sc.sessions.setCloseReason(sc.sessionID, &CloseError{Code: code, Message: message})
+	sc.tcpHandler.sendClose(code, message)
+	sc.Close()
+}
+
+// Close 关闭连接
+func (sc *ServerConnection) Close() {
+	sc.closeOnce.Do(func() {
+		if sc.tcpHandler != nil && sc.tcpHandler.conn != nil {
+			sc.tcpHandler.conn.Close()
+		}
+		sc.udpMu.Lock()
+		sc.udpClosed = true
+		if sc.udpConn != nil {
+			sc.udpConn.Close()
+		}
+		sc.udpMu.Unlock()
+		if sc.fec != nil {
+			sc.fec.stop()
+			log.Printf("[客户端 %s] 前向纠错统计: %s", sc.clientAddr, sc.fec.Stats())
+		}
+		if sc.bond != nil {
+			sc.bond.close()
+			log.Printf("[客户端 %s] 多路径绑定统计: %s", sc.clientAddr, sc.bond.stats())
+		}
+		if sc.codec != nil {
+			log.Printf("[客户端 %s] 压缩统计: %s", sc.clientAddr, sc.codec)
+		}
+		sc.originLimit.close()
+		sc.limit.close()
+		if sc.originLimit.throttled() {
+			log.Printf("[客户端 %s] 原始客户端 %s 因限速丢弃 %d 个数据包", sc.clientAddr, sc.origin, sc.originLimit.droppedPackets.Load())
+		}
+		if sc.limit.throttled() {
+			log.Printf("[客户端 %s] 应答限速统计: %s", sc.clientAddr, sc.limit)
+		}
+		if sc.account != nil {
+			sc.account.close()
+			log.Printf("[客户端 %s] 流量统计: %s", sc.clientAddr, sc.account)
+		}
+		logRTT(sc.clientAddr, sc.rtt)
+		log.Printf("[客户端 %s] 连接已关闭", sc.clientAddr)
+	})
+}
@@ -0,0 +1,276 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ===============================
+// 会话头模块
+// ===============================
+
+// 会话头格式（客户端建立隧道连接后首先发送）：
+//   - 4 字节魔数 "UTS1"
+//   - 2 字节元数据长度（大端序，uint16）
+//   - N 字节元数据，使用 URL 查询串编码（例如 target=example.com%3A443）
+
+const (
+	// 会话头魔数
+	sessionMagic = "UTS1"
+	// 会话头读取超时时间
+	sessionHeaderTimeout = 10 * time.Second
+)
+
+// 会话元数据键
+const (
+	// 客户端请求的目标地址（host:port）
+	metaTarget = "target"
+	// 原始客户端地址（ip:port）
+	metaSource = "src"
+	// 前向纠错分片数（K,M），仅 UDP 隧道
+	metaFEC = "fec"
+	// 多路径绑定会话编号，同一编号的隧道连接属于同一会话，仅 UDP 隧道
+	metaBond = "bond"
+	// 多路径绑定模式（redundant 或 aggregate）
+	metaBondMode = "bond-mode"
+	// 压缩算法：客户端按优先顺序列出，服务端回复选中的算法
+	metaCompress = "compress"
+	// 控制帧：客户端请求隧道帧带类型字段（用于保活和往返时延测量），服务端回复 1 表示启用
+	metaControl = "ctrl"
+	// 多路复用：值为发送方每个流的接收窗口（字节），仅 TCP 隧道
+	metaMux = "mux"
+)
+
+// SessionMeta 会话元数据
+type SessionMeta struct {
+	values url.Values
+}
+
+// NewSessionMeta 创建空的会话元数据
+func NewSessionMeta() *SessionMeta {
+	return &SessionMeta{values: url.Values{}}
+}
+
+// Get 读取元数据字段
+func (m *SessionMeta) Get(key string) string {
+	return m.values.Get(key)
+}
+
+// Set 设置元数据字段，空值表示删除
+func (m *SessionMeta) Set(key, value string) {
+	if value == "" {
+		m.values.Del(key)
+		return
+	}
+	m.values.Set(key, value)
+}
+
+// WriteSessionHeader 写入会话头
+func WriteSessionHeader(w io.Writer, meta *SessionMeta) error {
+	encoded := meta.values.Encode()
+	if len(encoded) > 0xFFFF {
+		return fmt.Errorf("会话元数据过长: %d 字节", len(encoded))
+	}
+
+	buf := make([]byte, 0, len(sessionMagic)+packetLengthSize+len(encoded))
+	buf = append(buf, sessionMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(encoded)))
+	buf = append(buf, encoded...)
+
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("写入会话头失败: %w", err)
+	}
+	return nil
+}
+
+// ReadSessionHeader 读取会话头
+func ReadSessionHeader(r io.Reader) (*SessionMeta, error) {
+	head := make([]byte, len(sessionMagic)+packetLengthSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, fmt.Errorf("读取会话头失败: %w", err)
+	}
+	if string(head[:len(sessionMagic)]) != sessionMagic {
+		return nil, fmt.Errorf("无效的会话头魔数: %q", head[:len(sessionMagic)])
+	}
+
+	length := binary.BigEndian.Uint16(head[len(sessionMagic):])
+	encoded := make([]byte, length)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return nil, fmt.Errorf("读取会话元数据失败: %w", err)
+	}
+
+	values, err := url.ParseQuery(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("解析会话元数据失败: %w", err)
+	}
+	return &SessionMeta{values: values}, nil
+}
+
+// readSessionHeaderTimeout 在超时限制内从连接读取会话头
+func readSessionHeaderTimeout(conn net.Conn) (*SessionMeta, error) {
+	conn.SetReadDeadline(time.Now().Add(sessionHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	return ReadSessionHeader(conn)
+}
+
+// acceptSessionHeader 服务端读取会话头，连接开头不是会话头魔数时按旧版本客户端处理：
+// 返回的元数据为空，已读取的数据保留在返回的连接中。超时时间内没有收到任何数据时同样按旧版本客户端处理
+func acceptSessionHeader(conn net.Conn) (net.Conn, *SessionMeta, error) {
+	conn.SetReadDeadline(time.Now().Add(sessionHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// 逐字节比对魔数，旧版本客户端的数据可能不足 4 字节
+	reader := bufio.NewReader(conn)
+	for i := 1; i <= len(sessionMagic); i++ {
+		head, err := reader.Peek(i)
+		if string(head) != sessionMagic[:len(head)] {
+			return newBufferedConn(conn, reader), nil, nil
+		}
+		if err != nil {
+			var netErr net.Error
+			if len(head) == 0 && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				return nil, nil, fmt.Errorf("读取会话头失败: %w", err)
+			}
+			return newBufferedConn(conn, reader), nil, nil
+		}
+	}
+
+	meta, err := ReadSessionHeader(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newBufferedConn(conn, reader), meta, nil
+}
+
+// ===============================
+// 会话协商
+// ===============================
+
+// 客户端在会话头中提供可协商的功能（compress、ctrl、mux）时，服务端读取会话头后回复一个会话头，
+// 给出启用的功能；客户端没有提供任何可协商的功能时服务端不回复。
+// 旧版本客户端不发送会话头，服务端按没有会话头的会话处理（不协商任何功能）；
+// 新版本客户端总是发送会话头，旧版本服务端会把会话头当作数据转发，因此需要先升级服务端。
+
+// sessionParams 会话建立时协商的参数
+type sessionParams struct {
+	codec   *frameCodec // 帧压缩，未启用时为空
+	control bool        // 隧道帧带类型字段，可以收发控制帧
+	mux     uint32      // 启用多路复用时为对端每个流的接收窗口，未启用时为 0
+}
+
+// framed TCP 隧道会话是否需要按帧传输
+func (p sessionParams) framed() bool {
+	return p.codec != nil || p.control
+}
+
+// offerMux 客户端在会话头中请求多路复用，多路复用需要控制帧
+func offerMux(meta *SessionMeta, opts *Options) {
+	meta.Set(metaMux, strconv.FormatUint(uint64(opts.Mux.window()), 10))
+	meta.Set(metaControl, "1")
+}
+
+// offerSession 客户端在会话头中提供可协商的功能
+func offerSession(meta *SessionMeta, opts *Options) {
+	meta.Set(metaCompress, opts.Compression.offer())
+	if opts.Control.Enabled || opts.Keepalive.enabled() {
+		meta.Set(metaControl, "1")
+	}
+}
+
+// expectsReply 客户端是否提供了需要服务端回复的功能
+func expectsReply(meta *SessionMeta) bool {
+	return meta.Get(metaCompress) != "" || meta.Get(metaControl) != "" || meta.Get(metaMux) != ""
+}
+
+// acceptSession 服务端根据客户端会话头协商会话参数，需要时回复会话头
+func acceptSession(conn net.Conn, meta *SessionMeta, opts *Options) (sessionParams, error) {
+	if !expectsReply(meta) {
+		return sessionParams{}, nil
+	}
+
+	reply := NewSessionMeta()
+	params := sessionParams{codec: negotiateCompression(meta, reply, opts.Compression)}
+	if meta.Get(metaControl) != "" {
+		reply.Set(metaControl, "1")
+		params.control = true
+	}
+	if value := meta.Get(metaMux); value != "" && params.control {
+		window, err := parseMuxWindow(value)
+		if err != nil {
+			return sessionParams{}, err
+		}
+		reply.Set(metaMux, strconv.FormatUint(uint64(opts.Mux.window()), 10))
+		params.mux = window
+	}
+	if err := WriteSessionHeader(conn, reply); err != nil {
+		return sessionParams{}, err
+	}
+	return params, nil
+}
+
+// readSessionReply 客户端读取服务端的协商结果，没有提供可协商的功能时直接返回
+func readSessionReply(conn net.Conn, meta *SessionMeta, opts *Options) (sessionParams, error) {
+	if !expectsReply(meta) {
+		return sessionParams{}, nil
+	}
+
+	reply, err := readSessionHeaderTimeout(conn)
+	if err != nil {
+		return sessionParams{}, fmt.Errorf("读取会话协商结果失败: %w", err)
+	}
+	codec, err := compressionFromReply(reply, opts.Compression)
+	if err != nil {
+		return sessionParams{}, err
+	}
+	params := sessionParams{codec: codec, control: reply.Get(metaControl) != ""}
+	if meta.Get(metaMux) != "" {
+		value := reply.Get(metaMux)
+		if value == "" || !params.control {
+			return sessionParams{}, errors.New("服务端不支持多路复用")
+		}
+		if params.mux, err = parseMuxWindow(value); err != nil {
+			return sessionParams{}, err
+		}
+	}
+	return params, nil
+}
+
+// ===============================
+// 连接辅助类型
+// ===============================
+
+// closeWriter 支持半关闭写方向的连接
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// bufferedConn 带读缓冲的连接，用于在解析协议头之后继续读取已缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// newBufferedConn 创建带读缓冲的连接
+func newBufferedConn(conn net.Conn, reader *bufio.Reader) *bufferedConn {
+	return &bufferedConn{Conn: conn, reader: reader}
+}
+
+// Read 优先从缓冲区读取数据
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite 关闭底层连接的写方向
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// acceptFrom 在 net.Pipe 的服务端读取会话头，客户端写入 data 后关闭连接
+func acceptFrom(t *testing.T, data []byte) (*SessionMeta, []byte) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+
+	conn, meta, err := acceptSessionHeader(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta, rest
+}
+
+func TestAcceptSessionHeader(t *testing.T) {
+	var header bytes.Buffer
+	meta := NewSessionMeta()
+	meta.Set(metaTarget, "example.com:443")
+	if err := WriteSessionHeader(&header, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	got, rest := acceptFrom(t, append(header.Bytes(), "payload"...))
+	if got == nil || got.Get(metaTarget) != "example.com:443" {
+		t.Fatalf("会话头解析错误: %v", got)
+	}
+	if string(rest) != "payload" {
+		t.Fatalf("会话头之后的数据为 %q", rest)
+	}
+}
+
+func TestAcceptSessionHeaderLegacy(t *testing.T) {
+	// 旧版本客户端直接发送数据，包括与魔数前缀相同和不足 4 字节的数据
+	for _, data := range []string{"\x00\x05hello", "GET / HTTP/1.1\r\n\r\n", "UT", "UTS"} {
+		meta, rest := acceptFrom(t, []byte(data))
+		if meta != nil {
+			t.Fatalf("%q 不应解析出会话头", data)
+		}
+		if string(rest) != data {
+			t.Fatalf("旧版本客户端的数据 %q 被改为 %q", data, rest)
+		}
+	}
+}
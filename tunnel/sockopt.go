@@ -0,0 +1,242 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ===============================
+// 套接字选项模块
+// ===============================
+
+// SocketOptions 作用于隧道创建的每个 TCP 和 UDP 套接字：本地监听、隧道连接（TCP、TLS、UDP 传输）
+// 和目标连接。缓冲区、SO_MARK 和 DSCP 在绑定或连接之前设置，TCP_NODELAY 和保活探测参数在连接建立
+// （或接受）之后设置。Unix 套接字不受影响。
+//
+// 注入的 Options.Dialer、Options.Listener 和 Options.PacketConn 由调用方自行设置。
+type SocketOptions struct {
+	// KeepAlive TCP 保活：空闲多久后开始探测，0 表示 Go 默认的 15 秒，负数表示关闭保活
+	KeepAlive time.Duration
+	// KeepAliveInterval TCP 保活探测的间隔，0 表示与 KeepAlive 相同（仅 Linux）
+	KeepAliveInterval time.Duration
+	// KeepAliveCount 连续多少次探测无应答后断开，0 表示系统默认（仅 Linux）
+	KeepAliveCount int
+	// Nagle 启用 Nagle 算法（关闭 TCP_NODELAY），默认关闭 Nagle 以降低交互流量的延迟
+	Nagle bool
+	// SendBuffer SO_SNDBUF 字节数，0 表示系统默认
+	SendBuffer int
+	// ReceiveBuffer SO_RCVBUF 字节数，0 表示系统默认
+	ReceiveBuffer int
+	// Mark SO_MARK 防火墙标记，用于策略路由，0 表示不设置（仅 Linux，需要 CAP_NET_ADMIN）
+	Mark int
+	// DSCP 发出数据包的 DSCP 值（0~63），写入 IPv4 TOS 或 IPv6 Traffic Class 的高 6 位，0 表示不设置
+	DSCP int
+}
+
+// ParseDSCP 解析 DSCP 值：0~63 的数字或 EF、AF11~AF43、CS0~CS7 等名称
+func ParseDSCP(value string) (int, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	switch {
+	case value == "EF":
+		return 46, nil
+	case len(value) == 4 && strings.HasPrefix(value, "AF") && value[2] >= '1' && value[2] <= '4' && value[3] >= '1' && value[3] <= '3':
+		return int(value[2]-'0')*8 + int(value[3]-'0')*2, nil
+	case len(value) == 3 && strings.HasPrefix(value, "CS") && value[2] >= '0' && value[2] <= '7':
+		return int(value[2]-'0') * 8, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 63 {
+		return 0, fmt.Errorf("无效的 DSCP 值: %s（0~63 或 EF、AF11~AF43、CS0~CS7）", value)
+	}
+	return n, nil
+}
+
+// validate 检查选项的取值范围
+func (o SocketOptions) validate() error {
+	if o.DSCP < 0 || o.DSCP > 63 {
+		return fmt.Errorf("无效的 DSCP 值: %d（0~63）", o.DSCP)
+	}
+	if o.SendBuffer < 0 || o.ReceiveBuffer < 0 || o.KeepAliveCount < 0 || o.KeepAliveInterval < 0 {
+		return fmt.Errorf("套接字缓冲区大小和保活探测参数不能为负数")
+	}
+	return nil
+}
+
+// presocket 是否需要在绑定或连接之前设置选项
+func (o SocketOptions) presocket() bool {
+	return o.SendBuffer > 0 || o.ReceiveBuffer > 0 || o.Mark != 0 || o.DSCP != 0
+}
+
+// tunesConn 是否需要在连接建立后设置选项
+func (o SocketOptions) tunesConn() bool {
+	return o.Nagle || o.KeepAliveInterval > 0 || o.KeepAliveCount > 0
+}
+
+// netDialer 返回应用了套接字选项、带连接超时的 net.Dialer
+func (o SocketOptions) netDialer() *net.Dialer {
+	return &net.Dialer{Timeout: tcpConnTimeout, KeepAlive: o.KeepAlive, Control: o.control}
+}
+
+// listenConfig 返回应用了套接字选项的监听配置
+func (o SocketOptions) listenConfig() *net.ListenConfig {
+	return &net.ListenConfig{KeepAlive: o.KeepAlive, Control: o.control}
+}
+
+// control 在套接字绑定或连接之前设置选项，供 net.Dialer 和 net.ListenConfig 使用
+func (o SocketOptions) control(network, address string, rc syscall.RawConn) error {
+	if !o.presocket() || strings.HasPrefix(network, "unix") {
+		return nil
+	}
+	if err := o.validate(); err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = setSocketOptions(fd, network, o)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// tuneConn 在 TCP 连接建立或接受之后设置 TCP_NODELAY 和保活探测参数，其他连接不处理
+func (o SocketOptions) tuneConn(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || !o.tunesConn() {
+		return nil
+	}
+	if o.Nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			return fmt.Errorf("关闭 TCP_NODELAY 失败: %w", err)
+		}
+	}
+	if o.KeepAlive < 0 || (o.KeepAliveInterval <= 0 && o.KeepAliveCount <= 0) {
+		return nil
+	}
+	rc, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = setKeepAliveProbes(fd, o.KeepAliveInterval, o.KeepAliveCount)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// socketDialer 应用套接字选项、从绑定的源地址建立连接的拨号器
+type socketDialer struct {
+	dialer *net.Dialer
+	opts   SocketOptions
+	bind   BindOptions
+}
+
+// NewDialer 创建应用套接字选项和源地址绑定、带连接超时的拨号器
+func NewDialer(socket SocketOptions, bind BindOptions) Dialer {
+	return newSocketDialer(socket, bind)
+}
+
+// newSocketDialer 创建应用套接字选项和源地址绑定的拨号器
+func newSocketDialer(socket SocketOptions, bind BindOptions) *socketDialer {
+	dialer := socket.netDialer()
+	dialer.Control = func(network, address string, rc syscall.RawConn) error {
+		if err := socket.control(network, address, rc); err != nil {
+			return err
+		}
+		return bind.control(network, address, rc)
+	}
+	return &socketDialer{dialer: dialer, opts: socket, bind: bind}
+}
+
+// DialContext 建立连接，TCP 连接建立后再设置连接级选项
+func (d *socketDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.bind.dial(ctx, d.dialer, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.opts.tuneConn(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialUDP 创建连接到 remote 的 UDP 套接字
+func (d *socketDialer) dialUDP(remote *net.UDPAddr) (*net.UDPConn, error) {
+	conn, err := d.DialContext(context.Background(), "udp", remote.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// listen 使用套接字选项按地址族监听 TCP 地址，接受的连接自动设置连接级选项
+func (o SocketOptions) listen(network, address string, family AddressFamily) (net.Listener, error) {
+	network, address, err := listenAddress(network, address, family)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := o.listenConfig().Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDualStack(listener.Addr(), family); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if !o.tunesConn() {
+		return listener, nil
+	}
+	return &tunedListener{Listener: listener, opts: o}, nil
+}
+
+// listenPacket 使用套接字选项按地址族创建 UDP 套接字
+func (o SocketOptions) listenPacket(network, address string, family AddressFamily) (*net.UDPConn, error) {
+	network, address, err := listenAddress(network, address, family)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := o.listenConfig().ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDualStack(conn.LocalAddr(), family); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// tunedListener 接受连接后设置连接级选项的监听器
+type tunedListener struct {
+	net.Listener
+	opts SocketOptions
+}
+
+// Accept 接受连接并设置 TCP_NODELAY 和保活探测参数，设置失败时关闭该连接并继续接受
+func (l *tunedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if err := l.opts.tuneConn(conn); err != nil {
+			log.Printf("设置来自 %s 的连接的套接字选项失败: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
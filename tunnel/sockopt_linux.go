@@ -0,0 +1,73 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ===============================
+// 套接字选项 - Linux
+// ===============================
+
+// setSocketOptions 在绑定或连接之前设置缓冲区、SO_MARK 和 DSCP
+func setSocketOptions(fd uintptr, network string, o SocketOptions) error {
+	sock := int(fd)
+	if o.SendBuffer > 0 {
+		if err := syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer); err != nil {
+			return fmt.Errorf("设置 SO_SNDBUF 失败: %w", err)
+		}
+	}
+	if o.ReceiveBuffer > 0 {
+		if err := syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReceiveBuffer); err != nil {
+			return fmt.Errorf("设置 SO_RCVBUF 失败: %w", err)
+		}
+	}
+	if o.Mark != 0 {
+		if err := syscall.SetsockoptInt(sock, syscall.SOL_SOCKET, syscall.SO_MARK, o.Mark); err != nil {
+			return fmt.Errorf("设置 SO_MARK 失败（需要 CAP_NET_ADMIN）: %w", err)
+		}
+	}
+	if o.DSCP != 0 {
+		tos := o.DSCP << 2
+		if network == "tcp4" || network == "udp4" {
+			if err := syscall.SetsockoptInt(sock, syscall.IPPROTO_IP, syscall.IP_TOS, tos); err != nil {
+				return fmt.Errorf("设置 IP_TOS 失败: %w", err)
+			}
+		} else {
+			if err := syscall.SetsockoptInt(sock, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos); err != nil {
+				return fmt.Errorf("设置 IPV6_TCLASS 失败: %w", err)
+			}
+			// 双栈套接字上的 IPv4 流量使用 IP_TOS，纯 IPv6 套接字上设置失败可以忽略
+			syscall.SetsockoptInt(sock, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	}
+	return nil
+}
+
+// bindToDevice 通过 SO_BINDTODEVICE 把套接字绑定到网络接口
+func bindToDevice(fd uintptr, device string) error {
+	if err := syscall.BindToDevice(int(fd), device); err != nil {
+		return fmt.Errorf("绑定网络接口 %s 失败: %w", device, err)
+	}
+	return nil
+}
+
+// setKeepAliveProbes 设置 TCP 保活探测的间隔和次数，0 表示不修改
+func setKeepAliveProbes(fd uintptr, interval time.Duration, count int) error {
+	sock := int(fd)
+	if interval > 0 {
+		secs := max(int(interval/time.Second), 1)
+		if err := syscall.SetsockoptInt(sock, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); err != nil {
+			return fmt.Errorf("设置 TCP_KEEPINTVL 失败: %w", err)
+		}
+	}
+	if count > 0 {
+		if err := syscall.SetsockoptInt(sock, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count); err != nil {
+			return fmt.Errorf("设置 TCP_KEEPCNT 失败: %w", err)
+		}
+	}
+	return nil
+}
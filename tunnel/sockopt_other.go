@@ -0,0 +1,31 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ===============================
+// 套接字选项 - 其他平台
+// ===============================
+
+// errSocketOptionUnsupported 当前平台不支持的套接字选项
+var errSocketOptionUnsupported = errors.New("当前平台仅支持 TCP 保活时间和 TCP_NODELAY 选项")
+
+// setSocketOptions 非 Linux 平台不支持在连接之前设置缓冲区、SO_MARK 和 DSCP
+func setSocketOptions(fd uintptr, network string, o SocketOptions) error {
+	return errSocketOptionUnsupported
+}
+
+// bindToDevice 非 Linux 平台不支持绑定网络接口
+func bindToDevice(fd uintptr, device string) error {
+	return fmt.Errorf("当前平台不支持绑定网络接口 %s", device)
+}
+
+// setKeepAliveProbes 非 Linux 平台不支持设置保活探测的间隔和次数
+func setKeepAliveProbes(fd uintptr, interval time.Duration, count int) error {
+	return errSocketOptionUnsupported
+}
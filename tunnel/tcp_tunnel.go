@@ -0,0 +1,863 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// ===============================
+// TCP 隧道模块
+// ===============================
+
+// TCPTunnelClient TCP隧道客户端
+type TCPTunnelClient struct {
+	opts        Options
+	localTCP    string
+	remote      serverAddress // 服务端地址，收到带备用地址的 GOAWAY 后切换
+	listener    net.Listener
+	connections map[string]*TCPClientConnection
+	mu          sync.RWMutex
+	tunnelLimit *rateLimiter   // 整个隧道的上传限速
+	sourceLimit *sourceLimiter // 按本地客户端 IP 的上传限速
+	connLimit   *connLimiter
+	rtt         *rttRecorder // 全部隧道连接的往返时延
+	sessions    *sessionRegistry
+	mux         *muxPool  // 启用多路复用时共享的隧道连接
+	pool        *connPool // 预建的空闲隧道连接，未启用时为空
+	lifecycle
+}
+
+// NewTCPTunnelClient 创建新的TCP隧道客户端
+func NewTCPTunnelClient(opts Options) *TCPTunnelClient {
+	c := &TCPTunnelClient{
+		opts:        opts,
+		localTCP:    opts.LocalAddr,
+		remote:      serverAddress{addr: opts.RemoteAddr},
+		connections: make(map[string]*TCPClientConnection),
+		tunnelLimit: newRateLimiter(opts.RateLimits.Tunnel),
+		sourceLimit: newSourceLimiter(opts.RateLimits.SourceIP),
+		connLimit:   newConnLimiter(opts.ConnLimits),
+		rtt:         newRTTRecorder(nil),
+		sessions:    newSessionRegistry(opts.Hooks),
+		lifecycle:   newLifecycle(),
+	}
+	c.mux = &muxPool{dial: c.dialMux}
+	c.pool = newConnPool(opts.Pool, &c.remote, c.opts.dialTunnel)
+	return c
+}
+
+// Serve 启动TCP客户端并接受本地连接，直到 ctx 取消或调用 Close
+func (c *TCPTunnelClient) Serve(ctx context.Context) error {
+	log.Printf("启动TCP客户端模式 - 本地 TCP: %s, 远程 TCP: %s", c.localTCP, c.remote.get())
+	if c.opts.HTTPProxy {
+		log.Printf("已启用 HTTP 代理前端")
+	}
+	if c.opts.Mux.Enabled {
+		log.Printf("已启用多路复用，每条隧道连接最多 %d 个流", c.opts.Mux.maxStreams())
+	}
+
+	var listener net.Listener
+	var err error
+	if c.opts.TProxy && c.opts.Listener == nil {
+		listener, err = listenTransparentTCP(c.localTCP, c.opts.Socket)
+	} else {
+		listener, err = c.opts.listenLocal()
+	}
+	if err != nil {
+		return fmt.Errorf("监听本地 TCP 失败: %w", err)
+	}
+
+	c.mu.Lock()
+	c.listener = listener
+	c.mu.Unlock()
+	defer c.Close()
+
+	if c.closed() {
+		return nil
+	}
+	c.watch(ctx, c.Close)
+	if c.pool != nil {
+		go c.pool.run(c.ctx)
+	}
+	go c.opts.Resolver.watch(c.ctx, c.remote.get, c.opts.Family.Remote, c.serverChanged)
+
+	log.Printf("TCP 隧道客户端已启动，监听地址: %s", listener.Addr())
+
+	return c.acceptConnections()
+}
+
+// Close 停止接受本地连接并关闭所有会话
+func (c *TCPTunnelClient) Close() error {
+	c.shutdown(func() {
+		c.mu.RLock()
+		listener := c.listener
+		c.mu.RUnlock()
+		if listener != nil {
+			listener.Close()
+		}
+		c.sessions.closeAll()
+		c.mux.closeAll(ReasonShutdown, "客户端正在关闭")
+	})
+	return nil
+}
+
+// Sessions 返回当前活动会话
+func (c *TCPTunnelClient) Sessions() []SessionInfo {
+	return c.sessions.list()
+}
+
+// RTT 返回全部隧道连接的往返时延统计，需要启用保活
+func (c *TCPTunnelClient) RTT() RTTStats {
+	return c.rtt.snapshot()
+}
+
+// CloseCounts 返回按原因码统计的带关闭原因的会话数（控制帧通知的关闭和超时关闭）
+func (c *TCPTunnelClient) CloseCounts() map[ReasonCode]uint64 {
+	return c.sessions.closeCounts()
+}
+
+// acceptConnections 接受客户端连接
+func (c *TCPTunnelClient) acceptConnections() error {
+	aclName := "本地 TCP 监听 " + c.listener.Addr().String()
+	var backoff acceptBackoff
+	for {
+		localConn, err := c.listener.Accept()
+		if err != nil {
+			if c.closed() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("接受本地连接失败: %v，%s 后重试", err, backoff.next())
+			backoff.wait(c.done)
+			continue
+		}
+		backoff.reset()
+
+		if !c.opts.ACL.check(localConn.RemoteAddr(), aclName) {
+			localConn.Close()
+			continue
+		}
+
+		// 超过并发数时按配置立即拒绝，或暂停接受新连接等待名额
+		release, err := c.connLimit.acquire(c.ctx, localConn.RemoteAddr(), true)
+		if err != nil {
+			if !c.closed() {
+				log.Printf("拒绝来自 %s 的本地连接: %v", localConn.RemoteAddr().String(), err)
+			}
+			localConn.Close()
+			continue
+		}
+
+		log.Printf("接受来自 %s 的本地连接", localConn.RemoteAddr().String())
+
+		// 为每个连接启动处理协程
+		go func() {
+			defer release()
+			c.handleLocalConnection(localConn)
+		}()
+	}
+}
+
+// handleLocalConnection 处理本地连接
+func (c *TCPTunnelClient) handleLocalConnection(localConn net.Conn) {
+	defer localConn.Close()
+
+	// 位于负载均衡之后时，从 PROXY 协议头恢复原始客户端地址
+	if c.opts.AcceptProxyProtocol {
+		proxied, err := acceptProxyHeader(localConn)
+		if err != nil {
+			log.Printf("[客户端 %s] %v", localConn.RemoteAddr().String(), err)
+			return
+		}
+		localConn = proxied
+	}
+
+	clientKey := peerName(localConn)
+	meta := NewSessionMeta()
+	if _, ok := localConn.RemoteAddr().(*net.TCPAddr); ok {
+		meta.Set(metaSource, clientKey)
+	}
+
+	// HTTP 代理模式下先解析代理请求，得到目标地址
+	var proxyReq *httpProxyRequest
+	if c.opts.HTTPProxy {
+		var err error
+		proxyReq, err = readHTTPProxyRequest(localConn)
+		if err != nil {
+			log.Printf("[客户端 %s] %v", clientKey, err)
+			return
+		}
+		localConn = proxyReq.conn
+		meta.Set(metaTarget, proxyReq.target)
+		log.Printf("[客户端 %s] HTTP 代理请求目标: %s", clientKey, proxyReq.target)
+	}
+
+	// 透明代理模式下被拦截连接的本地地址就是原始目标地址
+	if c.opts.TProxy {
+		meta.Set(metaTarget, localConn.LocalAddr().String())
+		log.Printf("[客户端 %s] 透明代理原始目标: %s", clientKey, localConn.LocalAddr().String())
+	}
+
+	// 连接到远程服务端，启用多路复用时在共享的隧道连接上打开流
+	var remoteConn net.Conn
+	var err error
+	if c.opts.Mux.Enabled {
+		remoteConn, err = c.mux.open(meta)
+	} else {
+		remoteConn, err = c.dialSession(clientKey, meta)
+	}
+	if err != nil {
+		log.Printf("[客户端 %s] 连接到远程服务端失败: %v", clientKey, err)
+		if proxyReq != nil {
+			proxyReq.fail()
+		}
+		return
+	}
+	defer remoteConn.Close()
+
+	var rtt *rttRecorder
+	switch conn := remoteConn.(type) {
+	case *framedConn:
+		rtt = conn.packets.rtt
+		if rtt != nil {
+			defer logRTT(clientKey, rtt)
+		}
+		if conn.codec != nil {
+			defer log.Printf("[客户端 %s] 压缩统计: %s", clientKey, conn.codec)
+		}
+	case *muxStream:
+		// 往返时延和压缩统计属于整条隧道连接，在隧道连接关闭时输出
+		rtt = conn.session.packets.rtt
+	}
+
+	if proxyReq != nil {
+		if err := proxyReq.start(remoteConn); err != nil {
+			log.Printf("[客户端 %s] %v", clientKey, err)
+			return
+		}
+	}
+
+	log.Printf("为客户端 %s 建立了到远程服务端 %s 的连接", clientKey, remoteConn.RemoteAddr())
+
+	// 创建连接管理对象
+	tcpConn := &TCPClientConnection{
+		localConn:  localConn,
+		remoteConn: remoteConn,
+		clientKey:  clientKey,
+		client:     c,
+		limit:      newTrafficLimiter(c.tunnelLimit, c.sourceLimit.get(addrPortOf(localConn.RemoteAddr()).Addr()), newRateLimiter(c.opts.RateLimits.Session)),
+	}
+
+	// 注册连接
+	c.registerConnection(clientKey, tcpConn)
+	defer c.removeConnection(clientKey)
+
+	tcpConn.sessionID = c.sessions.open(SessionInfo{
+		Protocol:   "tcp",
+		ClientAddr: clientKey,
+		Target:     meta.Get(metaTarget),
+		rtt:        rtt,
+	}, func() {
+		notifyClose(tcpConn.remoteConn, ReasonShutdown, "客户端正在关闭")
+		tcpConn.Close()
+	})
+	defer c.sessions.close(tcpConn.sessionID)
+
+	// 启动双向数据转发
+	tcpConn.startForwarding()
+}
+
+// dialRemote 建立到服务端的隧道连接，启用连接池时优先取用预建的连接
+func (c *TCPTunnelClient) dialRemote() (net.Conn, error) {
+	if conn := c.pool.get(); conn != nil {
+		return conn, nil
+	}
+	return c.opts.dialTunnel(c.ctx, c.remote.get())
+}
+
+// handleGoaway 之后的新会话连接备用地址，丢弃连接到原服务端的空闲连接
+func (c *TCPTunnelClient) handleGoaway(alternate string) {
+	c.remote.redirect(alternate)
+	c.pool.flush()
+}
+
+// serverChanged 服务端主机名的解析结果变化后，丢弃空闲连接，对端地址不在新结果中的多路复用连接不再打开新的流；
+// 已建立的会话无法迁移，之后的新会话连接新地址
+func (c *TCPTunnelClient) serverChanged(host string, addrs []netip.Addr) {
+	c.pool.flush()
+	if n := c.mux.retire(addrs); n > 0 {
+		log.Printf("服务端 %s 的地址已变化，%d 条多路复用隧道连接不再打开新的流", host, n)
+	}
+}
+
+// dialSession 为一个本地连接建立独立的隧道连接并协商会话，启用压缩或控制帧时返回分帧连接
+func (c *TCPTunnelClient) dialSession(clientKey string, meta *SessionMeta) (net.Conn, error) {
+	remoteConn, err := c.dialRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	// 发送会话头
+	offerSession(meta, &c.opts)
+	if err := WriteSessionHeader(remoteConn, meta); err != nil {
+		remoteConn.Close()
+		return nil, err
+	}
+
+	params, err := readSessionReply(remoteConn, meta, &c.opts)
+	if err != nil {
+		remoteConn.Close()
+		return nil, err
+	}
+	if !params.framed() {
+		return remoteConn, nil
+	}
+
+	var rtt *rttRecorder
+	if params.control {
+		rtt = newRTTRecorder(c.rtt)
+	}
+	handler := newSessionHandler(remoteConn, params, rtt, &c.opts)
+	// 已建立的 TCP 会话无法迁移，只让之后的新会话连接备用地址
+	handler.onGoaway = c.handleGoaway
+	return newFramedConn(remoteConn, handler, params.codec), nil
+}
+
+// dialMux 建立一条多路复用的隧道连接，连接关闭前一直接收各个流的帧
+func (c *TCPTunnelClient) dialMux() (*muxSession, error) {
+	remoteConn, err := c.dialRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := NewSessionMeta()
+	offerSession(meta, &c.opts)
+	offerMux(meta, &c.opts)
+	if err := WriteSessionHeader(remoteConn, meta); err != nil {
+		remoteConn.Close()
+		return nil, err
+	}
+	params, err := readSessionReply(remoteConn, meta, &c.opts)
+	if err != nil {
+		remoteConn.Close()
+		return nil, err
+	}
+
+	name := peerName(remoteConn)
+	rtt := newRTTRecorder(c.rtt)
+	handler := newSessionHandler(remoteConn, params, rtt, &c.opts)
+	m := newMuxSession(remoteConn, handler, params, c.opts.Mux)
+	// 收到 GOAWAY 后已打开的流继续传输，新的流改为建立新的隧道连接
+	handler.onGoaway = func(alternate string) {
+		m.markGoaway()
+		c.handleGoaway(alternate)
+	}
+
+	go func() {
+		m.serve()
+		log.Printf("[隧道连接 %s] 多路复用连接已关闭: %v", name, m.closeErr())
+		logRTT(name, rtt)
+		if params.codec != nil {
+			log.Printf("[隧道连接 %s] 压缩统计: %s", name, params.codec)
+		}
+	}()
+	return m, nil
+}
+
+// registerConnection 注册连接
+func (c *TCPTunnelClient) registerConnection(clientKey string, conn *TCPClientConnection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connections[clientKey] = conn
+}
+
+// removeConnection 移除连接
+func (c *TCPTunnelClient) removeConnection(clientKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.connections, clientKey)
+}
+
+// TCPClientConnection TCP客户端连接管理
+type TCPClientConnection struct {
+	localConn  net.Conn
+	remoteConn net.Conn
+	clientKey  string
+	client     *TCPTunnelClient
+	limit      *trafficLimiter // 上传限速，可为空
+	sessionID  uint64
+	timer      *sessionTimer // 会话超时，未设置时为空
+}
+
+// Close 关闭本地和远程连接
+func (c *TCPClientConnection) Close() {
+	c.localConn.Close()
+	c.remoteConn.Close()
+}
+
+// closeWithReason 记录关闭原因并通知服务端后关闭连接
+func (c *TCPClientConnection) closeWithReason(code ReasonCode, message string) {
+	c.client.sessions.setCloseReason(c.sessionID, &CloseError{Code: code, Message: message})
+	notifyClose(c.remoteConn, code, message)
+	c.Close()
+}
+
+// expire 会话超时时关闭会话
+func (c *TCPClientConnection) expire(code ReasonCode, message string) {
+	log.Printf("[客户端 %s] %s，关闭会话", c.clientKey, (&CloseError{Code: code, Message: message}).Reason())
+	c.closeWithReason(code, message)
+}
+
+// startForwarding 启动双向转发
+func (c *TCPClientConnection) startForwarding() {
+	c.timer = startSessionTimer(c.client.opts.Timeouts, c.expire)
+	defer c.timer.stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// 本地到远程的转发
+	go func() {
+		defer wg.Done()
+		c.forwardData(c.localConn, c.remoteConn, "local->remote", c.limit)
+	}()
+
+	// 远程到本地的转发
+	go func() {
+		defer wg.Done()
+		c.forwardData(c.remoteConn, c.localConn, "remote->local", nil)
+	}()
+
+	wg.Wait()
+	c.limit.close()
+	if c.limit.throttled() {
+		log.Printf("[客户端 %s] 限速统计: %s", c.clientKey, c.limit)
+	}
+	log.Printf("[客户端 %s] 连接已关闭", c.clientKey)
+}
+
+// forwardData 转发数据，limit 非空时按限速整形写入
+func (c *TCPClientConnection) forwardData(src, dst net.Conn, direction string, limit *trafficLimiter) {
+	defer func() {
+		// 关闭目标连接的写入，触发对方读取结束
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+	}()
+
+	defer c.timer.halfClose()
+
+	written, err := io.Copy(c.timer.writer(limit.writer(c.client.ctx, dst)), src)
+	if closeErr := peerClose(err); closeErr != nil {
+		// 服务端关闭会话时同时结束另一个方向
+		log.Printf("[客户端 %s] 服务端关闭会话: %s", c.clientKey, closeErr.Reason())
+		c.client.sessions.setCloseReason(c.sessionID, closeErr)
+		c.Close()
+		return
+	}
+	if err != nil {
+		log.Printf("[客户端 %s] %s 数据转发出错: %v", c.clientKey, direction, err)
+	} else {
+		log.Printf("[客户端 %s] %s 数据转发完成，传输 %d 字节", c.clientKey, direction, written)
+	}
+}
+
+// ===============================
+// TCP 隧道服务端
+// ===============================
+
+// TCPTunnelServer TCP隧道服务端
+type TCPTunnelServer struct {
+	opts        Options
+	listenTCP   string
+	targetTCP   string
+	listener    net.Listener
+	mu          sync.Mutex
+	tunnelLimit *rateLimiter   // 整个隧道的应答限速
+	sourceLimit *sourceLimiter // 按原始客户端 IP 的应答限速
+	connLimit   *connLimiter
+	rtt         *rttRecorder // 全部隧道连接的往返时延
+	sessions    *sessionRegistry
+	mux         *muxPool // 多路复用的隧道连接
+	lifecycle
+}
+
+// NewTCPTunnelServer 创建新的TCP隧道服务端
+func NewTCPTunnelServer(opts Options) *TCPTunnelServer {
+	return &TCPTunnelServer{
+		opts:        opts,
+		listenTCP:   opts.LocalAddr,
+		targetTCP:   opts.RemoteAddr,
+		tunnelLimit: newRateLimiter(opts.RateLimits.Tunnel),
+		sourceLimit: newSourceLimiter(opts.RateLimits.SourceIP),
+		connLimit:   newConnLimiter(opts.ConnLimits),
+		rtt:         newRTTRecorder(nil),
+		sessions:    newSessionRegistry(opts.Hooks),
+		mux:         &muxPool{},
+		lifecycle:   newLifecycle(),
+	}
+}
+
+// Serve 启动TCP服务端并接受连接，直到 ctx 取消或调用 Close
+func (s *TCPTunnelServer) Serve(ctx context.Context) error {
+	log.Printf("启动TCP服务端模式 - 监听 TCP: %s, 目标 TCP: %s", s.listenTCP, s.targetTCP)
+
+	listener, err := s.opts.listenTunnel()
+	if err != nil {
+		return fmt.Errorf("监听 TCP 失败: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	defer s.Close()
+
+	if s.closed() {
+		return nil
+	}
+	s.watch(ctx, s.Close)
+
+	log.Printf("TCP 隧道服务端已启动，监听地址: %s", listener.Addr())
+
+	return s.acceptConnections()
+}
+
+// Close 停止接受连接，配置了 Drain 时向客户端发送 GOAWAY 并等待会话结束，然后关闭所有会话
+func (s *TCPTunnelServer) Close() error {
+	s.shutdown(func() {
+		s.mu.Lock()
+		listener := s.listener
+		s.mu.Unlock()
+		if listener != nil {
+			listener.Close()
+		}
+		s.sessions.drain(s.opts.Drain)
+		s.sessions.closeAll()
+		s.mux.closeAll(ReasonShutdown, "服务端正在关闭")
+	})
+	return nil
+}
+
+// Sessions 返回当前活动会话
+func (s *TCPTunnelServer) Sessions() []SessionInfo {
+	return s.sessions.list()
+}
+
+// RTT 返回全部隧道连接的往返时延统计，需要客户端启用保活
+func (s *TCPTunnelServer) RTT() RTTStats {
+	return s.rtt.snapshot()
+}
+
+// CloseCounts 返回按原因码统计的带关闭原因的会话数（控制帧通知的关闭和超时关闭）
+func (s *TCPTunnelServer) CloseCounts() map[ReasonCode]uint64 {
+	return s.sessions.closeCounts()
+}
+
+// acceptConnections 接受客户端连接
+func (s *TCPTunnelServer) acceptConnections() error {
+	aclName := "隧道监听 " + s.listener.Addr().String()
+	var backoff acceptBackoff
+	for {
+		clientConn, err := s.listener.Accept()
+		if err != nil {
+			if s.closed() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("接受连接失败: %v，%s 后重试", err, backoff.next())
+			backoff.wait(s.done)
+			continue
+		}
+		backoff.reset()
+
+		if !s.opts.ACL.check(clientConn.RemoteAddr(), aclName) {
+			clientConn.Close()
+			continue
+		}
+
+		// 超过并发数时按配置立即拒绝，或暂停接受新连接等待名额
+		release, err := s.connLimit.acquire(s.ctx, clientConn.RemoteAddr(), true)
+		if err != nil {
+			if !s.closed() {
+				log.Printf("拒绝来自 %s 的连接: %v", clientConn.RemoteAddr().String(), err)
+			}
+			clientConn.Close()
+			continue
+		}
+
+		log.Printf("接受来自 %s 的连接", clientConn.RemoteAddr().String())
+
+		// 为每个连接启动处理协程
+		go func() {
+			defer release()
+			s.handleClientConnection(clientConn)
+		}()
+	}
+}
+
+// handleClientConnection 处理客户端连接
+func (s *TCPTunnelServer) handleClientConnection(clientConn net.Conn) {
+	defer clientConn.Close()
+	rawConn := clientConn
+
+	if s.opts.AcceptProxyProtocol {
+		proxied, err := acceptProxyHeader(clientConn)
+		if err != nil {
+			log.Printf("[客户端 %s] %v", clientConn.RemoteAddr().String(), err)
+			return
+		}
+		clientConn = proxied
+	}
+
+	clientAddr := peerName(clientConn)
+
+	// 读取会话头，旧版本客户端没有会话头
+	clientConn, meta, err := acceptSessionHeader(clientConn)
+	if err != nil {
+		log.Printf("[客户端 %s] %v", clientAddr, err)
+		return
+	}
+	if meta == nil {
+		log.Printf("[客户端 %s] 未收到会话头，按旧版本客户端处理（不协商任何功能）", clientAddr)
+		meta = NewSessionMeta()
+	}
+
+	params, err := acceptSession(clientConn, meta, &s.opts)
+	if err != nil {
+		log.Printf("[客户端 %s] %v", clientAddr, err)
+		return
+	}
+	var rtt *rttRecorder
+	if params.control {
+		rtt = newRTTRecorder(s.rtt)
+		defer logRTT(clientAddr, rtt)
+	}
+	if params.codec != nil {
+		defer log.Printf("[客户端 %s] 压缩统计: %s", clientAddr, params.codec)
+	}
+
+	user := peerIdentity(rawConn)
+	if params.mux != 0 {
+		s.serveMux(clientConn, newSessionHandler(clientConn, params, rtt, &s.opts), params, user)
+		return
+	}
+	var goaway func(alternate string)
+	if params.framed() {
+		handler := newSessionHandler(clientConn, params, rtt, &s.opts)
+		clientConn = newFramedConn(clientConn, handler, params.codec)
+		if params.control {
+			goaway = handler.sendGoaway
+		}
+	}
+	s.serveSession(clientConn, clientAddr, meta, user, rtt, goaway)
+}
+
+// serveMux 在多路复用的隧道连接上为客户端打开的每个流建立到目标的会话，隧道连接关闭后返回
+func (s *TCPTunnelServer) serveMux(clientConn net.Conn, handler *TCPPacketHandler, params sessionParams, user string) {
+	clientAddr := peerName(clientConn)
+	m := newMuxSession(clientConn, handler, params, s.opts.Mux)
+	m.onOpen = func(stream *muxStream) {
+		defer stream.Close()
+		s.serveSession(stream, fmt.Sprintf("%s#%d", clientAddr, stream.id), stream.meta, user, handler.rtt, m.sendGoaway)
+	}
+
+	// 服务端关闭时通知客户端后关闭隧道连接
+	s.mux.add(m)
+	defer s.mux.remove(m)
+	if s.closed() {
+		m.closeWithReason(ReasonShutdown, "服务端正在关闭")
+	}
+
+	log.Printf("[客户端 %s] 已启用多路复用，对端每个流的接收窗口 %d 字节", clientAddr, params.mux)
+	m.serve()
+	log.Printf("[客户端 %s] 多路复用连接已关闭: %v", clientAddr, m.closeErr())
+}
+
+// serveSession 按会话头连接目标并在 clientConn 和目标之间转发数据，clientConn 为隧道连接或多路复用的流；
+// goaway 非空时登记为会话的 GOAWAY 通知函数
+func (s *TCPTunnelServer) serveSession(clientConn net.Conn, clientAddr string, meta *SessionMeta, user string, rtt *rttRecorder, goaway func(alternate string)) {
+	targetTCP, err := s.resolveTarget(meta)
+	if err != nil {
+		log.Printf("[客户端 %s] %v", clientAddr, err)
+		notifyClose(clientConn, ReasonRejected, err.Error())
+		return
+	}
+
+	account, err := s.opts.Accounting.open(user)
+	if err != nil {
+		log.Printf("[客户端 %s] 拒绝用户 %s 的会话: %v", clientAddr, user, err)
+		notifyClose(clientConn, ReasonQuotaExceeded, err.Error())
+		return
+	}
+	if account != nil {
+		defer func() {
+			account.close()
+			log.Printf("[客户端 %s] 流量统计: %s", clientAddr, account)
+		}()
+	}
+
+	// 连接到目标TCP服务
+	targetConn, err := s.opts.dialTCP(s.ctx, targetTCP)
+	if err != nil {
+		log.Printf("[客户端 %s] 连接到目标TCP服务失败: %v", clientAddr, err)
+		notifyClose(clientConn, ReasonTargetUnreachable, err.Error())
+		return
+	}
+	defer targetConn.Close()
+
+	// 向目标发送 PROXY 协议头，携带隧道另一端的原始客户端地址
+	if s.opts.ProxyProtocol != ProxyProtocolNone {
+		src := clientSourceAddr(meta, clientConn)
+		dst := addrPortOf(targetConn.RemoteAddr())
+		if err := writeProxyHeader(targetConn, s.opts.ProxyProtocol, src, dst); err != nil {
+			log.Printf("[客户端 %s] %v", clientAddr, err)
+			return
+		}
+	}
+
+	log.Printf("[客户端 %s] 连接已建立，目标: %s，原始客户端: %s", clientAddr, targetTCP, meta.Get(metaSource))
+
+	// 创建服务端连接管理对象
+	serverConn := &TCPServerConnection{
+		clientConn: clientConn,
+		targetConn: targetConn,
+		clientAddr: clientAddr,
+		targetTCP:  targetTCP,
+		server:     s,
+		account:    account,
+		limit:      newTrafficLimiter(s.tunnelLimit, s.sourceLimit.get(clientSourceAddr(meta, clientConn).Addr()), newRateLimiter(s.opts.RateLimits.Session)),
+	}
+
+	serverConn.sessionID = s.sessions.open(SessionInfo{
+		Protocol:   "tcp",
+		ClientAddr: clientAddr,
+		Origin:     meta.Get(metaSource),
+		Target:     targetTCP,
+		User:       user,
+		rtt:        rtt,
+	}, func() {
+		serverConn.closeWithReason(ReasonShutdown, "服务端正在关闭")
+	})
+	defer s.sessions.close(serverConn.sessionID)
+	if goaway != nil {
+		s.sessions.setGoaway(serverConn.sessionID, goaway)
+	}
+
+	// 启动双向数据转发
+	serverConn.startForwarding()
+}
+
+// resolveTarget 根据会话头确定本次连接的目标地址
+func (s *TCPTunnelServer) resolveTarget(meta *SessionMeta) (string, error) {
+	target := meta.Get(metaTarget)
+	if target == "" {
+		return s.targetTCP, nil
+	}
+	if !s.opts.DynamicTarget {
+		return "", fmt.Errorf("拒绝客户端指定的目标 %s（未启用动态目标）", target)
+	}
+	return target, nil
+}
+
+// clientSourceAddr 返回会话头中的原始客户端地址，缺失时使用隧道连接的对端地址
+func clientSourceAddr(meta *SessionMeta, conn net.Conn) netip.AddrPort {
+	if src, err := netip.ParseAddrPort(meta.Get(metaSource)); err == nil {
+		return netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	}
+	return addrPortOf(conn.RemoteAddr())
+}
+
+// TCPServerConnection TCP服务端连接管理
+type TCPServerConnection struct {
+	clientConn net.Conn
+	targetConn net.Conn
+	clientAddr string
+	targetTCP  string
+	server     *TCPTunnelServer
+	limit      *trafficLimiter // 应答限速，可为空
+	account    *quotaSession   // 用户流量计费，未认证时为空
+	sessionID  uint64
+	timer      *sessionTimer // 会话超时，未设置时为空
+}
+
+// Close 关闭客户端和目标连接
+func (s *TCPServerConnection) Close() {
+	s.clientConn.Close()
+	s.targetConn.Close()
+}
+
+// closeWithReason 通知客户端关闭原因后关闭连接
+func (s *TCPServerConnection) closeWithReason(code ReasonCode, message string) {
+	s.server.sessions.setCloseReason(s.sessionID, &CloseError{Code: code, Message: message})
+	notifyClose(s.clientConn, code, message)
+	s.Close()
+}
+
+// expire 会话超时时关闭会话
+func (s *TCPServerConnection) expire(code ReasonCode, message string) {
+	log.Printf("[客户端 %s] %s，关闭会话", s.clientAddr, (&CloseError{Code: code, Message: message}).Reason())
+	s.closeWithReason(code, message)
+}
+
+// startForwarding 启动双向转发
+func (s *TCPServerConnection) startForwarding() {
+	s.timer = startSessionTimer(s.server.opts.Timeouts, s.expire)
+	defer s.timer.stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// 客户端到目标的转发
+	go func() {
+		defer wg.Done()
+		s.forwardData(s.clientConn, s.targetConn, "client->target", nil)
+	}()
+
+	// 目标到客户端的转发
+	go func() {
+		defer wg.Done()
+		s.forwardData(s.targetConn, s.clientConn, "target->client", s.limit)
+	}()
+
+	wg.Wait()
+	s.limit.close()
+	if s.limit.throttled() {
+		log.Printf("[客户端 %s] 限速统计: %s", s.clientAddr, s.limit)
+	}
+	log.Printf("[客户端 %s] 连接已关闭", s.clientAddr)
+}
+
+// forwardData 转发数据，limit 非空时按限速整形写入，两个方向都计入用户流量
+func (s *TCPServerConnection) forwardData(src, dst net.Conn, direction string, limit *trafficLimiter) {
+	defer func() {
+		// 关闭目标连接的写入，触发对方读取结束
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+	}()
+
+	defer s.timer.halfClose()
+
+	written, err := io.Copy(s.timer.writer(s.account.writer(limit.writer(s.server.ctx, dst))), src)
+	if errors.Is(err, errQuotaExceeded) {
+		// 配额用完时同时结束另一个方向
+		log.Printf("[客户端 %s] %v，关闭会话", s.clientAddr, err)
+		s.closeWithReason(ReasonQuotaExceeded, err.Error())
+		return
+	}
+	if closeErr := peerClose(err); closeErr != nil {
+		log.Printf("[客户端 %s] 客户端关闭会话: %s", s.clientAddr, closeErr.Reason())
+		s.server.sessions.setCloseReason(s.sessionID, closeErr)
+		s.Close()
+		return
+	}
+	if err != nil {
+		log.Printf("[客户端 %s] %s 数据转发出错: %v", s.clientAddr, direction, err)
+	} else {
+		log.Printf("[客户端 %s] %s 数据转发完成，传输 %d 字节", s.clientAddr, direction, written)
+	}
+}
@@ -0,0 +1,152 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// 会话超时模块
+// ===============================
+
+// TCP 隧道会话的转发没有读写超时，对端消失而连接没有断开时会话会一直保留。
+// TimeoutOptions 为每个会话设置三种超时，触发时以各自的原因码关闭会话（启用控制帧时通知对端）：
+//   - 空闲超时：两个方向都没有数据
+//   - 半关闭超时：一个方向已经结束（对端半关闭），另一个方向仍未结束
+//   - 最长存活时间：从会话建立开始计算，不论是否有数据
+
+const (
+	// 检查超时的最短间隔
+	timeoutCheckMin = 10 * time.Millisecond
+	// 检查超时的最长间隔
+	timeoutCheckMax = 1 * time.Second
+)
+
+// TimeoutOptions TCP 隧道会话的超时配置，均为 0 表示不限制
+type TimeoutOptions struct {
+	// Idle 两个方向都没有数据超过该时间时关闭会话
+	Idle time.Duration
+	// Linger 一个方向结束后，另一个方向最多继续传输的时间
+	Linger time.Duration
+	// MaxLifetime 会话的最长存活时间
+	MaxLifetime time.Duration
+}
+
+// enabled 是否设置了任一超时
+func (o TimeoutOptions) enabled() bool {
+	return o.Idle > 0 || o.Linger > 0 || o.MaxLifetime > 0
+}
+
+// checkInterval 返回检查超时的间隔：最短超时的四分之一，限制在 10ms 到 1s 之间
+func (o TimeoutOptions) checkInterval() time.Duration {
+	interval := timeoutCheckMax
+	for _, d := range []time.Duration{o.Idle, o.Linger, o.MaxLifetime} {
+		if d > 0 && d/4 < interval {
+			interval = d / 4
+		}
+	}
+	return max(interval, timeoutCheckMin)
+}
+
+// sessionTimer 一个会话的超时检查
+type sessionTimer struct {
+	opts       TimeoutOptions
+	start      time.Time
+	active     atomic.Int64 // 最近一次写入数据的时间（相对于 start 的纳秒数）
+	writing    atomic.Int32 // 进行中的写入数
+	halfClosed atomic.Int64 // 第一个方向结束的时间（相对于 start 的纳秒数加 1），未结束时为 0
+	expire     func(code ReasonCode, message string)
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+// startSessionTimer 开始检查会话超时，超时时在检查协程中调用一次 expire；未设置超时时返回 nil
+func startSessionTimer(opts TimeoutOptions, expire func(code ReasonCode, message string)) *sessionTimer {
+	if !opts.enabled() {
+		return nil
+	}
+	t := &sessionTimer{opts: opts, start: time.Now(), expire: expire, done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+// run 定期检查是否超时
+func (t *sessionTimer) run() {
+	ticker := time.NewTicker(t.opts.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+
+		if code, message, expired := t.check(time.Since(t.start)); expired {
+			t.expire(code, message)
+			return
+		}
+	}
+}
+
+// check 判断在会话建立 elapsed 之后是否超时
+func (t *sessionTimer) check(elapsed time.Duration) (ReasonCode, string, bool) {
+	if t.opts.MaxLifetime > 0 && elapsed >= t.opts.MaxLifetime {
+		return ReasonMaxLifetime, fmt.Sprintf("上限 %s", t.opts.MaxLifetime), true
+	}
+	if closedAt := t.halfClosed.Load(); t.opts.Linger > 0 && closedAt > 0 {
+		if elapsed-time.Duration(closedAt-1) >= t.opts.Linger {
+			return ReasonLingerTimeout, fmt.Sprintf("一个方向结束后超过 %s 未关闭", t.opts.Linger), true
+		}
+	}
+	if t.opts.Idle > 0 && t.writing.Load() == 0 && elapsed-time.Duration(t.active.Load()) >= t.opts.Idle {
+		return ReasonIdleTimeout, fmt.Sprintf("超过 %s 没有数据", t.opts.Idle), true
+	}
+	return 0, "", false
+}
+
+// touch 记录一次数据传输，t 为空时无操作
+func (t *sessionTimer) touch() {
+	if t != nil {
+		t.active.Store(int64(time.Since(t.start)))
+	}
+}
+
+// halfClose 记录一个方向已结束，只记录第一次；t 为空时无操作
+func (t *sessionTimer) halfClose() {
+	if t != nil {
+		t.halfClosed.CompareAndSwap(0, int64(time.Since(t.start))+1)
+	}
+}
+
+// stop 停止检查，会话结束时调用；t 为空时无操作
+func (t *sessionTimer) stop() {
+	if t != nil {
+		t.stopOnce.Do(func() { close(t.done) })
+	}
+}
+
+// writer 返回每次写入时记录数据传输的 Writer，t 为空时返回 w
+func (t *sessionTimer) writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &timedWriter{w: w, timer: t}
+}
+
+// timedWriter 记录数据传输时间的 Writer
+type timedWriter struct {
+	w     io.Writer
+	timer *sessionTimer
+}
+
+// Write 写入数据并记录传输时间，对端接收慢导致写入阻塞时不算空闲
+func (w *timedWriter) Write(p []byte) (int, error) {
+	w.timer.writing.Add(1)
+	n, err := w.w.Write(p)
+	w.timer.writing.Add(-1)
+	w.timer.touch()
+	return n, err
+}
@@ -0,0 +1,123 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ===============================
+// 透明代理（TPROXY）模块 - Linux
+// ===============================
+
+// syscall 包未定义的 IPv6 透明代理选项
+const (
+	ipv6Transparent     = 0x4b
+	ipv6RecvOrigDstAddr = 0x4a
+)
+
+// transparentListenConfig 创建设置了 IP_TRANSPARENT 的监听配置，同时应用 socket 中的套接字选项
+//
+// recvOrigDst 为 true 时同时开启 IP_RECVORIGDSTADDR，用于获取 UDP 数据报的原始目标地址。
+func transparentListenConfig(recvOrigDst bool, socket SocketOptions) net.ListenConfig {
+	return net.ListenConfig{
+		KeepAlive: socket.KeepAlive,
+		Control: func(network, address string, rc syscall.RawConn) error {
+			var sockErr error
+			err := rc.Control(func(fd uintptr) {
+				sockErr = setTransparentOptions(int(fd), network, recvOrigDst)
+			})
+			if err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return sockErr
+			}
+			return socket.control(network, address, rc)
+		},
+	}
+}
+
+// setTransparentOptions 设置透明代理所需的套接字选项
+func setTransparentOptions(fd int, network string, recvOrigDst bool) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return fmt.Errorf("设置 SO_REUSEADDR 失败: %w", err)
+	}
+
+	isIPv6 := network != "tcp4" && network != "udp4"
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_TRANSPARENT, 1); err != nil {
+		return fmt.Errorf("设置 IP_TRANSPARENT 失败（需要 CAP_NET_ADMIN）: %w", err)
+	}
+	if recvOrigDst {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1); err != nil {
+			return fmt.Errorf("设置 IP_RECVORIGDSTADDR 失败: %w", err)
+		}
+	}
+
+	// 双栈套接字同时设置 IPv6 选项，纯 IPv4 套接字上会失败，忽略即可
+	if isIPv6 {
+		syscall.SetsockoptInt(fd, syscall.SOL_IPV6, ipv6Transparent, 1)
+		if recvOrigDst {
+			syscall.SetsockoptInt(fd, syscall.SOL_IPV6, ipv6RecvOrigDstAddr, 1)
+		}
+	}
+	return nil
+}
+
+// listenTransparentTCP 监听透明代理 TCP 端口，连接的本地地址即原始目标地址
+func listenTransparentTCP(addr string, socket SocketOptions) (net.Listener, error) {
+	lc := transparentListenConfig(false, socket)
+	listener, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil || !socket.tunesConn() {
+		return listener, err
+	}
+	return &tunedListener{Listener: listener, opts: socket}, nil
+}
+
+// listenTransparentUDP 创建透明代理 UDP 套接字
+//
+// recvOrigDst 为 true 时用于接收被拦截的数据报；为 false 时用于绑定原始目标地址发送应答（伪造源地址）。
+func listenTransparentUDP(addr string, recvOrigDst bool, socket SocketOptions) (*net.UDPConn, error) {
+	lc := transparentListenConfig(recvOrigDst, socket)
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// parseOrigDstAddr 从控制消息中解析 UDP 数据报的原始目标地址
+func parseOrigDstAddr(oob []byte) (*net.UDPAddr, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("解析控制消息失败: %w", err)
+	}
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.SOL_IP && msg.Header.Type == syscall.IP_ORIGDSTADDR:
+			// struct sockaddr_in: family(2) port(2) addr(4)
+			if len(msg.Data) < 8 {
+				continue
+			}
+			return &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[4:8]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}, nil
+		case msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == ipv6RecvOrigDstAddr:
+			// struct sockaddr_in6: family(2) port(2) flowinfo(4) addr(16) scope_id(4)
+			if len(msg.Data) < 24 {
+				continue
+			}
+			return &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[8:24]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("控制消息中没有原始目标地址")
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+)
+
+// errTransparentUnsupported 非 Linux 平台不支持透明代理
+var errTransparentUnsupported = fmt.Errorf("透明代理（TPROXY）仅支持 Linux")
+
+// listenTransparentTCP 非 Linux 平台不支持透明代理
+func listenTransparentTCP(addr string, socket SocketOptions) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+// listenTransparentUDP 非 Linux 平台不支持透明代理
+func listenTransparentUDP(addr string, recvOrigDst bool, socket SocketOptions) (*net.UDPConn, error) {
+	return nil, errTransparentUnsupported
+}
+
+// parseOrigDstAddr 非 Linux 平台不支持透明代理
+func parseOrigDstAddr(oob []byte) (*net.UDPAddr, error) {
+	return nil, errTransparentUnsupported
+}
@@ -0,0 +1,299 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ===============================
+// 传输层模块
+// ===============================
+
+// Transport 隧道连接（客户端与服务端之间）的传输层
+//
+// 返回的连接按字节流使用：隧道在其上写入长度前缀的数据帧，每个帧由一次 Write 完成，
+// 因此按消息传输的实现只要保证每次 Write 作为整体送达、Read 可以分多次读取同一消息即可。
+type Transport interface {
+	// Dial 连接到隧道服务端
+	Dial(ctx context.Context, address string) (net.Conn, error)
+	// Listen 在服务端监听隧道连接
+	Listen(address string) (net.Listener, error)
+}
+
+// ===============================
+// TCP 传输
+// ===============================
+
+// TCPTransport 默认的 TCP 传输
+type TCPTransport struct {
+	// Dialer 拨号器，为空时使用应用了 Socket 和 Bind 选项、带超时的拨号器
+	Dialer Dialer
+	// Socket 拨号（未指定 Dialer 时）和监听的 TCP 套接字选项
+	Socket SocketOptions
+	// Bind 拨号（未指定 Dialer 时）的源地址和网络接口
+	Bind BindOptions
+	// Family 拨号和监听的地址族
+	Family AddressFamily
+	// FallbackDelay 拨号时 Happy Eyeballs 发起下一次连接尝试前的等待时间，0 表示默认 250ms
+	FallbackDelay time.Duration
+	// Resolver 拨号时解析主机名的解析器，为空时每次通过系统解析器解析
+	Resolver *Resolver
+}
+
+// Dial 建立 TCP 连接，unix:// 地址建立 Unix 流式连接
+func (t *TCPTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	dialer := t.Dialer
+	if dialer == nil {
+		dialer = NewDialer(t.Socket, t.Bind)
+	}
+	return dialStream(ctx, dialer, t.Resolver, address, t.Family, t.FallbackDelay)
+}
+
+// Listen 监听 TCP 地址，unix:// 地址监听 Unix 流式套接字
+func (t *TCPTransport) Listen(address string) (net.Listener, error) {
+	return listenStream(address, SocketFileOptions{}, t.Socket, t.Family)
+}
+
+// ===============================
+// TLS 传输
+// ===============================
+
+// TLSTransport 基于 TCP 的 TLS 传输
+type TLSTransport struct {
+	// Config TLS 配置：服务端需要 Certificates；客户端为空时使用默认配置，按拨号地址校验服务端证书
+	Config *tls.Config
+	// Dialer 底层 TCP 拨号器，为空时使用应用了 Socket 和 Bind 选项、带超时的拨号器
+	Dialer Dialer
+	// Socket 底层 TCP 套接字选项
+	Socket SocketOptions
+	// Bind 底层 TCP 连接的源地址和网络接口
+	Bind BindOptions
+	// Family 拨号和监听的地址族
+	Family AddressFamily
+	// FallbackDelay 拨号时 Happy Eyeballs 发起下一次连接尝试前的等待时间，0 表示默认 250ms
+	FallbackDelay time.Duration
+	// Resolver 拨号时解析主机名的解析器，为空时每次通过系统解析器解析
+	Resolver *Resolver
+}
+
+// Dial 建立 TCP 连接并完成 TLS 握手
+func (t *TLSTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	rawConn, err := (&TCPTransport{
+		Dialer:        t.Dialer,
+		Socket:        t.Socket,
+		Bind:          t.Bind,
+		Family:        t.Family,
+		FallbackDelay: t.FallbackDelay,
+		Resolver:      t.Resolver,
+	}).Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// 未提供配置时使用默认配置，按拨号地址校验服务端证书
+	config := &tls.Config{}
+	if t.Config != nil {
+		config = t.Config.Clone()
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			config.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(rawConn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, fmt.Errorf("TLS 握手失败: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// Listen 监听 TCP 地址，接受的连接在首次读写时完成 TLS 握手
+func (t *TLSTransport) Listen(address string) (net.Listener, error) {
+	if t.Config == nil || (len(t.Config.Certificates) == 0 && t.Config.GetCertificate == nil && t.Config.GetConfigForClient == nil) {
+		return nil, fmt.Errorf("TLS 服务端需要证书")
+	}
+	listener, err := (&TCPTransport{Socket: t.Socket, Family: t.Family}).Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, t.Config), nil
+}
+
+// LoadTLSConfig 从文件加载 TLS 配置
+//
+// certFile/keyFile 为本端证书（服务端必需，客户端用于双向认证），caFile 用于校验对端证书，
+// 服务端指定 caFile 时要求客户端提供证书。
+func LoadTLSConfig(certFile, keyFile, caFile string, server, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecure,
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载 TLS 证书失败: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if server {
+		return nil, fmt.Errorf("TLS 服务端需要证书和私钥")
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 证书文件中没有有效证书: %s", caFile)
+		}
+		if server {
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.RootCAs = pool
+		}
+	}
+
+	return config, nil
+}
+
+// peerIdentity 返回 TLS 连接中已验证的客户端证书身份：证书的 CommonName，为空时使用第一个 DNS 名称；
+// 非 TLS 连接或对端未提供证书时返回空字符串
+func peerIdentity(conn net.Conn) string {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	state := tlsConn.ConnectionState()
+	if !state.HandshakeComplete || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// ===============================
+// 内存传输
+// ===============================
+
+// MemoryTransport 进程内传输，使用 net.Pipe 连接，适用于确定性的单元测试
+//
+// 地址只是名字，同一个 MemoryTransport 上 Dial 的地址必须先被 Listen。
+// net.Pipe 连接不支持半关闭，TCP 隧道会话的半关闭不会传递到对端（多路复用的流除外）。
+type MemoryTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+// NewMemoryTransport 创建进程内传输
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{listeners: make(map[string]*memoryListener)}
+}
+
+// Dial 连接到同一传输上的内存监听器
+func (t *MemoryTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	t.mu.Lock()
+	listener, exists := t.listeners[address]
+	t.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("内存传输地址 %s 没有监听器", address)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	select {
+	case listener.conns <- serverConn:
+		return clientConn, nil
+	case <-listener.done:
+		clientConn.Close()
+		serverConn.Close()
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		clientConn.Close()
+		serverConn.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Listen 在内存地址上监听
+func (t *MemoryTransport) Listen(address string) (net.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.listeners[address]; exists {
+		return nil, fmt.Errorf("内存传输地址 %s 已被占用", address)
+	}
+
+	listener := &memoryListener{
+		transport: t,
+		addr:      memoryAddr(address),
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	t.listeners[address] = listener
+	return listener, nil
+}
+
+// memoryListener 内存监听器
+type memoryListener struct {
+	transport *MemoryTransport
+	addr      memoryAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept 等待内存连接
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听器并释放地址
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.transport.mu.Lock()
+		delete(l.transport.listeners, string(l.addr))
+		l.transport.mu.Unlock()
+	})
+	return nil
+}
+
+// Addr 返回监听地址
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memoryAddr 内存传输地址
+type memoryAddr string
+
+// Network 返回网络类型
+func (a memoryAddr) Network() string {
+	return "memory"
+}
+
+// String 返回地址名
+func (a memoryAddr) String() string {
+	return string(a)
+}
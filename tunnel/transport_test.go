@@ -0,0 +1,32 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestTLSTransportNilConfig(t *testing.T) {
+	if _, err := (&TLSTransport{}).Listen("127.0.0.1:0"); err == nil {
+		t.Fatal("没有证书时 Listen 应返回错误")
+	}
+
+	// 对端直接关闭连接，握手失败；未提供配置时不应崩溃
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	if _, err := (&TLSTransport{}).Dial(context.Background(), listener.Addr().String()); err == nil {
+		t.Fatal("握手应失败")
+	}
+}
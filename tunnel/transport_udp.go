@@ -0,0 +1,1102 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ===============================
+// UDP 传输模块
+// ===============================
+
+// UDP 传输包格式（大端序）：
+//   - 1 字节包类型
+//   - 4 字节连接编号（由客户端随机选择）
+//   - 类型相关字段：
+//     SYN:  1 字节标志（第 0 位表示可靠模式）
+//     DATA: 4 字节序号 + 数据（可靠模式下空数据段表示写方向关闭；
+//           不可靠模式下序号 0 的数据报即会话头需要确认，确认前重传）
+//     ACK:  4 字节累计确认序号（之前的数据段全部收到）+ 1 字节位图长度 N + N 字节选择确认位图，
+//           位图第 i 位表示序号“累计确认序号 + 1 + i”的数据段已收到
+//     SYNACK/PING/FIN: 无
+//
+// 服务端收到 SYN 后回复 SYNACK，收到对端的其他数据包（客户端收到 SYNACK 后立即发送 PING）后
+// 才确认对端地址并交给 Accept，未确认的握手数有上限，伪造来源地址的 SYN 不会无限占用资源。
+
+// UDP 传输包类型
+const (
+	udpPacketSYN    = 1
+	udpPacketSYNACK = 2
+	udpPacketDATA   = 3
+	udpPacketACK    = 4
+	udpPacketPING   = 5
+	udpPacketFIN    = 6
+)
+
+const (
+	// 包头长度：类型 + 连接编号
+	udpHeaderSize = 5
+	// SYN 标志：可靠模式
+	udpFlagReliable = 0x01
+	// 可靠模式下每个数据段的最大负载，避免 IP 分片
+	udpSegmentSize = 1200
+	// 单个 UDP 数据报的最大负载
+	udpMaxDatagram = 65507
+	// 不可靠模式下单个数据报可以携带的最大数据长度
+	udpMaxMessage = udpMaxDatagram - udpHeaderSize - 4
+	// 最大在途数据段数，同时也是接收端乱序缓存窗口
+	udpWindowSize = 256
+	// 接收端未读数据上限，超过后丢弃新数据段等待重传（简单的流量控制）
+	udpMaxReadBuffer = 4 * 1024 * 1024
+	// 不可靠模式下未读消息数上限
+	udpMaxMessages = 1024
+	// 拥塞窗口初始值（数据段数）
+	udpInitialCwnd = 4
+	// 重传超时范围
+	udpInitialRTO = 500 * time.Millisecond
+	udpMinRTO     = 100 * time.Millisecond
+	udpMaxRTO     = 5 * time.Second
+	// 快速重传阈值：一个数据段被之后的选择确认跳过的次数
+	udpFastRetransmit = 3
+	// 定时器检查间隔
+	udpTickInterval = 20 * time.Millisecond
+	// 握手重发间隔
+	udpHandshakeInterval = 500 * time.Millisecond
+	// 默认保活间隔和对端超时
+	udpDefaultKeepalive   = 5 * time.Second
+	udpDefaultIdleTimeout = 30 * time.Second
+	// 关闭时等待发送队列清空的最长时间
+	udpLingerTimeout = 5 * time.Second
+	// 监听端未接受连接的队列长度
+	udpAcceptBacklog = 128
+	// 监听端未确认的握手数上限，超过后丢弃新的 SYN（客户端会重发）
+	udpMaxPending = 1024
+	// 未确认的握手超时时间
+	udpPendingTimeout = 5 * time.Second
+)
+
+// errUDPPeerTimeout 对端超时
+var errUDPPeerTimeout = errors.New("UDP 传输对端超时")
+
+// errUDPHandshakeTimeout 握手未被确认
+var errUDPHandshakeTimeout = errors.New("UDP 传输握手未被确认")
+
+// UDPTransport 基于 UDP 的隧道传输
+//
+// 可靠模式提供有序字节流（选择确认、快速重传、AIMD 拥塞控制），适用于两种隧道；
+// 不可靠模式每个隧道帧对应一个 UDP 数据报，丢失的帧不会重传，仅适用于 UDP 隧道；
+// 不可靠模式下每个方向的第一个数据报（会话头）仍然可靠送达，之前到达的数据报被丢弃。
+type UDPTransport struct {
+	// Reliable 启用可靠传输（由客户端决定，服务端跟随客户端的握手标志）
+	Reliable bool
+	// KeepaliveInterval 空闲时发送保活包的间隔，0 表示默认 5 秒
+	KeepaliveInterval time.Duration
+	// IdleTimeout 多久未收到对端数据包视为连接断开，0 表示默认 30 秒
+	IdleTimeout time.Duration
+	// LossRate 发送时随机丢弃数据包的比例（0~1），仅用于测试
+	LossRate float64
+	// Socket UDP 套接字选项（缓冲区、SO_MARK 和 DSCP）
+	Socket SocketOptions
+	// Bind 客户端 UDP 套接字的源地址和网络接口
+	Bind BindOptions
+	// Family 拨号和监听的地址族，拨号时使用排序后的第一个地址
+	Family AddressFamily
+	// Resolver 拨号时解析主机名的解析器，为空时每次通过系统解析器解析
+	Resolver *Resolver
+}
+
+// keepalive 返回保活间隔
+func (t *UDPTransport) keepalive() time.Duration {
+	if t.KeepaliveInterval > 0 {
+		return t.KeepaliveInterval
+	}
+	return udpDefaultKeepalive
+}
+
+// idleTimeout 返回对端超时时间
+func (t *UDPTransport) idleTimeout() time.Duration {
+	if t.IdleTimeout > 0 {
+		return t.IdleTimeout
+	}
+	return udpDefaultIdleTimeout
+}
+
+// Dial 与服务端完成握手并返回连接
+func (t *UDPTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	remote, err := resolveUDP(ctx, t.Resolver, address, t.Family)
+	if err != nil {
+		return nil, fmt.Errorf("解析 UDP 服务端地址失败: %w", err)
+	}
+	sock, err := newSocketDialer(t.Socket, t.Bind).dialUDP(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newUDPTransportConn(t, sock, remote, rand.Uint32(), t.Reliable, true)
+	conn.onClose = func() { sock.Close() }
+	go conn.clientReadLoop()
+
+	// 定期重发 SYN 直到收到 SYNACK
+	flags := byte(0)
+	if t.Reliable {
+		flags = udpFlagReliable
+	}
+	ticker := time.NewTicker(udpHandshakeInterval)
+	defer ticker.Stop()
+	for {
+		conn.sendControl(udpPacketSYN, flags)
+		select {
+		case <-conn.established:
+			// 确认收到 SYNACK，服务端据此确认客户端地址；PING 可能丢失，握手超时前按握手间隔保活
+			conn.confirmUntil = time.Now().Add(udpPendingTimeout)
+			conn.sendControl(udpPacketPING)
+			go conn.timerLoop()
+			return conn, nil
+		case <-conn.done:
+			return nil, conn.err()
+		case <-ctx.Done():
+			conn.shutdown(ctx.Err(), false)
+			return nil, fmt.Errorf("UDP 传输握手失败: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Listen 监听 UDP 地址
+func (t *UDPTransport) Listen(address string) (net.Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("解析 UDP 监听地址失败: %w", err)
+	}
+	sock, err := t.Socket.listenPacket("udp", udpAddr.String(), t.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &udpTransportListener{
+		transport: t,
+		sock:      sock,
+		conns:     make(map[string]*udpTransportConn),
+		pending:   make(map[string]struct{}),
+		accept:    make(chan *udpTransportConn, udpAcceptBacklog),
+		done:      make(chan struct{}),
+	}
+	go listener.readLoop()
+	return listener, nil
+}
+
+// ===============================
+// UDP 传输监听器
+// ===============================
+
+// udpTransportListener 按“对端地址 + 连接编号”分发数据包的监听器
+type udpTransportListener struct {
+	transport *UDPTransport
+	sock      *net.UDPConn
+	mu        sync.Mutex
+	conns     map[string]*udpTransportConn
+	pending   map[string]struct{} // 尚未确认对端地址的连接
+	accept    chan *udpTransportConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// readLoop 读取数据包并分发到对应连接
+func (l *udpTransportListener) readLoop() {
+	buffer := make([]byte, udpMaxDatagram)
+	for {
+		n, remote, err := l.sock.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("UDP 传输读取失败: %v", err)
+			continue
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+
+		packetType := buffer[0]
+		conv := binary.BigEndian.Uint32(buffer[1:udpHeaderSize])
+		body := buffer[udpHeaderSize:n]
+		key := fmt.Sprintf("%s/%d", remote, conv)
+
+		l.mu.Lock()
+		conn, exists := l.conns[key]
+		l.mu.Unlock()
+
+		switch {
+		case exists:
+			conn.handlePacket(packetType, body)
+		case packetType == udpPacketSYN:
+			l.acceptConn(key, remote, conv, body)
+		case packetType != udpPacketFIN:
+			// 未知连接（例如服务端重启后），通知对端关闭
+			l.sendFIN(remote, conv)
+		}
+	}
+}
+
+// acceptConn 为新的 SYN 创建未确认的连接并回复 SYNACK，收到对端的其他数据包后放入接受队列
+func (l *udpTransportListener) acceptConn(key string, remote *net.UDPAddr, conv uint32, body []byte) {
+	reliable := len(body) > 0 && body[0]&udpFlagReliable != 0
+	conn := newUDPTransportConn(l.transport, l.sock, remote, conv, reliable, false)
+	conn.pending = true
+	conn.onConfirm = func() { l.confirm(key, conn) }
+	conn.onClose = func() {
+		l.mu.Lock()
+		delete(l.conns, key)
+		delete(l.pending, key)
+		l.mu.Unlock()
+	}
+	close(conn.established)
+
+	// 未确认的握手过多时丢弃 SYN，不为伪造来源地址的 SYN 分配更多资源
+	l.mu.Lock()
+	if len(l.pending) >= udpMaxPending {
+		l.mu.Unlock()
+		return
+	}
+	l.conns[key] = conn
+	l.pending[key] = struct{}{}
+	l.mu.Unlock()
+
+	conn.sendControl(udpPacketSYNACK)
+	go conn.timerLoop()
+}
+
+// confirm 对端地址已确认，把连接放入接受队列
+func (l *udpTransportListener) confirm(key string, conn *udpTransportConn) {
+	l.mu.Lock()
+	_, pending := l.pending[key]
+	delete(l.pending, key)
+	l.mu.Unlock()
+	if !pending {
+		return
+	}
+
+	select {
+	case l.accept <- conn:
+	default:
+		log.Printf("UDP 传输接受队列已满，丢弃来自 %s 的连接", conn.remote)
+		conn.shutdown(net.ErrClosed, true)
+	}
+}
+
+// sendFIN 向未知连接发送 FIN
+func (l *udpTransportListener) sendFIN(remote *net.UDPAddr, conv uint32) {
+	packet := make([]byte, udpHeaderSize)
+	packet[0] = udpPacketFIN
+	binary.BigEndian.PutUint32(packet[1:], conv)
+	l.sock.WriteToUDP(packet, remote)
+}
+
+// Accept 等待新连接
+func (l *udpTransportListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听器及其上的所有连接
+func (l *udpTransportListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+
+		l.mu.Lock()
+		conns := make([]*udpTransportConn, 0, len(l.conns))
+		for _, conn := range l.conns {
+			conns = append(conns, conn)
+		}
+		l.mu.Unlock()
+
+		for _, conn := range conns {
+			conn.shutdown(net.ErrClosed, true)
+		}
+		l.sock.Close()
+	})
+	return nil
+}
+
+// Addr 返回监听地址
+func (l *udpTransportListener) Addr() net.Addr {
+	return l.sock.LocalAddr()
+}
+
+// ===============================
+// UDP 传输连接
+// ===============================
+
+// udpSegment 可靠模式下已发送未确认的数据段
+type udpSegment struct {
+	seq         uint32
+	data        []byte
+	sentAt      time.Time
+	retransmits int
+	sacked      bool // 已被选择确认，不再重传
+	lost        bool // 重传超时，等待拥塞窗口允许时重传
+	skipped     int  // 被之后的选择确认跳过的次数
+}
+
+// udpTransportConn UDP 传输连接
+type udpTransportConn struct {
+	transport *UDPTransport
+	sock      *net.UDPConn
+	remote    *net.UDPAddr
+	conv      uint32
+	reliable  bool
+	connected bool   // 客户端套接字已连接，直接 Write
+	onClose   func() // 关闭时释放资源
+	onConfirm func() // 服务端确认对端地址后放入接受队列
+
+	mu sync.Mutex
+
+	// 发送状态
+	nextSeq     uint32
+	queue       []*udpSegment // 按序号排列的未确认数据段
+	cwnd        float64
+	ssthresh    float64
+	srtt        time.Duration
+	rttvar      time.Duration
+	rto         time.Duration
+	recover     uint32 // 快速恢复期间不重复降低拥塞窗口
+	writeClosed bool   // 已调用 CloseWrite
+	oversized   uint64 // 不可靠模式下因过长丢弃的数据报数
+	lastSend    time.Time
+	// 客户端在此之前按握手间隔发送保活包，保证服务端确认客户端地址
+	confirmUntil time.Time
+
+	// 接收状态
+	rcvNext    uint32
+	outOfOrder map[uint32][]byte
+	readBuf    []byte
+	messages   [][]byte
+	readEOF    bool // 已按序收到对端的写关闭数据段
+	lastRecv   time.Time
+
+	// 连接状态
+	pending       bool // 服务端尚未收到 SYN 之外的数据包，对端地址未经确认
+	closed        bool
+	closeErr      error
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readNotify    chan struct{}
+	writeNotify   chan struct{}
+	established   chan struct{}
+	establishOnce sync.Once
+	done          chan struct{}
+}
+
+// newUDPTransportConn 创建 UDP 传输连接
+func newUDPTransportConn(t *UDPTransport, sock *net.UDPConn, remote *net.UDPAddr, conv uint32, reliable, connected bool) *udpTransportConn {
+	now := time.Now()
+	return &udpTransportConn{
+		transport:   t,
+		sock:        sock,
+		remote:      remote,
+		conv:        conv,
+		reliable:    reliable,
+		connected:   connected,
+		cwnd:        udpInitialCwnd,
+		ssthresh:    udpWindowSize,
+		rto:         udpInitialRTO,
+		lastSend:    now,
+		lastRecv:    now,
+		outOfOrder:  make(map[uint32][]byte),
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+		established: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// seqLess 比较序号，处理回绕
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// notify 非阻塞地唤醒等待者
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// clientReadLoop 客户端读取自己套接字上的数据包
+func (c *udpTransportConn) clientReadLoop() {
+	buffer := make([]byte, udpMaxDatagram)
+	for {
+		n, err := c.sock.Read(buffer)
+		if err != nil {
+			c.shutdown(err, false)
+			return
+		}
+		if n < udpHeaderSize || binary.BigEndian.Uint32(buffer[1:udpHeaderSize]) != c.conv {
+			continue
+		}
+		c.handlePacket(buffer[0], buffer[udpHeaderSize:n])
+	}
+}
+
+// handlePacket 处理收到的数据包
+func (c *udpTransportConn) handlePacket(packetType byte, body []byte) {
+	c.mu.Lock()
+	c.lastRecv = time.Now()
+	confirmed := c.pending && packetType != udpPacketSYN
+	if confirmed {
+		c.pending = false
+	}
+	c.mu.Unlock()
+	if confirmed {
+		c.onConfirm()
+	}
+
+	switch packetType {
+	case udpPacketSYN:
+		// 对端没有收到 SYNACK，重发
+		c.sendControl(udpPacketSYNACK)
+	case udpPacketSYNACK:
+		c.establishOnce.Do(func() { close(c.established) })
+	case udpPacketDATA:
+		if len(body) >= 4 {
+			c.handleData(binary.BigEndian.Uint32(body), body[4:])
+		}
+	case udpPacketACK:
+		if len(body) >= 5 {
+			bitmapLen := int(body[4])
+			if len(body) >= 5+bitmapLen {
+				c.handleAck(binary.BigEndian.Uint32(body), body[5:5+bitmapLen])
+			}
+		}
+	case udpPacketFIN:
+		c.shutdown(io.EOF, false)
+	}
+}
+
+// handleData 处理数据段
+func (c *udpTransportConn) handleData(seq uint32, data []byte) {
+	c.mu.Lock()
+
+	if !c.reliable {
+		c.handleMessageLocked(seq, data)
+		return
+	}
+
+	switch {
+	case seqLess(seq, c.rcvNext):
+		// 重复数据段，重新确认即可
+	case seq == c.rcvNext:
+		if len(c.readBuf)+len(data) > udpMaxReadBuffer {
+			// 应用未及时读取，丢弃后等待重传
+			break
+		}
+		c.deliverLocked(data)
+		for {
+			next, exists := c.outOfOrder[c.rcvNext]
+			if !exists {
+				break
+			}
+			delete(c.outOfOrder, c.rcvNext)
+			c.deliverLocked(next)
+		}
+	case seq-c.rcvNext < udpWindowSize:
+		if _, exists := c.outOfOrder[seq]; !exists {
+			c.outOfOrder[seq] = append([]byte(nil), data...)
+		}
+	}
+
+	ack := c.buildAckLocked()
+	c.mu.Unlock()
+
+	notify(c.readNotify)
+	c.sendRaw(ack)
+}
+
+// handleMessageLocked 不可靠模式下处理数据报并释放锁：序号 0 的数据报（会话头）只交付一次并回复确认，
+// 收到它之前到达的其他数据报被丢弃，保证对端读到的第一个数据报总是会话头
+func (c *udpTransportConn) handleMessageLocked(seq uint32, data []byte) {
+	if seq == 0 {
+		if c.rcvNext == 0 {
+			c.rcvNext = 1
+			c.messages = append(c.messages, append([]byte(nil), data...))
+		}
+		ack := c.buildAckLocked()
+		c.mu.Unlock()
+		notify(c.readNotify)
+		c.sendRaw(ack)
+		return
+	}
+
+	if c.rcvNext != 0 && len(data) > 0 && len(c.messages) < udpMaxMessages {
+		c.messages = append(c.messages, append([]byte(nil), data...))
+	}
+	c.mu.Unlock()
+	notify(c.readNotify)
+}
+
+// deliverLocked 按序交付数据段，调用方需持有锁
+func (c *udpTransportConn) deliverLocked(data []byte) {
+	if len(data) == 0 {
+		c.readEOF = true
+	}
+	c.readBuf = append(c.readBuf, data...)
+	c.rcvNext++
+}
+
+// buildAckLocked 构造确认包，调用方需持有锁
+func (c *udpTransportConn) buildAckLocked() []byte {
+	var bitmap []byte
+	for seq := range c.outOfOrder {
+		offset := int(seq - c.rcvNext - 1)
+		if offset < 0 || offset >= udpWindowSize {
+			continue
+		}
+		for len(bitmap) <= offset/8 {
+			bitmap = append(bitmap, 0)
+		}
+		bitmap[offset/8] |= 1 << (offset % 8)
+	}
+
+	packet := c.header(udpPacketACK, 4+1+len(bitmap))
+	packet = binary.BigEndian.AppendUint32(packet, c.rcvNext)
+	packet = append(packet, byte(len(bitmap)))
+	return append(packet, bitmap...)
+}
+
+// handleAck 处理确认包：移除已确认数据段、更新 RTT 与拥塞窗口、触发快速重传
+func (c *udpTransportConn) handleAck(cumAck uint32, bitmap []byte) {
+	now := time.Now()
+	var retransmit []*udpSegment
+
+	c.mu.Lock()
+	newlyAcked := 0
+
+	for len(c.queue) > 0 && seqLess(c.queue[0].seq, cumAck) {
+		segment := c.queue[0]
+		c.queue = c.queue[1:]
+		if !segment.sacked {
+			newlyAcked++
+			if segment.retransmits == 0 {
+				c.updateRTTLocked(now.Sub(segment.sentAt))
+			}
+		}
+	}
+
+	highestSacked, hasSacked := uint32(0), false
+	for offset := 0; offset < len(bitmap)*8; offset++ {
+		if bitmap[offset/8]&(1<<(offset%8)) == 0 {
+			continue
+		}
+		segment := c.segmentLocked(cumAck + 1 + uint32(offset))
+		if segment == nil {
+			continue
+		}
+		if !segment.sacked {
+			segment.sacked = true
+			newlyAcked++
+			if segment.retransmits == 0 {
+				c.updateRTTLocked(now.Sub(segment.sentAt))
+			}
+		}
+		highestSacked, hasSacked = segment.seq, true
+	}
+
+	// 被之后的数据段跳过多次的数据段视为丢失，立即重传
+	if hasSacked {
+		for _, segment := range c.queue {
+			if !seqLess(segment.seq, highestSacked) {
+				break
+			}
+			if segment.sacked {
+				continue
+			}
+			segment.skipped++
+			if segment.skipped == udpFastRetransmit {
+				retransmit = append(retransmit, segment)
+			}
+		}
+	}
+	if len(retransmit) > 0 && !seqLess(retransmit[0].seq, c.recover) {
+		// 每个窗口只降低一次拥塞窗口
+		c.ssthresh = maxFloat(c.cwnd/2, 2)
+		c.cwnd = c.ssthresh
+		c.recover = c.nextSeq
+	}
+
+	for i := 0; i < newlyAcked; i++ {
+		if c.cwnd < c.ssthresh {
+			c.cwnd++
+		} else {
+			c.cwnd += 1 / c.cwnd
+		}
+	}
+	if c.cwnd > udpWindowSize {
+		c.cwnd = udpWindowSize
+	}
+
+	packets := make([][]byte, 0, len(retransmit))
+	for _, segment := range retransmit {
+		packets = append(packets, c.retransmitLocked(segment, now))
+	}
+	packets = append(packets, c.retransmitLostLocked(now)...)
+	c.mu.Unlock()
+
+	for _, packet := range packets {
+		c.sendRaw(packet)
+	}
+	notify(c.writeNotify)
+}
+
+// segmentLocked 按序号查找未确认数据段，调用方需持有锁
+func (c *udpTransportConn) segmentLocked(seq uint32) *udpSegment {
+	if len(c.queue) == 0 || seqLess(seq, c.queue[0].seq) {
+		return nil
+	}
+	index := int(seq - c.queue[0].seq)
+	if index >= len(c.queue) {
+		return nil
+	}
+	return c.queue[index]
+}
+
+// updateRTTLocked 按 RFC 6298 更新 RTT 估计和重传超时，调用方需持有锁
+func (c *udpTransportConn) updateRTTLocked(sample time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = sample
+		c.rttvar = sample / 2
+	} else {
+		delta := c.srtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + sample) / 8
+	}
+	c.rto = clampDuration(c.srtt+4*c.rttvar, udpMinRTO, udpMaxRTO)
+}
+
+// inFlightLocked 返回已发送且未被确认的数据段数（不含超时后等待重传的数据段），调用方需持有锁
+func (c *udpTransportConn) inFlightLocked() int {
+	count := 0
+	for _, segment := range c.queue {
+		if !segment.sacked && !segment.lost {
+			count++
+		}
+	}
+	return count
+}
+
+// hasLostLocked 是否有超时后等待重传的数据段，调用方需持有锁
+func (c *udpTransportConn) hasLostLocked() bool {
+	for _, segment := range c.queue {
+		if segment.lost {
+			return true
+		}
+	}
+	return false
+}
+
+// retransmitLocked 标记数据段已重传并返回数据包，调用方需持有锁
+func (c *udpTransportConn) retransmitLocked(segment *udpSegment, now time.Time) []byte {
+	segment.retransmits++
+	segment.sentAt = now
+	segment.lost = false
+	return c.dataPacket(segment)
+}
+
+// retransmitLostLocked 在拥塞窗口允许的范围内按序重传超时的数据段，调用方需持有锁
+func (c *udpTransportConn) retransmitLostLocked(now time.Time) [][]byte {
+	var packets [][]byte
+	inFlight := c.inFlightLocked()
+	for _, segment := range c.queue {
+		if inFlight >= int(c.cwnd) {
+			break
+		}
+		if segment.lost {
+			packets = append(packets, c.retransmitLocked(segment, now))
+			inFlight++
+		}
+	}
+	return packets
+}
+
+// timerLoop 处理重传超时、保活和对端超时
+func (c *udpTransportConn) timerLoop() {
+	ticker := time.NewTicker(udpTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		c.mu.Lock()
+		if c.pending && now.Sub(c.lastRecv) > udpPendingTimeout {
+			// 对端地址可能是伪造的，不发送 FIN
+			c.mu.Unlock()
+			c.shutdown(errUDPHandshakeTimeout, false)
+			return
+		}
+		if now.Sub(c.lastRecv) > c.transport.idleTimeout() {
+			c.mu.Unlock()
+			c.shutdown(errUDPPeerTimeout, true)
+			return
+		}
+
+		packets := c.retransmitTimedOutLocked(now)
+
+		keepalive := c.transport.keepalive()
+		if now.Before(c.confirmUntil) {
+			keepalive = udpHandshakeInterval
+		}
+		if len(packets) == 0 && now.Sub(c.lastSend) > keepalive {
+			packets = append(packets, c.header(udpPacketPING, 0))
+		}
+		c.mu.Unlock()
+
+		for _, packet := range packets {
+			c.sendRaw(packet)
+		}
+	}
+}
+
+// retransmitTimedOutLocked 处理重传超时，返回需要重传的数据包，调用方需持有锁
+//
+// 只立即重传第一个未确认的数据段，其余超时的数据段标记为丢失，收到确认后按拥塞窗口依次重传；
+// 同时退回慢启动并指数退避
+func (c *udpTransportConn) retransmitTimedOutLocked(now time.Time) [][]byte {
+	var packets [][]byte
+	var first *udpSegment
+	for _, segment := range c.queue {
+		if segment.sacked || segment.lost {
+			continue
+		}
+		if first == nil {
+			first = segment
+		} else if now.Sub(segment.sentAt) >= c.rto {
+			segment.lost = true
+		}
+	}
+	if first != nil && now.Sub(first.sentAt) >= c.rto {
+		packets = append(packets, c.retransmitLocked(first, now))
+		c.ssthresh = maxFloat(c.cwnd/2, 2)
+		c.cwnd = 1
+		c.rto = clampDuration(c.rto*2, udpMinRTO, udpMaxRTO)
+	}
+	return append(packets, c.retransmitLostLocked(now)...)
+}
+
+// header 构造包头
+func (c *udpTransportConn) header(packetType byte, extra int) []byte {
+	packet := make([]byte, udpHeaderSize, udpHeaderSize+extra)
+	packet[0] = packetType
+	binary.BigEndian.PutUint32(packet[1:], c.conv)
+	return packet
+}
+
+// dataPacket 构造数据包
+func (c *udpTransportConn) dataPacket(segment *udpSegment) []byte {
+	packet := c.header(udpPacketDATA, 4+len(segment.data))
+	packet = binary.BigEndian.AppendUint32(packet, segment.seq)
+	return append(packet, segment.data...)
+}
+
+// sendControl 发送控制包
+func (c *udpTransportConn) sendControl(packetType byte, body ...byte) {
+	c.sendRaw(append(c.header(packetType, len(body)), body...))
+}
+
+// sendRaw 发送数据包，按 LossRate 模拟丢包
+func (c *udpTransportConn) sendRaw(packet []byte) error {
+	c.mu.Lock()
+	c.lastSend = time.Now()
+	c.mu.Unlock()
+
+	if c.transport.LossRate > 0 && rand.Float64() < c.transport.LossRate {
+		return nil
+	}
+
+	var err error
+	if c.connected {
+		_, err = c.sock.Write(packet)
+	} else {
+		_, err = c.sock.WriteToUDP(packet, c.remote)
+	}
+	return err
+}
+
+// Read 读取数据：可靠模式为有序字节流，不可靠模式每次读取一个数据报中的数据
+func (c *udpTransportConn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.readBuf) > 0 {
+			n := copy(p, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if len(c.messages) > 0 {
+			n := copy(p, c.messages[0])
+			if n < len(c.messages[0]) {
+				c.messages[0] = c.messages[0][n:]
+			} else {
+				c.messages = c.messages[1:]
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.readEOF {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.closed {
+			err := c.closeErr
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		if err := waitNotify(c.readNotify, c.done, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 写入数据：可靠模式按数据段发送并受拥塞窗口限制，不可靠模式整体作为一个数据报发送
+func (c *udpTransportConn) Write(p []byte) (int, error) {
+	if !c.reliable {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, c.closeErr
+		}
+		if len(p) > udpMaxMessage {
+			// 无法放入单个 UDP 数据报（例如接近 64KB 的隧道帧），与丢包同样处理，不中断会话
+			c.oversized++
+			dropped := c.oversized
+			c.mu.Unlock()
+			if dropped == 1 || dropped%1000 == 0 {
+				log.Printf("UDP 传输连接 %s 丢弃过长的数据报: %d 字节，上限 %d 字节（累计丢弃 %d 个）", c.remote, len(p), udpMaxMessage, dropped)
+			}
+			return len(p), nil
+		}
+		segment := &udpSegment{seq: c.nextSeq, data: p}
+		if segment.seq == 0 {
+			// 第一个数据报（会话头）在确认前按重传超时重发
+			segment.data = append([]byte(nil), p...)
+			segment.sentAt = time.Now()
+			c.queue = append(c.queue, segment)
+		}
+		c.nextSeq++
+		packet := c.dataPacket(segment)
+		c.mu.Unlock()
+		if err := c.sendRaw(packet); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	written := 0
+	for written < len(p) {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return written, c.closeErr
+		}
+		if c.writeClosed {
+			c.mu.Unlock()
+			return written, net.ErrClosed
+		}
+		// 超时后等待重传的数据段优先于新数据
+		if c.inFlightLocked() >= int(c.cwnd) || c.hasLostLocked() || len(c.queue) >= udpWindowSize {
+			deadline := c.writeDeadline
+			c.mu.Unlock()
+			if err := waitNotify(c.writeNotify, c.done, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		end := written + udpSegmentSize
+		if end > len(p) {
+			end = len(p)
+		}
+		segment := &udpSegment{
+			seq:    c.nextSeq,
+			data:   append([]byte(nil), p[written:end]...),
+			sentAt: time.Now(),
+		}
+		c.nextSeq++
+		c.queue = append(c.queue, segment)
+		packet := c.dataPacket(segment)
+		c.mu.Unlock()
+
+		c.sendRaw(packet)
+		written = end
+	}
+	return written, nil
+}
+
+// CloseWrite 关闭写方向：可靠模式下发送一个空数据段，对端按序收到后读到 EOF
+func (c *udpTransportConn) CloseWrite() error {
+	if !c.reliable {
+		return nil
+	}
+
+	c.mu.Lock()
+	if c.closed || c.writeClosed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.writeClosed = true
+	segment := &udpSegment{seq: c.nextSeq, sentAt: time.Now()}
+	c.nextSeq++
+	c.queue = append(c.queue, segment)
+	packet := c.dataPacket(segment)
+	c.mu.Unlock()
+
+	return c.sendRaw(packet)
+}
+
+// waitNotify 等待通知、连接关闭或超时
+func waitNotify(ch, done chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		select {
+		case <-ch:
+		case <-done:
+		}
+		return nil
+	}
+
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-done:
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// Close 关闭连接，可靠模式下先等待已写入的数据被确认
+func (c *udpTransportConn) Close() error {
+	if c.reliable {
+		deadline := time.Now().Add(udpLingerTimeout)
+		for {
+			c.mu.Lock()
+			pending := len(c.queue) > 0 && !c.closed
+			c.mu.Unlock()
+			if !pending || waitNotify(c.writeNotify, c.done, deadline) != nil {
+				break
+			}
+		}
+	}
+	c.shutdown(net.ErrClosed, true)
+	return nil
+}
+
+// shutdown 标记连接关闭，sendFIN 为 true 时通知对端
+func (c *udpTransportConn) shutdown(err error, sendFIN bool) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.closeErr = err
+	close(c.done)
+	c.mu.Unlock()
+
+	if sendFIN {
+		// FIN 不重传，多发一次降低丢失概率
+		c.sendControl(udpPacketFIN)
+		c.sendControl(udpPacketFIN)
+	}
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
+
+// err 返回连接关闭原因
+func (c *udpTransportConn) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeErr
+}
+
+// LocalAddr 返回本地地址
+func (c *udpTransportConn) LocalAddr() net.Addr {
+	return c.sock.LocalAddr()
+}
+
+// RemoteAddr 返回对端地址
+func (c *udpTransportConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline 设置读写超时
+func (c *udpTransportConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读超时
+func (c *udpTransportConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	notify(c.readNotify)
+	return nil
+}
+
+// SetWriteDeadline 设置写超时
+func (c *udpTransportConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	notify(c.writeNotify)
+	return nil
+}
+
+// maxFloat 返回较大值
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// clampDuration 将时长限制在范围内
+func clampDuration(d, low, high time.Duration) time.Duration {
+	if d < low {
+		return low
+	}
+	if d > high {
+		return high
+	}
+	return d
+}
@@ -0,0 +1,230 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// udpTransportPair 在回环地址上建立一对 UDP 传输连接
+func udpTransportPair(t *testing.T, server, client *UDPTransport) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	clientConn, err := client.Dial(ctx, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clientConn.Close() })
+
+	// 服务端在收到客户端 SYN 之外的数据包后才接受连接
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		t.Cleanup(func() { conn.Close() })
+		return conn, clientConn
+	case <-time.After(10 * time.Second):
+		t.Fatal("服务端没有接受连接")
+		return nil, nil
+	}
+}
+
+func TestUDPTransportReliableLoss(t *testing.T) {
+	serverConn, clientConn := udpTransportPair(t,
+		&UDPTransport{LossRate: 0.1},
+		&UDPTransport{Reliable: true, LossRate: 0.1})
+
+	payload := make([]byte, 512*1024)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	// 客户端写完后半关闭，服务端读到 EOF 后原样写回并半关闭
+	go func() {
+		clientConn.Write(payload)
+		clientConn.(closeWriter).CloseWrite()
+	}()
+	go func() {
+		received, _ := io.ReadAll(serverConn)
+		serverConn.Write(received)
+		serverConn.(closeWriter).CloseWrite()
+	}()
+
+	clientConn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	echoed, err := io.ReadAll(clientConn)
+	if err != nil {
+		t.Fatalf("读取失败（已收到 %d 字节）: %v", len(echoed), err)
+	}
+	if !bytes.Equal(echoed, payload) {
+		t.Fatalf("收到 %d 字节，与发送的 %d 字节不一致", len(echoed), len(payload))
+	}
+}
+
+func TestUDPTransportUnreliableFirstMessage(t *testing.T) {
+	serverConn, clientConn := udpTransportPair(t,
+		&UDPTransport{LossRate: 0.5},
+		&UDPTransport{LossRate: 0.5})
+
+	// 第一个数据报丢失时会重传，对端读到的第一个数据报总是它
+	if _, err := clientConn.Write([]byte("header")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		clientConn.Write([]byte("data"))
+	}
+
+	buffer := make([]byte, 64)
+	serverConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := serverConn.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffer[:n]) != "header" {
+		t.Fatalf("第一个数据报为 %q", buffer[:n])
+	}
+
+	// 之后的数据报不重传，重复的第一个数据报不会再次交付
+	time.Sleep(udpInitialRTO * 3)
+	for {
+		serverConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := serverConn.Read(buffer)
+		if err != nil {
+			break
+		}
+		if string(buffer[:n]) != "data" {
+			t.Fatalf("收到意外的数据报 %q", buffer[:n])
+		}
+	}
+}
+
+func TestUDPTransportPendingHandshakes(t *testing.T) {
+	listener, err := (&UDPTransport{}).Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	sock, err := net.DialUDP("udp", nil, listener.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	// 只发送 SYN 的对端不会被接受，未确认的握手数不超过上限
+	for conv := uint32(1); conv <= udpMaxPending+100; conv++ {
+		packet := binary.BigEndian.AppendUint32([]byte{udpPacketSYN}, conv)
+		if _, err := sock.Write(append(packet, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if conv%100 == 0 {
+			// 避免超过套接字接收缓冲区
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	udpListener := listener.(*udpTransportListener)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		udpListener.mu.Lock()
+		pending, conns := len(udpListener.pending), len(udpListener.conns)
+		udpListener.mu.Unlock()
+		if pending > udpMaxPending || conns > udpMaxPending {
+			t.Fatalf("未确认的握手 %d 个，连接 %d 个，超过上限 %d", pending, conns, udpMaxPending)
+		}
+		if pending == udpMaxPending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("未确认的握手只有 %d 个", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(udpListener.accept) != 0 {
+		t.Fatalf("未确认的连接不应进入接受队列")
+	}
+
+	// 确认后才进入接受队列
+	if _, err := sock.Write(binary.BigEndian.AppendUint32([]byte{udpPacketPING}, 1)); err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("确认后的连接没有被接受")
+	}
+}
+
+func TestUDPTransportUnreliableOversized(t *testing.T) {
+	serverConn, clientConn := udpTransportPair(t, &UDPTransport{}, &UDPTransport{})
+
+	// 无法放入单个 UDP 数据报的数据被丢弃，会话继续
+	if _, err := clientConn.Write(make([]byte, udpMaxMessage+1)); err != nil {
+		t.Fatalf("过长的数据报应被丢弃而不是返回错误: %v", err)
+	}
+	if _, err := clientConn.Write([]byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 64)
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := serverConn.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buffer[:n]) == "after" {
+			return
+		}
+	}
+}
+
+func TestUDPTransportRetransmitTimeout(t *testing.T) {
+	c := &udpTransportConn{cwnd: 8, ssthresh: 16, rto: 100 * time.Millisecond}
+	sent := time.Now().Add(-time.Second)
+	for seq := uint32(0); seq < 8; seq++ {
+		c.queue = append(c.queue, &udpSegment{seq: seq, data: []byte("x"), sentAt: sent})
+	}
+
+	// 重传超时时只重传第一个未确认的数据段，拥塞窗口退回 1
+	now := time.Now()
+	if packets := c.retransmitTimedOutLocked(now); len(packets) != 1 {
+		t.Fatalf("超时后立即重传 %d 个数据段，期望 1 个", len(packets))
+	}
+	if c.cwnd != 1 || c.inFlightLocked() != 1 {
+		t.Fatalf("拥塞窗口 %v，在途数据段 %d", c.cwnd, c.inFlightLocked())
+	}
+
+	// 下一次检查时尚未再次超时，其余数据段等待拥塞窗口
+	if packets := c.retransmitTimedOutLocked(now.Add(udpTickInterval)); len(packets) != 0 {
+		t.Fatalf("拥塞窗口已满时重传了 %d 个数据段", len(packets))
+	}
+
+	// 拥塞窗口增大后按序重传
+	c.cwnd = 3
+	if packets := c.retransmitLostLocked(now); len(packets) != 2 {
+		t.Fatalf("拥塞窗口为 3 时重传 %d 个数据段，期望 2 个", len(packets))
+	}
+	if c.queue[1].lost || c.queue[2].lost || !c.queue[3].lost {
+		t.Fatal("丢失的数据段没有按序重传")
+	}
+}
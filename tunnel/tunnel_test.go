@@ -0,0 +1,207 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// 端到端测试：隧道连接使用 MemoryTransport，本地端和目标使用回环地址上的套接字
+
+// server 和 client 都实现的启动与关闭方法
+type tunnelRole interface {
+	Serve(ctx context.Context) error
+	Close() error
+}
+
+// startRole 在后台运行隧道角色，测试结束时关闭
+func startRole(t *testing.T, role tunnelRole) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		role.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		role.Close()
+		<-done
+	})
+}
+
+// udpEchoServer 回环地址上的 UDP 回显服务
+func udpEchoServer(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buffer[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// tcpEchoServer 回环地址上的 TCP 回显服务，读到 EOF 后半关闭写方向
+func tcpEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestUDPTunnelMemoryTransport(t *testing.T) {
+	cases := map[string]func(opts *Options){
+		"plain": func(opts *Options) {},
+		"compress+control": func(opts *Options) {
+			opts.Compression.Algorithms = []string{"deflate"}
+			opts.Control.Enabled = true
+		},
+		"fec": func(opts *Options) {
+			opts.FEC = FECOptions{DataShards: 4, ParityShards: 2}
+		},
+	}
+	for name, configure := range cases {
+		t.Run(name, func(t *testing.T) {
+			mem := NewMemoryTransport()
+			listener, err := mem.Listen("server")
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverOpts := Options{Listener: listener, RemoteAddr: udpEchoServer(t), Transport: mem}
+			configure(&serverOpts)
+			startRole(t, NewTunnelServer(serverOpts))
+
+			local, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientOpts := Options{PacketConn: local, RemoteAddr: "server", Transport: mem}
+			configure(&clientOpts)
+			startRole(t, NewTunnelClient(clientOpts))
+
+			app, err := net.Dial("udp", local.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer app.Close()
+
+			buffer := make([]byte, maxPacketSize)
+			for i := 0; i < 20; i++ {
+				payload := bytes.Repeat([]byte(fmt.Sprintf("datagram %d ", i)), i*50+1)
+				if _, err := app.Write(payload); err != nil {
+					t.Fatal(err)
+				}
+				app.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, err := app.Read(buffer)
+				if err != nil {
+					t.Fatalf("第 %d 个数据报没有回显: %v", i, err)
+				}
+				if !bytes.Equal(buffer[:n], payload) {
+					t.Fatalf("第 %d 个数据报回显错误: %d 字节，期望 %d 字节", i, n, len(payload))
+				}
+			}
+		})
+	}
+}
+
+func TestTCPTunnelMemoryTransport(t *testing.T) {
+	cases := map[string]func(opts *Options){
+		"plain": func(opts *Options) {},
+		"compress+control": func(opts *Options) {
+			opts.Compression.Algorithms = []string{"deflate"}
+			opts.Control.Enabled = true
+		},
+		"mux": func(opts *Options) {
+			opts.Mux.Enabled = true
+			opts.Mux.Window = 16 * 1024
+		},
+	}
+	for name, configure := range cases {
+		t.Run(name, func(t *testing.T) {
+			mem := NewMemoryTransport()
+			listener, err := mem.Listen("server")
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverOpts := Options{Listener: listener, RemoteAddr: tcpEchoServer(t), Transport: mem}
+			configure(&serverOpts)
+			startRole(t, NewTCPTunnelServer(serverOpts))
+
+			local, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientOpts := Options{Listener: local, RemoteAddr: "server", Transport: mem}
+			configure(&clientOpts)
+			startRole(t, NewTCPTunnelClient(clientOpts))
+
+			// 同时建立多个本地连接，每个连接发送数据后读取完整的回显
+			errs := make(chan error, 3)
+			for i := 0; i < cap(errs); i++ {
+				go func(seed int64) {
+					errs <- echoOnce(local.Addr().String(), seed)
+				}(int64(i))
+			}
+			for i := 0; i < cap(errs); i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// echoOnce 经过隧道发送随机数据，检查回显的数据与发送的一致。
+// MemoryTransport 的连接不支持半关闭，因此按长度读取回显而不是读到 EOF
+func echoOnce(address string, seed int64) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	payload := make([]byte, 300*1024)
+	rand.New(rand.NewSource(seed)).Read(payload)
+	go conn.Write(payload)
+
+	echoed := make([]byte, len(payload))
+	if n, err := io.ReadFull(conn, echoed); err != nil {
+		return fmt.Errorf("读取回显失败（已收到 %d 字节）: %w", n, err)
+	}
+	if !bytes.Equal(echoed, payload) {
+		return fmt.Errorf("回显的 %d 字节与发送的数据不一致", len(payload))
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ===============================
+// Unix 域套接字模块
+// ===============================
+
+// 地址方案：-local/-remote 使用以下前缀时表示 Unix 域套接字路径
+const (
+	schemeUnix     = "unix://"
+	schemeUnixgram = "unixgram://"
+)
+
+// unixPeerSeq 为没有地址的 Unix 套接字对端生成编号
+var unixPeerSeq atomic.Uint64
+
+// splitAddress 解析带方案前缀的地址，返回网络类型和地址，无前缀时使用 defaultNetwork
+func splitAddress(address, defaultNetwork string) (network, addr string) {
+	switch {
+	case strings.HasPrefix(address, schemeUnix):
+		return "unix", strings.TrimPrefix(address, schemeUnix)
+	case strings.HasPrefix(address, schemeUnixgram):
+		return "unixgram", strings.TrimPrefix(address, schemeUnixgram)
+	default:
+		return defaultNetwork, address
+	}
+}
+
+// peerName 返回连接对端的名称。监听的 Unix 套接字接受的连接在接受时分配编号（见 unixListener），
+// 同一连接的名称总是相同；其他没有地址的连接返回 "unix"
+func peerName(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil || addr.String() == "" || addr.String() == "@" {
+		return "unix"
+	}
+	return addr.String()
+}
+
+// unixPeerAddr 没有地址的 Unix 套接字对端的编号
+type unixPeerAddr string
+
+func (a unixPeerAddr) Network() string { return "unix" }
+func (a unixPeerAddr) String() string  { return string(a) }
+
+// unixPeerConn 对端没有地址的 Unix 流式连接，RemoteAddr 返回接受时分配的编号
+type unixPeerConn struct {
+	*net.UnixConn
+	name unixPeerAddr
+}
+
+// RemoteAddr 返回接受连接时分配的编号
+func (c *unixPeerConn) RemoteAddr() net.Addr {
+	return c.name
+}
+
+// unixListener Unix 流式套接字监听器，为对端没有地址的连接分配编号，日志中可以区分同一连接的前后记录
+type unixListener struct {
+	*net.UnixListener
+}
+
+// Accept 接受连接，对端没有地址时分配编号
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+	if addr := conn.RemoteAddr(); addr == nil || addr.String() == "" || addr.String() == "@" {
+		return &unixPeerConn{UnixConn: conn, name: unixPeerAddr(fmt.Sprintf("unix#%d", unixPeerSeq.Add(1)))}, nil
+	}
+	return conn, nil
+}
+
+// SocketFileOptions 创建的 Unix 套接字文件的权限设置
+type SocketFileOptions struct {
+	// Mode 文件权限，0 表示保持默认
+	Mode os.FileMode
+	// Owner 文件属主，格式为 "用户:组"，可使用名称或数字 ID，空表示保持默认
+	Owner string
+}
+
+// apply 对套接字文件应用权限和属主设置
+func (o SocketFileOptions) apply(path string) error {
+	if o.Mode != 0 {
+		if err := os.Chmod(path, o.Mode); err != nil {
+			return fmt.Errorf("设置套接字文件权限失败: %w", err)
+		}
+	}
+	if o.Owner != "" {
+		uid, gid, err := parseSocketOwner(o.Owner)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("设置套接字文件属主失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyToAddr 地址为 Unix 套接字路径时应用文件设置
+func (o SocketFileOptions) applyToAddr(addr net.Addr) error {
+	unixAddr, ok := addr.(*net.UnixAddr)
+	if !ok || unixAddr.Name == "" || strings.HasPrefix(unixAddr.Name, "@") {
+		return nil
+	}
+	return o.apply(unixAddr.Name)
+}
+
+// parseSocketOwner 解析 "用户:组" 形式的属主，-1 表示不修改
+func parseSocketOwner(owner string) (uid, gid int, err error) {
+	userPart, groupPart, _ := strings.Cut(owner, ":")
+	uid, gid = -1, -1
+
+	if userPart != "" {
+		if uid, err = strconv.Atoi(userPart); err != nil {
+			u, lookupErr := user.Lookup(userPart)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("无效的套接字属主用户 %q: %w", userPart, lookupErr)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupPart != "" {
+		if gid, err = strconv.Atoi(groupPart); err != nil {
+			g, lookupErr := user.LookupGroup(groupPart)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("无效的套接字属主组 %q: %w", groupPart, lookupErr)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// removeStaleSocket 删除无人监听的残留套接字文件，避免上次异常退出后无法重新监听
+func removeStaleSocket(network, path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是套接字文件", path)
+	}
+
+	// 探测是否仍有进程在使用：流式套接字连接成功说明仍在监听，
+	// 数据报套接字在没有进程绑定时 connect 返回 ECONNREFUSED
+	if conn, err := net.DialTimeout(network, path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s 正在被其他进程使用", path)
+	}
+	return os.Remove(path)
+}
+
+// listenUnix 监听 Unix 流式套接字，关闭监听器时自动删除套接字文件
+func listenUnix(path string, fileOpts SocketFileOptions) (net.Listener, error) {
+	if err := removeStaleSocket("unix", path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := fileOpts.apply(path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener}, nil
+}
+
+// unixgramConn 绑定了本端路径的 Unix 数据报套接字，关闭时删除套接字文件（及为其创建的临时目录）
+type unixgramConn struct {
+	*net.UnixConn
+	path string
+	dir  string // dialUnixgram 创建的临时目录，监听的套接字为空
+}
+
+// Close 关闭套接字并删除套接字文件
+func (c *unixgramConn) Close() error {
+	err := c.UnixConn.Close()
+	os.Remove(c.path)
+	if c.dir != "" {
+		os.Remove(c.dir)
+	}
+	return err
+}
+
+// listenUnixgram 监听 Unix 数据报套接字
+func listenUnixgram(path string, fileOpts SocketFileOptions) (net.PacketConn, error) {
+	if err := removeStaleSocket("unixgram", path); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	if err := fileOpts.apply(path); err != nil {
+		conn.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &unixgramConn{UnixConn: conn, path: path}, nil
+}
+
+// dialUnixgram 连接 Unix 数据报目标
+//
+// 数据报套接字必须绑定本端路径才能收到应答。本端路径放在 os.MkdirTemp 新建的目录中（权限 0700），
+// 其他用户无法预先占用或替换该路径，关闭时删除套接字文件和目录。
+func dialUnixgram(path string) (net.Conn, error) {
+	dir, err := os.MkdirTemp("", "udptunnel-")
+	if err != nil {
+		return nil, err
+	}
+	localPath := filepath.Join(dir, "local.sock")
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: localPath, Net: "unixgram"},
+		&net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.Remove(localPath)
+		os.Remove(dir)
+		return nil, err
+	}
+	return &unixgramConn{UnixConn: conn, path: localPath, dir: dir}, nil
+}
+
+// dialStream 按地址方案建立 TCP 或 Unix 流式连接，TCP 连接通过 resolver 按地址族解析并以 Happy Eyeballs 的方式拨号
+func dialStream(ctx context.Context, dialer Dialer, resolver *Resolver, address string, family AddressFamily, delay time.Duration) (net.Conn, error) {
+	network, addr := splitAddress(address, "tcp")
+	if network == "unix" {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return dialFamily(ctx, dialer, resolver, network, addr, family, delay)
+}
+
+// listenStream 按地址方案监听 TCP 或 Unix 流式套接字，TCP 套接字应用 socket 中的选项和地址族
+func listenStream(address string, fileOpts SocketFileOptions, socket SocketOptions, family AddressFamily) (net.Listener, error) {
+	network, addr := splitAddress(address, "tcp")
+	if network == "unix" {
+		return listenUnix(addr, fileOpts)
+	}
+	return socket.listen(network, addr, family)
+}
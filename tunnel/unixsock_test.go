@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveStaleUnixgramSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.sock")
+	conn, err := listenUnixgram(path, SocketFileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 仍有进程绑定的数据报套接字不能删除
+	if err := removeStaleSocket("unixgram", path); err == nil {
+		t.Fatal("正在使用的数据报套接字被删除")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 残留的套接字文件可以删除后重新监听
+	conn.(*unixgramConn).UnixConn.Close()
+	if err := removeStaleSocket("unixgram", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("残留的套接字文件没有被删除")
+	}
+}
+
+func TestDialUnixgramPrivatePath(t *testing.T) {
+	target, err := listenUnixgram(filepath.Join(t.TempDir(), "target.sock"), SocketFileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	conn, err := dialUnixgram(target.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := conn.(*unixgramConn)
+	info, err := os.Stat(local.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Fatalf("本端套接字目录的权限为 %v", info.Mode().Perm())
+	}
+
+	// 关闭后删除本端套接字文件和目录
+	conn.Close()
+	if _, err := os.Stat(local.dir); !os.IsNotExist(err) {
+		t.Fatal("本端套接字目录没有被删除")
+	}
+}
+
+func TestUnixPeerName(t *testing.T) {
+	listener, err := listenUnix(filepath.Join(t.TempDir(), "local.sock"), SocketFileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("unix", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+	}
+	first, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	// 同一连接的名称不变，不同连接的名称不同
+	if peerName(first) != peerName(first) {
+		t.Fatalf("同一连接的名称变化: %s, %s", peerName(first), peerName(first))
+	}
+	if peerName(first) == peerName(second) {
+		t.Fatalf("不同连接的名称相同: %s", peerName(first))
+	}
+}